@@ -0,0 +1,324 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// FileConfig is the structured configuration read from --config-file, in YAML or JSON.
+// It holds the same settings as the command line flags. Scalar values and lists set in
+// the file replace the flag values, while labels and renames are merged over the flags.
+type FileConfig struct {
+	From             FromConfig       `json:"from,omitempty"`
+	To               ToConfig         `json:"to,omitempty"`
+	Interval         *metav1.Duration `json:"interval,omitempty"`
+	EvaluateInterval *metav1.Duration `json:"evaluateInterval,omitempty"`
+	LimitBytes       int64            `json:"limitBytes,omitempty"`
+	Rules            RulesConfig      `json:"rules,omitempty"`
+	Transforms       TransformsConfig `json:"transforms,omitempty"`
+	Limits           LimitsConfig     `json:"limits,omitempty"`
+
+	// Destinations receive a subset of the metrics in addition to the main remote write endpoint.
+	Destinations []DestinationConfig `json:"destinations,omitempty"`
+	// Sources are federated in addition to the from Prometheus, such as the user workload one.
+	Sources []SourceConfig `json:"sources,omitempty"`
+}
+
+// FromConfig is the Prometheus server to federate and query from.
+type FromConfig struct {
+	// Name names the source in the status, such as platform or user-workload.
+	Name      string `json:"name,omitempty"`
+	URL       string `json:"url,omitempty"`
+	QueryURL  string `json:"queryURL,omitempty"`
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+	CAFile    string `json:"caFile,omitempty"`
+}
+
+// ToConfig is the remote write endpoint to push metrics to.
+type ToConfig struct {
+	URL              string      `json:"url,omitempty"`
+	CAFile           string      `json:"caFile,omitempty"`
+	CertFile         string      `json:"certFile,omitempty"`
+	KeyFile          string      `json:"keyFile,omitempty"`
+	NativeHistograms *bool       `json:"nativeHistograms,omitempty"`
+	MinShards        int         `json:"minShards,omitempty"`
+	MaxShards        int         `json:"maxShards,omitempty"`
+	Queue            QueueConfig `json:"queue,omitempty"`
+	// Protocol is the remote write protocol version, 1.0 or 2.0.
+	Protocol string `json:"protocol,omitempty"`
+	// Compression is snappy or zstd.
+	Compression string           `json:"compression,omitempty"`
+	Timeout     *metav1.Duration `json:"timeout,omitempty"`
+	// StalenessMarkers ends the series that disappear with a staleness marker.
+	StalenessMarkers *bool `json:"stalenessMarkers,omitempty"`
+}
+
+// SourceConfig is an additional Prometheus server to federate from, with its own
+// authentication and match rules.
+type SourceConfig struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Token     string   `json:"token,omitempty"`
+	TokenFile string   `json:"tokenFile,omitempty"`
+	CAFile    string   `json:"caFile,omitempty"`
+	Matches   []string `json:"matches"`
+}
+
+// DestinationConfig is an additional remote write endpoint, with its own authentication,
+// selection of metrics and transforms.
+type DestinationConfig struct {
+	Name            string            `json:"name"`
+	URL             string            `json:"url"`
+	CAFile          string            `json:"caFile,omitempty"`
+	CertFile        string            `json:"certFile,omitempty"`
+	KeyFile         string            `json:"keyFile,omitempty"`
+	BearerToken     string            `json:"bearerToken,omitempty"`
+	BearerTokenFile string            `json:"bearerTokenFile,omitempty"`
+	Matches         []string          `json:"matches,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Renames         map[string]string `json:"renames,omitempty"`
+	MinShards       int               `json:"minShards,omitempty"`
+	MaxShards       int               `json:"maxShards,omitempty"`
+}
+
+// QueueConfig is the on-disk buffer of metrics that failed to be sent.
+type QueueConfig struct {
+	Dir      string           `json:"dir,omitempty"`
+	MaxBytes int64            `json:"maxBytes,omitempty"`
+	MaxAge   *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// RulesConfig defines which metrics are collected.
+type RulesConfig struct {
+	Matches        []string        `json:"matches,omitempty"`
+	MatchFile      string          `json:"matchFile,omitempty"`
+	RecordingRules []RecordingRule `json:"recordingRules,omitempty"`
+	CollectRules   []CollectRule   `json:"collectRules,omitempty"`
+	// LocalRecordingRules evaluates the recording rules in the collector.
+	LocalRecordingRules *bool `json:"localRecordingRules,omitempty"`
+	// CollectRuleStateFile persists the state of the collect rules across restarts.
+	CollectRuleStateFile string `json:"collectRuleStateFile,omitempty"`
+}
+
+// RecordingRule generates a new metric from a query expression.
+type RecordingRule struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// CollectRule collects additional metrics while its expression returns results.
+type CollectRule struct {
+	Name    string   `json:"name"`
+	Expr    string   `json:"expr"`
+	For     string   `json:"for,omitempty"`
+	Names   []string `json:"names,omitempty"`
+	Matches []string `json:"matches,omitempty"`
+}
+
+// TransformsConfig defines how metrics are modified before they are sent.
+type TransformsConfig struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Renames     map[string]string `json:"renames,omitempty"`
+	ElideLabels []string          `json:"elideLabels,omitempty"`
+	Anonymize   AnonymizeConfig   `json:"anonymize,omitempty"`
+	// RelabelConfigs are Prometheus relabel_configs, with their usual snake_case fields.
+	RelabelConfigs []json.RawMessage `json:"relabelConfigs,omitempty"`
+}
+
+// LimitsConfig bounds the number of series sent per scrape.
+type LimitsConfig struct {
+	MaxSeries          int            `json:"maxSeries,omitempty"`
+	MaxSeriesPerMetric int            `json:"maxSeriesPerMetric,omitempty"`
+	Metrics            map[string]int `json:"metrics,omitempty"`
+}
+
+// AnonymizeConfig defines the labels whose values are hashed before they are sent.
+type AnonymizeConfig struct {
+	Labels   []string `json:"labels,omitempty"`
+	Salt     string   `json:"salt,omitempty"`
+	SaltFile string   `json:"saltFile,omitempty"`
+	// KeyID and KeyFile anonymize the values with a keyed hash instead of the salted hash.
+	KeyID            string `json:"keyID,omitempty"`
+	KeyFile          string `json:"keyFile,omitempty"`
+	FormatPreserving *bool  `json:"formatPreserving,omitempty"`
+	// Metrics anonymize labels on the metrics matching a selector only.
+	Metrics []AnonymizeMetricConfig `json:"metrics,omitempty"`
+}
+
+// AnonymizeMetricConfig anonymizes labels on the metrics matching a series selector.
+type AnonymizeMetricConfig struct {
+	Match  string   `json:"match"`
+	Labels []string `json:"labels"`
+}
+
+// loadConfigFile reads the file at path, rejecting unknown fields.
+func loadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config-file: %w", err)
+	}
+	cfg := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config-file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// apply sets the file configuration on the options.
+func (c *FileConfig) apply(o *Options) error {
+	setString(&o.From, c.From.URL)
+	setString(&o.FromQuery, c.From.QueryURL)
+	setString(&o.FromToken, c.From.Token)
+	setString(&o.FromTokenFile, c.From.TokenFile)
+	setString(&o.FromCAFile, c.From.CAFile)
+	setString(&o.SourceName, c.From.Name)
+
+	setString(&o.ToUpload, c.To.URL)
+	setString(&o.ToUploadCA, c.To.CAFile)
+	setString(&o.ToUploadCert, c.To.CertFile)
+	setString(&o.ToUploadKey, c.To.KeyFile)
+	if c.To.NativeHistograms != nil {
+		o.NativeHistograms = *c.To.NativeHistograms
+	}
+	if c.To.MinShards > 0 {
+		o.MinShards = c.To.MinShards
+	}
+	if c.To.MaxShards > 0 {
+		o.MaxShards = c.To.MaxShards
+	}
+	setString(&o.RemoteWriteProtocol, c.To.Protocol)
+	setString(&o.RemoteWriteCompression, c.To.Compression)
+	if c.To.Timeout != nil {
+		o.RemoteWriteTimeout = c.To.Timeout.Duration
+	}
+	if c.To.StalenessMarkers != nil {
+		o.StalenessMarkers = *c.To.StalenessMarkers
+	}
+	setString(&o.QueueDir, c.To.Queue.Dir)
+	if c.To.Queue.MaxBytes > 0 {
+		o.QueueMaxBytes = c.To.Queue.MaxBytes
+	}
+	if c.To.Queue.MaxAge != nil {
+		o.QueueMaxAge = c.To.Queue.MaxAge.Duration
+	}
+
+	if c.Interval != nil {
+		o.Interval = c.Interval.Duration
+	}
+	if c.EvaluateInterval != nil {
+		o.EvaluateInterval = c.EvaluateInterval.Duration
+	}
+	if c.LimitBytes > 0 {
+		o.LimitBytes = c.LimitBytes
+	}
+
+	if len(c.Rules.Matches) > 0 {
+		o.Rules = c.Rules.Matches
+	}
+	setString(&o.RulesFile, c.Rules.MatchFile)
+	if len(c.Rules.RecordingRules) > 0 {
+		o.RecordingRules = nil
+		for _, r := range c.Rules.RecordingRules {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return fmt.Errorf("invalid recording rule %s: %w", r.Name, err)
+			}
+			o.RecordingRules = append(o.RecordingRules, string(data))
+		}
+	}
+	if len(c.Rules.CollectRules) > 0 {
+		o.CollectRules = nil
+		for _, r := range c.Rules.CollectRules {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return fmt.Errorf("invalid collect rule %s: %w", r.Name, err)
+			}
+			o.CollectRules = append(o.CollectRules, string(data))
+		}
+	}
+
+	if c.Rules.LocalRecordingRules != nil {
+		o.LocalRecordingRules = *c.Rules.LocalRecordingRules
+	}
+	setString(&o.CollectRuleStateFile, c.Rules.CollectRuleStateFile)
+
+	// Labels and renames are appended after the flags, so the file takes precedence.
+	o.LabelFlag = append(o.LabelFlag, pairs(c.Transforms.Labels)...)
+	o.RenameFlag = append(o.RenameFlag, pairs(c.Transforms.Renames)...)
+	if len(c.Transforms.RelabelConfigs) > 0 {
+		o.RelabelConfigs = nil
+		for _, r := range c.Transforms.RelabelConfigs {
+			o.RelabelConfigs = append(o.RelabelConfigs, string(r))
+		}
+	}
+	if len(c.Transforms.ElideLabels) > 0 {
+		o.ElideLabels = c.Transforms.ElideLabels
+	}
+	if len(c.Transforms.Anonymize.Labels) > 0 {
+		o.AnonymizeLabels = c.Transforms.Anonymize.Labels
+	}
+	setString(&o.AnonymizeSalt, c.Transforms.Anonymize.Salt)
+	setString(&o.AnonymizeSaltFile, c.Transforms.Anonymize.SaltFile)
+	setString(&o.AnonymizeKeyID, c.Transforms.Anonymize.KeyID)
+	setString(&o.AnonymizeKeyFile, c.Transforms.Anonymize.KeyFile)
+	if c.Transforms.Anonymize.FormatPreserving != nil {
+		o.AnonymizeFormatPreserving = *c.Transforms.Anonymize.FormatPreserving
+	}
+	for _, m := range c.Transforms.Anonymize.Metrics {
+		o.AnonymizeMetricLabels = append(o.AnonymizeMetricLabels, m.Match+"="+strings.Join(m.Labels, ","))
+	}
+
+	if c.Limits.MaxSeries > 0 {
+		o.MaxSeries = c.Limits.MaxSeries
+	}
+	if c.Limits.MaxSeriesPerMetric > 0 {
+		o.MaxSeriesPerMetric = c.Limits.MaxSeriesPerMetric
+	}
+	for _, name := range sortedKeys(c.Limits.Metrics) {
+		o.MetricSeriesLimitFlag = append(o.MetricSeriesLimitFlag, fmt.Sprintf("%s=%d", name, c.Limits.Metrics[name]))
+	}
+
+	if len(c.Destinations) > 0 {
+		o.Destinations = c.Destinations
+	}
+	if len(c.Sources) > 0 {
+		o.Sources = c.Sources
+	}
+	return nil
+}
+
+func setString(dst *string, v string) {
+	if len(v) > 0 {
+		*dst = v
+	}
+}
+
+// pairs returns the key=value pairs of m, sorted by key.
+func pairs(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		out = append(out, k+"="+m[k])
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
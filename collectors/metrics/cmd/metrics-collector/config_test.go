@@ -0,0 +1,134 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testConfigFile = `
+from:
+  url: https://prometheus-k8s.openshift-monitoring.svc:9091
+  tokenFile: ../../testdata/token
+  caFile: ../../testdata/service-ca.crt
+to:
+  url: https://observatorium-api/api/metrics/v1/default/api/v1/receive
+  maxShards: 4
+  protocol: "2.0"
+  compression: zstd
+interval: 1m
+rules:
+  matches:
+  - '{__name__="up"}'
+  localRecordingRules: true
+  collectRules:
+  - name: SNOOverCPU
+    expr: node_cpu_utilisation > 0.8
+    for: 2m
+    names:
+    - container_cpu_usage_seconds_total
+transforms:
+  labels:
+    cluster: local-cluster
+  renames:
+    old_metric: new_metric
+  anonymize:
+    keyID: "2024-10"
+    keyFile: /etc/anonymize/key
+    metrics:
+    - match: '{__name__="kube_pod_info",namespace="payments"}'
+      labels: [pod, node]
+sources:
+- name: user-workload
+  url: https://prometheus-user-workload.openshift-user-workload-monitoring.svc:9092
+  tokenFile: ../../testdata/token
+  matches:
+  - '{__name__="app_requests_total"}'
+limits:
+  maxSeriesPerMetric: 1000
+  metrics:
+    kube_pod_labels: 5000
+`
+
+func TestWithConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := &Options{
+		ConfigFile: path,
+		From:       "http://localhost:9090",
+		Interval:   4 * time.Minute,
+		MinShards:  1,
+		MaxShards:  1,
+		LabelFlag:  []string{"cluster=flag-cluster", "clusterID=1234"},
+	}
+	o, err := flags.withConfigFile()
+	if err != nil {
+		t.Fatalf("failed to apply config file: %v", err)
+	}
+
+	if o.From != "https://prometheus-k8s.openshift-monitoring.svc:9091" {
+		t.Errorf("expected from to be set by the file, got %s", o.From)
+	}
+	if o.Interval != time.Minute {
+		t.Errorf("expected interval of 1m, got %s", o.Interval)
+	}
+	if o.MinShards != 1 || o.MaxShards != 4 {
+		t.Errorf("expected shards between 1 and 4, got %d and %d", o.MinShards, o.MaxShards)
+	}
+	if len(o.Sources) != 1 || o.Sources[0].Name != "user-workload" || len(o.Sources[0].Matches) != 1 {
+		t.Errorf("unexpected sources %v", o.Sources)
+	}
+	if o.RemoteWriteProtocol != "2.0" || o.RemoteWriteCompression != "zstd" {
+		t.Errorf("expected remote write 2.0 with zstd, got %s with %s", o.RemoteWriteProtocol, o.RemoteWriteCompression)
+	}
+	if !reflect.DeepEqual(o.Rules, []string{`{__name__="up"}`}) {
+		t.Errorf("unexpected match rules %v", o.Rules)
+	}
+	if !o.LocalRecordingRules {
+		t.Error("expected the recording rules to be evaluated locally")
+	}
+	wantCollectRules := []string{
+		`{"name":"SNOOverCPU","expr":"node_cpu_utilisation > 0.8","for":"2m","names":["container_cpu_usage_seconds_total"]}`,
+	}
+	if !reflect.DeepEqual(o.CollectRules, wantCollectRules) {
+		t.Errorf("expected collect rules %v, got %v", wantCollectRules, o.CollectRules)
+	}
+	wantLabels := []string{"cluster=flag-cluster", "clusterID=1234", "cluster=local-cluster"}
+	if !reflect.DeepEqual(o.LabelFlag, wantLabels) {
+		t.Errorf("expected labels %v, got %v", wantLabels, o.LabelFlag)
+	}
+	wantAnonymize := []string{`{__name__="kube_pod_info",namespace="payments"}=pod,node`}
+	if o.AnonymizeKeyID != "2024-10" || !reflect.DeepEqual(o.AnonymizeMetricLabels, wantAnonymize) {
+		t.Errorf("expected key 2024-10 and metric labels %v, got %s and %v", wantAnonymize, o.AnonymizeKeyID, o.AnonymizeMetricLabels)
+	}
+	if !reflect.DeepEqual(o.RenameFlag, []string{"old_metric=new_metric"}) {
+		t.Errorf("unexpected renames %v", o.RenameFlag)
+	}
+	if o.MaxSeriesPerMetric != 1000 || !reflect.DeepEqual(o.MetricSeriesLimitFlag, []string{"kube_pod_labels=5000"}) {
+		t.Errorf("unexpected series limits %d and %v", o.MaxSeriesPerMetric, o.MetricSeriesLimitFlag)
+	}
+
+	// The flags are left untouched so that a reload applies the file again.
+	if len(flags.LabelFlag) != 2 || flags.From != "http://localhost:9090" {
+		t.Errorf("expected flags to be left untouched, got %+v", flags)
+	}
+}
+
+func TestWithConfigFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("from:\n  unknown: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Options{ConfigFile: path}).withConfigFile(); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+}
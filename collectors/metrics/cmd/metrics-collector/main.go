@@ -5,7 +5,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	stdlog "log"
@@ -13,7 +16,9 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -23,8 +28,10 @@ import (
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/util/uuid"
 
@@ -33,8 +40,12 @@ import (
 	collectorhttp "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/http"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricsclient"
 )
 
+// anonymizeMappingSize is the number of most recently anonymized values kept in the mapping.
+const anonymizeMappingSize = 100000
+
 func main() {
 	opt := &Options{
 		From:             "http://localhost:9090",
@@ -44,6 +55,15 @@ func main() {
 		Interval:         4*time.Minute + 30*time.Second,
 		EvaluateInterval: 30 * time.Second,
 		WorkerNum:        1,
+		MinShards:        1,
+		MaxShards:        1,
+		QueueMaxBytes:    256 * 1024 * 1024,
+		QueueMaxAge:      2 * time.Hour,
+
+		RemoteWriteProtocol:    string(metricsclient.ProtocolV1),
+		RemoteWriteCompression: metricsclient.CompressionSnappy,
+		RemoteWriteTimeout:     5 * time.Second,
+		StalenessMarkers:       true,
 	}
 	cmd := &cobra.Command{
 		Short:         "Federate Prometheus via push",
@@ -85,6 +105,12 @@ func main() {
 		opt.FromCAFile,
 		`A file containing the CA certificate to use to verify the --from URL in
 		 addition to the system roots certificates.`)
+	cmd.Flags().StringVar(
+		&opt.SourceName,
+		"source-name",
+		opt.SourceName,
+		`The name of the --from source in the status. Defaults to user-workload for the user
+		 workload Prometheus and to platform otherwise.`)
 	cmd.Flags().StringVar(
 		&opt.FromTokenFile,
 		"from-token-file",
@@ -125,9 +151,87 @@ func main() {
 		&opt.LimitBytes,
 		"limit-bytes",
 		opt.LimitBytes,
-		"The maxiumum acceptable size of a response returned when scraping Prometheus.")
+		`The maxiumum acceptable size of a response returned when scraping Prometheus. Zero
+		 means no limit: responses are decoded one metric family at a time and only the
+		 metrics kept by the match rules are held in memory.`)
+	cmd.Flags().BoolVar(
+		&opt.NativeHistograms,
+		"native-histograms",
+		opt.NativeHistograms,
+		`Send histograms with native buckets as Prometheus native histograms instead of
+		 classic _bucket, _sum and _count series. The --to-upload endpoint must accept them.`)
+	cmd.Flags().StringVar(
+		&opt.RemoteWriteProtocol,
+		"remote-write-protocol",
+		opt.RemoteWriteProtocol,
+		`The remote write protocol version, 1.0 or 2.0. Remote write 2.0 sends the metadata of
+		 every series and falls back to 1.0 if the endpoint does not support it.`)
+	cmd.Flags().StringVar(
+		&opt.RemoteWriteCompression,
+		"remote-write-compression",
+		opt.RemoteWriteCompression,
+		"The compression of remote write requests, snappy or zstd. zstd falls back to snappy if the endpoint does not support it.")
+	cmd.Flags().DurationVar(
+		&opt.RemoteWriteTimeout,
+		"remote-write-timeout",
+		opt.RemoteWriteTimeout,
+		"The timeout of a single remote write request.")
+	cmd.Flags().BoolVar(
+		&opt.StalenessMarkers,
+		"staleness-markers",
+		opt.StalenessMarkers,
+		`Write a staleness marker for the series that disappear between two federations, so that
+		 queries on the hub stop returning them right away instead of for the lookback delta.`)
 
-	// TODO: more complex input definition, such as a JSON struct
+	cmd.Flags().IntVar(
+		&opt.MinShards,
+		"min-shards",
+		opt.MinShards,
+		"The minimum number of shards concurrently sending metrics to the --to-upload endpoint.")
+	cmd.Flags().IntVar(
+		&opt.MaxShards,
+		"max-shards",
+		opt.MaxShards,
+		`The maximum number of shards concurrently sending metrics to the --to-upload endpoint.
+		 The number of shards is adapted to the observed throughput between both bounds.`)
+	cmd.Flags().IntVar(
+		&opt.MaxSeries,
+		"max-series",
+		opt.MaxSeries,
+		"The maximum number of series sent per scrape. Zero means no limit.")
+	cmd.Flags().IntVar(
+		&opt.MaxSeriesPerMetric,
+		"max-series-per-metric",
+		opt.MaxSeriesPerMetric,
+		"The maximum number of series sent per metric name and scrape. Zero means no limit.")
+	cmd.Flags().StringSliceVar(
+		&opt.MetricSeriesLimitFlag,
+		"metric-series-limit",
+		opt.MetricSeriesLimitFlag,
+		"The maximum number of series of a metric, overriding --max-series-per-metric, in NAME=LIMIT form.")
+	cmd.Flags().StringVar(
+		&opt.QueueDir,
+		"queue-dir",
+		opt.QueueDir,
+		`A directory, such as an emptyDir or a persistent volume, where metrics that failed
+		 to be sent are buffered and replayed once the --to-upload endpoint recovers.`)
+	cmd.Flags().Int64Var(
+		&opt.QueueMaxBytes,
+		"queue-max-bytes",
+		opt.QueueMaxBytes,
+		"The maximum size of the --queue-dir buffer. The oldest metrics are dropped first.")
+	cmd.Flags().DurationVar(
+		&opt.QueueMaxAge,
+		"queue-max-age",
+		opt.QueueMaxAge,
+		"The maximum age of buffered metrics. Older metrics are dropped instead of replayed.")
+
+	cmd.Flags().StringVar(
+		&opt.ConfigFile,
+		"config-file",
+		opt.ConfigFile,
+		`A YAML or JSON file holding the collector configuration. Values set in the file
+		 take precedence over the flags. The file is read again on SIGHUP and /-/reload.`)
 	cmd.Flags().StringArrayVar(
 		&opt.Rules,
 		"match",
@@ -148,6 +252,13 @@ func main() {
 		"recording-file",
 		opt.RulesFile,
 		"A file containing recording rules.")
+	cmd.Flags().BoolVar(
+		&opt.LocalRecordingRules,
+		"local-recording-rules",
+		opt.LocalRecordingRules,
+		`Evaluate the recording rules over the federated metrics in the collector instead of querying
+		 Prometheus for every rule. The rules only select the federated series. The families they
+		 select are held in memory untransformed until the rules are evaluated.`)
 	cmd.Flags().StringArrayVar(
 		&opt.CollectRules,
 		"collectrule",
@@ -158,6 +269,12 @@ func main() {
 		"collect-file",
 		opt.RecordingRulesFile,
 		"A file containing collect rules.")
+	cmd.Flags().StringVar(
+		&opt.CollectRuleStateFile,
+		"collectrule-state-file",
+		opt.CollectRuleStateFile,
+		`A file, on a volume that outlives the container, where the pending and firing collect
+		 rules are saved so that they are restored on restart.`)
 
 	cmd.Flags().StringSliceVar(
 		&opt.LabelFlag,
@@ -169,6 +286,12 @@ func main() {
 		"rename",
 		opt.RenameFlag,
 		"Rename metrics before sending by specifying OLD=NEW name pairs.")
+	cmd.Flags().StringArrayVar(
+		&opt.RelabelConfigs,
+		"relabel-config",
+		opt.RelabelConfigs,
+		`A Prometheus relabel_config applied to each outgoing metric, as a JSON or YAML object,
+		 e.g. {"source_labels":["namespace"],"regex":"kube-.*","action":"drop"}. Can be repeated.`)
 	cmd.Flags().StringArrayVar(
 		&opt.ElideLabels,
 		"elide-label",
@@ -191,6 +314,42 @@ func main() {
 		"anonymize-salt-file",
 		opt.AnonymizeSaltFile,
 		"A file containing a secret and unguessable value used to anonymize the input data.")
+	cmd.Flags().StringVar(
+		&opt.AnonymizeKeyID,
+		"anonymize-key-id",
+		opt.AnonymizeKeyID,
+		"The ID of the --anonymize-key-file key, recorded with the anonymized values so that the key can be rotated.")
+	cmd.Flags().StringVar(
+		&opt.AnonymizeKeyFile,
+		"anonymize-key-file",
+		opt.AnonymizeKeyFile,
+		"A file containing a secret key to anonymize the label values with HMAC-SHA256, instead of the salted hash.")
+	cmd.Flags().StringArrayVar(
+		&opt.AnonymizeMetricLabels,
+		"anonymize-metric-labels",
+		opt.AnonymizeMetricLabels,
+		`Anonymize the values of labels on the metrics matching a series selector, in the form
+		 SELECTOR=LABEL[,LABEL...]. Requires --anonymize-key-file.`)
+	cmd.Flags().BoolVar(
+		&opt.AnonymizeFormatPreserving,
+		"anonymize-format-preserving",
+		opt.AnonymizeFormatPreserving,
+		`Replace the letters and digits of the anonymized values with letters and digits, keeping their
+		 length and the other characters. These values do not carry the key ID and are not unique,
+		 short values collide. Requires --anonymize-key-file.`)
+	cmd.Flags().StringVar(
+		&opt.AnonymizeMappingTokenFile,
+		"anonymize-mapping-token-file",
+		opt.AnonymizeMappingTokenFile,
+		`A file containing a token that allows to read the original values of the anonymized values
+		 on /debug/anonymization, with the Authorization header. The mapping is disabled if unset.`)
+	cmd.Flags().StringVar(
+		&opt.ExplainTokenFile,
+		"explain-token-file",
+		opt.ExplainTokenFile,
+		`A file containing a token that allows to explain how the series of the last federation are
+		 transformed on /debug/explain, with the Authorization header. The federate responses of the
+		 last federation are kept in memory for it. The endpoint is disabled if unset.`)
 
 	cmd.Flags().BoolVarP(
 		&opt.Verbose,
@@ -217,6 +376,12 @@ func main() {
 		"simulated-timeseries-file",
 		opt.SimulatedTimeseriesFile,
 		"A file containing the sample of timeseries.")
+	cmd.Flags().StringVar(
+		&opt.SimulatedScenarioFile,
+		"simulated-scenario-file",
+		opt.SimulatedScenarioFile,
+		`A file describing the simulated metrics over time: their series, churn, label growth, counters
+		 and histograms. The throughput and latency of their writes are served on /debug/simulation.`)
 
 	l := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	lvl, err := cmd.Flags().GetString("log-level")
@@ -237,9 +402,16 @@ func main() {
 }
 
 type Options struct {
-	Listen     string
-	LimitBytes int64
-	Verbose    bool
+	ConfigFile       string
+	Listen           string
+	LimitBytes       int64
+	NativeHistograms bool
+	Verbose          bool
+
+	RemoteWriteProtocol    string
+	RemoteWriteCompression string
+	RemoteWriteTimeout     time.Duration
+	StalenessMarkers       bool
 
 	From          string
 	FromQuery     string
@@ -254,12 +426,23 @@ type Options struct {
 	RenameFlag []string
 	Renames    map[string]string
 
+	RelabelConfigs []string
+
 	ElideLabels []string
 
 	AnonymizeLabels   []string
 	AnonymizeSalt     string
 	AnonymizeSaltFile string
 
+	AnonymizeKeyID            string
+	AnonymizeKeyFile          string
+	AnonymizeMetricLabels     []string
+	AnonymizeFormatPreserving bool
+	AnonymizeMappingTokenFile string
+	// AnonymizeMapping records the anonymized values when the mapping is enabled.
+	AnonymizeMapping *metricfamily.AnonymizeMapping
+	ExplainTokenFile string
+
 	Rules              []string
 	RulesFile          string
 	RecordingRules     []string
@@ -267,12 +450,34 @@ type Options struct {
 	CollectRules       []string
 	CollectRulesFile   string
 
+	LocalRecordingRules  bool
+	CollectRuleStateFile string
+
 	LabelFlag []string
 	Labels    map[string]string
 
 	Interval         time.Duration
 	EvaluateInterval time.Duration
 
+	MinShards int
+	MaxShards int
+
+	MaxSeries             int
+	MaxSeriesPerMetric    int
+	MetricSeriesLimitFlag []string
+	MetricSeriesLimits    map[string]int
+
+	QueueDir      string
+	QueueMaxBytes int64
+	QueueMaxAge   time.Duration
+
+	// Destinations are only set from the config file.
+	Destinations []DestinationConfig
+
+	SourceName string
+	// Sources are only set from the config file.
+	Sources []SourceConfig
+
 	LogLevel string
 	Logger   log.Logger
 
@@ -281,6 +486,7 @@ type Options struct {
 
 	// simulation file
 	SimulatedTimeseriesFile string
+	SimulatedScenarioFile   string
 
 	// how many threads are running
 	// for production, it is always 1
@@ -302,6 +508,16 @@ func (o *Options) Run() error {
 	// Some packages still use default Register. Replace to have those metrics.
 	prometheus.DefaultRegisterer = metricsReg
 
+	flags := o
+	if len(flags.AnonymizeMappingTokenFile) > 0 {
+		// The mapping outlives the reconfigurations, so that the values anonymized with a
+		// rotated key can still be looked up.
+		flags.AnonymizeMapping = metricfamily.NewAnonymizeMapping(anonymizeMappingSize)
+	}
+	o, err := flags.withConfigFile()
+	if err != nil {
+		return err
+	}
 	err, cfg := initConfig(o)
 	if err != nil {
 		return err
@@ -314,6 +530,70 @@ func (o *Options) Run() error {
 		return fmt.Errorf("failed to configure metrics collector: %w", err)
 	}
 
+	// The evaluator only runs when collect rules are configured at startup.
+	var evaluator *collectrule.Evaluator
+	if len(cfg.CollectRules) != 0 {
+		evaluator, err = collectrule.New(*cfg)
+		if err != nil {
+			return fmt.Errorf("failed to configure collect rule evaluator: %w", err)
+		}
+	}
+
+	reloadSuccess := promauto.With(metricsReg).NewGauge(prometheus.GaugeOpts{
+		Name: "config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	reloadSuccessTime := promauto.With(metricsReg).NewGauge(prometheus.GaugeOpts{
+		Name: "config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+	reloadSuccess.Set(1)
+	reloadSuccessTime.SetToCurrentTime()
+
+	// reconfigure builds the configuration from the flags and the config file again, and
+	// applies it to the worker and the evaluator. Their clients are recreated, so that rotated
+	// certificates, tokens and rules files are read again.
+	reconfigure := func() error {
+		o, err := flags.withConfigFile()
+		if err != nil {
+			return err
+		}
+		err, cfg := initConfig(o)
+		if err != nil {
+			return err
+		}
+		cfg.Metrics = metrics
+		// Validate the collect rules first, the worker is reconfigured only with a
+		// configuration the evaluator accepts too.
+		if evaluator == nil && len(cfg.CollectRules) != 0 {
+			return errors.New("collect rules must be configured at startup, restart the collector to evaluate them")
+		}
+		if err := collectrule.Validate(*cfg); err != nil {
+			return fmt.Errorf("failed to reconfigure: %w", err)
+		}
+		if err := worker.Reconfigure(*cfg); err != nil {
+			return err
+		}
+		if evaluator == nil {
+			return nil
+		}
+		return evaluator.Reconfigure(*cfg)
+	}
+
+	// reload applies the configuration sources to the running worker.
+	// An invalid configuration is rejected and the worker keeps its current configuration.
+	reload := func() error {
+		if err := reconfigure(); err != nil {
+			reloadSuccess.Set(0)
+			logger.Log(o.Logger, logger.Error, "msg", "failed to reload config", "err", err)
+			return err
+		}
+		reloadSuccess.Set(1)
+		reloadSuccessTime.SetToCurrentTime()
+		logger.Log(o.Logger, logger.Info, "msg", "config reloaded")
+		return nil
+	}
+
 	logger.Log(
 		o.Logger, logger.Info,
 		"msg", "starting metrics collector",
@@ -341,10 +621,7 @@ func (o *Options) Run() error {
 			for {
 				select {
 				case <-hup:
-					if err := worker.Reconfigure(*cfg); err != nil {
-						logger.Log(o.Logger, logger.Error, "msg", "failed to reload config", "err", err)
-						return err
-					}
+					_ = reload()
 				case <-cancel:
 					return nil
 				}
@@ -359,10 +636,21 @@ func (o *Options) Run() error {
 		collectorhttp.DebugRoutes(handlers)
 		collectorhttp.HealthRoutes(handlers)
 		collectorhttp.MetricRoutes(handlers, metricsReg)
-		collectorhttp.ReloadRoutes(handlers, func() error {
-			return worker.Reconfigure(*cfg)
-		})
+		collectorhttp.ReloadRoutes(handlers, reload)
 		handlers.Handle("/federate", serveLastMetrics(o.Logger, worker))
+		handlers.Handle("/debug/cardinality", serveCardinality(o.Logger, worker))
+		if evaluator != nil {
+			handlers.Handle("/debug/collectrules", serveCollectRules(o.Logger, evaluator))
+		}
+		handlers.Handle("/debug/simulation", serveSimulationReport(o.Logger, worker))
+		if len(o.ExplainTokenFile) > 0 {
+			handlers.Handle("/debug/explain", requireToken(o.Logger, o.ExplainTokenFile, "explanation",
+				serveExplain(o.Logger, worker, evaluator)))
+		}
+		if o.AnonymizeMapping != nil {
+			handlers.Handle("/debug/anonymization", requireToken(o.Logger, o.AnonymizeMappingTokenFile, "anonymization mapping",
+				serveAnonymizeMapping(o.Logger, o.AnonymizeMapping)))
+		}
 		s := http.Server{
 			Addr:              o.Listen,
 			Handler:           handlers,
@@ -393,11 +681,8 @@ func (o *Options) Run() error {
 		return err
 	}
 
-	if len(o.CollectRules) != 0 {
-		evaluator, err := collectrule.New(*cfg)
-		if err != nil {
-			return fmt.Errorf("failed to configure collect rule evaluator: %w", err)
-		}
+	if evaluator != nil {
+		// Execute the evaluator's `Run` func.
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
 			evaluator.Run(ctx)
@@ -410,6 +695,31 @@ func (o *Options) Run() error {
 	return g.Run()
 }
 
+// withConfigFile returns a copy of the options with the config file applied.
+// The options themselves are left untouched, so the file can be applied again on reload.
+func (o *Options) withConfigFile() (*Options, error) {
+	opt := *o
+	opt.LabelFlag = append([]string(nil), o.LabelFlag...)
+	opt.RenameFlag = append([]string(nil), o.RenameFlag...)
+	opt.MetricSeriesLimitFlag = append([]string(nil), o.MetricSeriesLimitFlag...)
+	opt.AnonymizeMetricLabels = append([]string(nil), o.AnonymizeMetricLabels...)
+	opt.Labels = nil
+	opt.Renames = nil
+	opt.MetricSeriesLimits = nil
+	if len(o.ConfigFile) == 0 {
+		return &opt, nil
+	}
+
+	fileCfg, err := loadConfigFile(o.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileCfg.apply(&opt); err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
 func runMultiWorkers(o *Options, cfg *forwarder.Config) error {
 	for i := 1; i < int(o.WorkerNum); i++ {
 		opt := &Options{
@@ -424,9 +734,11 @@ func runMultiWorkers(o *Options, cfg *forwarder.Config) error {
 			Rules:                   o.Rules,
 			RenameFlag:              o.RenameFlag,
 			RecordingRules:          o.RecordingRules,
+			LocalRecordingRules:     o.LocalRecordingRules,
 			Interval:                o.Interval,
 			Labels:                  map[string]string{},
 			SimulatedTimeseriesFile: o.SimulatedTimeseriesFile,
+			SimulatedScenarioFile:   o.SimulatedScenarioFile,
 			Logger:                  o.Logger,
 		}
 		for _, flag := range o.LabelFlag {
@@ -493,6 +805,34 @@ func initConfig(o *Options) (error, *forwarder.Config) {
 		o.Renames[values[0]] = values[1]
 	}
 
+	for _, flag := range o.MetricSeriesLimitFlag {
+		values := strings.SplitN(flag, "=", 2)
+		if len(values) != 2 {
+			return fmt.Errorf("--metric-series-limit must be of the form NAME=LIMIT: %s", flag), nil
+		}
+		limit, err := strconv.Atoi(values[1])
+		if err != nil || limit < 0 {
+			return fmt.Errorf("--metric-series-limit must have a positive limit: %s", flag), nil
+		}
+		if o.MetricSeriesLimits == nil {
+			o.MetricSeriesLimits = make(map[string]int)
+		}
+		o.MetricSeriesLimits[values[0]] = limit
+	}
+
+	var anonymizeSelectors []metricfamily.AnonymizeSelector
+	for _, flag := range o.AnonymizeMetricLabels {
+		// The selector may contain =, the labels may not.
+		i := strings.LastIndex(flag, "=")
+		if i <= 0 || i == len(flag)-1 {
+			return fmt.Errorf("--anonymize-metric-labels must be of the form SELECTOR=LABEL[,LABEL...]: %s", flag), nil
+		}
+		anonymizeSelectors = append(anonymizeSelectors, metricfamily.AnonymizeSelector{
+			Match:  flag[:i],
+			Labels: strings.Split(flag[i+1:], ","),
+		})
+	}
+
 	from, err := url.Parse(o.From)
 	if err != nil {
 		return fmt.Errorf("--from is not a valid URL: %w", err), nil
@@ -537,6 +877,14 @@ func initConfig(o *Options) (error, *forwarder.Config) {
 		})
 	}
 
+	if len(o.RelabelConfigs) > 0 {
+		relabelConfigs, err := metricfamily.ParseRelabelConfigs(o.RelabelConfigs)
+		if err != nil {
+			return fmt.Errorf("--relabel-config is not valid: %w", err), nil
+		}
+		transformer.With(metricfamily.NewRelabel(relabelConfigs))
+	}
+
 	if len(o.ElideLabels) == 0 {
 		// While forwarding alerts from managed clusters to ACM alert manager on the hub,
 		// prometheus on managed clusters is configured to add a "managed_cluster" label
@@ -555,6 +903,15 @@ func initConfig(o *Options) (error, *forwarder.Config) {
 	transformer.With(metricfamily.TransformerFunc(metricfamily.PackMetrics))
 	transformer.With(metricfamily.TransformerFunc(metricfamily.SortMetrics))
 
+	destinations, err := initDestinations(o.Destinations)
+	if err != nil {
+		return err, nil
+	}
+	sources, err := initSources(o.Sources)
+	if err != nil {
+		return err, nil
+	}
+
 	isHypershift, err := metricfamily.CheckCRDExist(o.Logger)
 	if err != nil {
 		return err, nil
@@ -587,15 +944,269 @@ func initConfig(o *Options) (error, *forwarder.Config) {
 		Interval:          o.Interval,
 		EvaluateInterval:  o.EvaluateInterval,
 		LimitBytes:        o.LimitBytes,
+		NativeHistograms:  o.NativeHistograms,
+		MinShards:         o.MinShards,
+		MaxShards:         o.MaxShards,
 		Rules:             o.Rules,
 		RulesFile:         o.RulesFile,
 		RecordingRules:    o.RecordingRules,
 		CollectRules:      o.CollectRules,
 		Transformer:       transformer,
 
+		LocalRecordingRules:  o.LocalRecordingRules,
+		CollectRuleStateFile: o.CollectRuleStateFile,
+
+		AnonymizeKeyID:            o.AnonymizeKeyID,
+		AnonymizeKeyFile:          o.AnonymizeKeyFile,
+		AnonymizeSelectors:        anonymizeSelectors,
+		AnonymizeFormatPreserving: o.AnonymizeFormatPreserving,
+		AnonymizeMapping:          o.AnonymizeMapping,
+
+		RemoteWriteProtocol:    metricsclient.Protocol(o.RemoteWriteProtocol),
+		RemoteWriteCompression: o.RemoteWriteCompression,
+		RemoteWriteTimeout:     o.RemoteWriteTimeout,
+		StalenessMarkers:       o.StalenessMarkers,
+		Explain:                len(o.ExplainTokenFile) > 0,
+
+		QueueDir:      o.QueueDir,
+		QueueMaxBytes: o.QueueMaxBytes,
+		QueueMaxAge:   o.QueueMaxAge,
+
+		Destinations: destinations,
+		SourceName:   o.SourceName,
+		Sources:      sources,
+
+		MaxSeries:          o.MaxSeries,
+		MaxSeriesPerMetric: o.MaxSeriesPerMetric,
+		MetricSeriesLimits: o.MetricSeriesLimits,
+
 		Logger:                  o.Logger,
 		SimulatedTimeseriesFile: o.SimulatedTimeseriesFile,
+		SimulatedScenarioFile:   o.SimulatedScenarioFile,
+	}
+}
+
+// serveCardinality lists the metrics with the most series in the last scrape.
+// The number of metrics is set by the limit parameter and defaults to 10.
+func serveCardinality(l log.Logger, worker *forwarder.Worker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 10
+		if v := req.URL.Query().Get("limit"); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "limit must be a number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(worker.TopCardinality(limit)); err != nil {
+			logger.Log(l, logger.Error, "msg", "unable to write cardinality", "err", err)
+		}
+	})
+}
+
+// serveExplain explains which match rules and collect rules federate the series selected by
+// the match parameter, a metric name or a series selector, and how they are transformed.
+// The transformers are dry-run over the series of the last federation, nothing is retrieved
+// nor sent.
+func serveExplain(l log.Logger, worker *forwarder.Worker, evaluator *collectrule.Evaluator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		selector := req.URL.Query().Get("match")
+		if len(selector) == 0 {
+			http.Error(w, "match must be a metric name or a series selector", http.StatusBadRequest)
+			return
+		}
+		if _, err := parser.ParseMetricSelector(selector); err != nil {
+			http.Error(w, fmt.Sprintf("match is not a valid series selector: %v", err), http.StatusBadRequest)
+			return
+		}
+		var enabled map[string][]string
+		if evaluator != nil {
+			enabled = evaluator.EnabledMatches()
+		}
+		series, err := worker.Explain(selector, enabled)
+		if err != nil {
+			logger.Log(l, logger.Warn, "msg", "unable to explain metrics", "match", selector, "err", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if evaluator != nil {
+			collected, err := evaluator.Explain(selector)
+			if err != nil {
+				logger.Log(l, logger.Warn, "msg", "unable to explain metrics of the collect rules", "match", selector, "err", err)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			series = append(series, collected...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(series); err != nil {
+			logger.Log(l, logger.Error, "msg", "unable to write explanation", "err", err)
+		}
+	})
+}
+
+// requireToken serves the requests with next when they bear the token of tokenFile, that is
+// read on every request so that it can be rotated. what names the data of next in the logs.
+func requireToken(l log.Logger, tokenFile, what string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := os.ReadFile(filepath.Clean(tokenFile))
+		if err != nil {
+			logger.Log(l, logger.Error, "msg", "unable to read token file", "file", tokenFile, "err", err)
+			http.Error(w, fmt.Sprintf("the %s is unavailable", what), http.StatusInternalServerError)
+			return
+		}
+		token := bytes.TrimSpace(data)
+		bearer := []byte(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		if len(token) == 0 || subtle.ConstantTimeCompare(token, bearer) != 1 {
+			logger.Log(l, logger.Warn, "msg", "unauthorized access to the "+what, "remote", req.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logger.Log(l, logger.Info, "msg", what+" accessed", "remote", req.RemoteAddr, "query", req.URL.RawQuery)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// serveAnonymizeMapping returns the original values of the anonymized value set by the value
+// parameter, optionally of the keyID and label parameters, or all the recorded values.
+func serveAnonymizeMapping(l log.Logger, mapping *metricfamily.AnonymizeMapping) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body interface{} = mapping.Values()
+		query := req.URL.Query()
+		if v := query.Get("value"); len(v) > 0 {
+			values := mapping.Lookup(v, query.Get("keyID"), query.Get("label"))
+			if len(values) == 0 {
+				http.Error(w, "unknown anonymized value", http.StatusNotFound)
+				return
+			}
+			body = values
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			logger.Log(l, logger.Error, "msg", "unable to write anonymization mapping", "err", err)
+		}
+	})
+}
+
+// serveSimulationReport returns the throughput and latency of the writes of the simulated scenario.
+func serveSimulationReport(l log.Logger, worker *forwarder.Worker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		report := worker.SimulationReport()
+		if report == nil {
+			http.Error(w, "no scenario is simulated", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			logger.Log(l, logger.Error, "msg", "unable to write simulation report", "err", err)
+		}
+	})
+}
+
+// serveCollectRules lists the pending and firing instances of the collect rules.
+func serveCollectRules(l log.Logger, evaluator *collectrule.Evaluator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(evaluator.Status()); err != nil {
+			logger.Log(l, logger.Error, "msg", "unable to write collect rules", "err", err)
+		}
+	})
+}
+
+// initDestinations validates the additional destinations and builds their transformers.
+func initDestinations(configs []DestinationConfig) ([]forwarder.Destination, error) {
+	var destinations []forwarder.Destination
+	names := map[string]bool{}
+	for _, d := range configs {
+		if err := forwarder.ValidateDestinationName(d.Name); err != nil {
+			return nil, err
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("duplicate destination %s", d.Name)
+		}
+		names[d.Name] = true
+		if len(d.URL) == 0 {
+			return nil, fmt.Errorf("destination %s must have a url", d.Name)
+		}
+		u, err := url.Parse(d.URL)
+		if err != nil {
+			return nil, fmt.Errorf("url of destination %s is not valid: %w", d.Name, err)
+		}
+
+		var transformer metricfamily.MultiTransformer
+		if len(d.Labels) > 0 {
+			labels := d.Labels
+			transformer.WithFunc(func() metricfamily.Transformer {
+				return metricfamily.NewLabel(labels, nil)
+			})
+		}
+		if len(d.Renames) > 0 {
+			transformer.With(metricfamily.RenameMetrics{Names: d.Renames})
+		}
+
+		destinations = append(destinations, forwarder.Destination{
+			Name:            d.Name,
+			URL:             u,
+			CAFile:          d.CAFile,
+			CertFile:        d.CertFile,
+			KeyFile:         d.KeyFile,
+			BearerToken:     d.BearerToken,
+			BearerTokenFile: d.BearerTokenFile,
+			Matches:         d.Matches,
+			Transformer:     transformer,
+			MinShards:       d.MinShards,
+			MaxShards:       d.MaxShards,
+		})
+	}
+	return destinations, nil
+}
+
+// initSources validates the additional sources to federate from.
+func initSources(configs []SourceConfig) ([]forwarder.Source, error) {
+	var sources []forwarder.Source
+	for _, s := range configs {
+		if len(s.URL) == 0 {
+			return nil, fmt.Errorf("source %s must have a url", s.Name)
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return nil, fmt.Errorf("url of source %s is not valid: %w", s.Name, err)
+		}
+		sources = append(sources, forwarder.Source{
+			Name:            s.Name,
+			URL:             u,
+			CAFile:          s.CAFile,
+			BearerToken:     s.Token,
+			BearerTokenFile: s.TokenFile,
+			Matches:         s.Matches,
+		})
 	}
+	return sources, nil
 }
 
 // serveLastMetrics retrieves the last set of metrics served.
@@ -6,7 +6,10 @@ package main
 
 import (
 	stdlog "log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -54,3 +57,38 @@ func TestMultiWorkers(t *testing.T) {
 	time.Sleep(1 * time.Second)
 
 }
+
+func TestInitDestinations(t *testing.T) {
+	if _, err := initDestinations([]DestinationConfig{{Name: "longterm", URL: "https://example.com"}}); err != nil {
+		t.Errorf("failed to init destinations: %v", err)
+	}
+	for _, configs := range [][]DestinationConfig{
+		{{Name: "../longterm", URL: "https://example.com"}},
+		{{Name: "longterm", URL: "https://example.com"}, {Name: "longterm", URL: "https://example.org"}},
+	} {
+		if _, err := initDestinations(configs); err == nil {
+			t.Errorf("expected destinations %v to be rejected", configs)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	handler := requireToken(log.NewNopLogger(), tokenFile, "explanation",
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+	for token, code := range map[string]int{"": http.StatusUnauthorized, "other": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/explain?match=up", nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("expected status %d with token %q, got %d", code, token, rec.Code)
+		}
+	}
+}
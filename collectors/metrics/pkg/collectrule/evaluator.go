@@ -7,12 +7,14 @@ package collectrule
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"regexp"
-	"strings"
+	"reflect"
+	"sort"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/go-kit/log"
@@ -22,22 +24,13 @@ import (
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/forwarder"
 	rlogger "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricsclient"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/status"
 )
 
 const (
 	expireDuration = 15 * time.Minute
 )
 
-var (
-	config         forwarder.Config
-	forwardWorker  *forwarder.Worker
-	cancel         context.CancelFunc
-	rules          = []CollectRule{}
-	pendingRules   = map[string]*EvaluatedRule{}
-	firingRules    = map[string]*EvaluatedRule{}
-	enabledMatches = map[uint64][]string{}
-)
-
 type EvaluatedRule struct {
 	triggerTime map[uint64]*time.Time
 	resolveTime map[uint64]*time.Time
@@ -49,6 +42,11 @@ type CollectRule struct {
 	Names       []string `json:"names"`
 	Matches     []string `json:"matches"`
 	Duration    time.Duration
+
+	// nameTemplates and matchTemplates are the parsed Names and Matches, rendered with
+	// the labels of the series that fire the rule.
+	nameTemplates  []*template.Template
+	matchTemplates []*template.Template
 }
 
 type Evaluator struct {
@@ -57,6 +55,24 @@ type Evaluator struct {
 
 	interval     time.Duration
 	collectRules []string
+	stateFile    string
+
+	// config configures the forwarder collecting the metrics enabled by the fired rules.
+	config        forwarder.Config
+	forwardWorker *forwarder.Worker
+	cancel        context.CancelFunc
+
+	rules          []CollectRule
+	pendingRules   map[string]*EvaluatedRule
+	firingRules    map[string]*EvaluatedRule
+	enabledMatches map[uint64][]string
+	// seriesLabels holds the labels of the pending and firing series, by hash.
+	seriesLabels map[uint64]labels.Labels
+
+	metrics *forwarder.CollectRuleMetrics
+	status  *status.StatusReport
+	// reportedRules are the firing rules last reported in the ObservabilityAddon status.
+	reportedRules []string
 
 	lock        sync.Mutex
 	reconfigure chan struct{}
@@ -64,8 +80,91 @@ type Evaluator struct {
 	logger log.Logger
 }
 
+// New creates an Evaluator of the collect rules of cfg. When cfg.CollectRuleStateFile
+// holds the state saved by a previous evaluator, its pending and firing rules are restored.
 func New(cfg forwarder.Config) (*Evaluator, error) {
-	config = forwarder.Config{
+	e := newEvaluator(log.With(cfg.Logger, "component", "collectrule/evaluator"))
+	s, err := status.New(e.logger)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create StatusReport: %w", err)
+	}
+	e.status = s
+	if len(cfg.CollectRuleStateFile) > 0 {
+		if err := e.loadState(cfg.CollectRuleStateFile); err != nil {
+			rlogger.Log(e.logger, rlogger.Warn, "msg", "failed to restore collect rule state", "err", err)
+		}
+	}
+	if err := e.configure(cfg); err != nil {
+		return nil, err
+	}
+	e.config.Rules = e.getMatches()
+	return e, nil
+}
+
+func newEvaluator(logger log.Logger) *Evaluator {
+	return &Evaluator{
+		rules:          []CollectRule{},
+		pendingRules:   map[string]*EvaluatedRule{},
+		firingRules:    map[string]*EvaluatedRule{},
+		enabledMatches: map[uint64][]string{},
+		seriesLabels:   map[uint64]labels.Labels{},
+		reconfigure:    make(chan struct{}),
+		logger:         logger,
+	}
+}
+
+// Validate returns an error when the collect rules of cfg or the client evaluating them are
+// invalid, so that cfg can be checked before any part of it is applied.
+func Validate(cfg forwarder.Config) error {
+	_, _, err := parseConfig(cfg, evaluateInterval(cfg), log.NewNopLogger())
+	return err
+}
+
+// parseConfig returns the collect rules of cfg and the client evaluating them every interval.
+func parseConfig(cfg forwarder.Config, interval time.Duration, logger log.Logger) ([]CollectRule, *metricsclient.Client, error) {
+	collectRules, err := unmarshalCollectorRules(logger, cfg.CollectRules)
+	if err != nil {
+		return nil, nil, err
+	}
+	fromClient, err := forwarder.CreateFromClient(cfg, cfg.Metrics, interval, "evaluate_query", cfg.Logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return collectRules, fromClient, nil
+}
+
+func evaluateInterval(cfg forwarder.Config) time.Duration {
+	if cfg.EvaluateInterval == 0 {
+		return 30 * time.Second
+	}
+	return cfg.EvaluateInterval
+}
+
+// configure validates cfg and applies it, preserving the state of the rules that are kept.
+// If cfg is invalid, the evaluator is left untouched.
+func (e *Evaluator) configure(cfg forwarder.Config) error {
+	from := &url.URL{
+		Scheme: cfg.From.Scheme,
+		Host:   cfg.From.Host,
+		Path:   "/api/v1/query",
+	}
+
+	interval := evaluateInterval(cfg)
+	collectRules, fromClient, err := parseConfig(cfg, interval, e.logger)
+	if err != nil {
+		return err
+	}
+
+	// The configuration is valid, it can be applied.
+	e.from = from
+	e.interval = interval
+	e.collectRules = cfg.CollectRules
+	e.stateFile = cfg.CollectRuleStateFile
+	e.fromClient = fromClient
+	if cfg.Metrics != nil {
+		e.metrics = cfg.Metrics.CollectRules
+	}
+	e.config = forwarder.Config{
 		From:          cfg.From,
 		FromToken:     cfg.FromToken,
 		FromTokenFile: cfg.FromTokenFile,
@@ -82,57 +181,49 @@ func New(cfg forwarder.Config) (*Evaluator, error) {
 		Debug:             cfg.Debug,
 		Interval:          cfg.EvaluateInterval,
 		LimitBytes:        cfg.LimitBytes,
+		NativeHistograms:  cfg.NativeHistograms,
+		MinShards:         cfg.MinShards,
+		MaxShards:         cfg.MaxShards,
 		Transformer:       cfg.Transformer,
 
-		Logger:  cfg.Logger,
-		Metrics: cfg.Metrics,
-	}
-	from := &url.URL{
-		Scheme: cfg.From.Scheme,
-		Host:   cfg.From.Host,
-		Path:   "/api/v1/query",
-	}
-	evaluator := Evaluator{
-		from:         from,
-		interval:     cfg.EvaluateInterval,
-		collectRules: cfg.CollectRules,
-		reconfigure:  make(chan struct{}),
-		logger:       log.With(cfg.Logger, "component", "collectrule/evaluator"),
-	}
+		AnonymizeKeyID:            cfg.AnonymizeKeyID,
+		AnonymizeKeyFile:          cfg.AnonymizeKeyFile,
+		AnonymizeSelectors:        cfg.AnonymizeSelectors,
+		AnonymizeFormatPreserving: cfg.AnonymizeFormatPreserving,
+		AnonymizeMapping:          cfg.AnonymizeMapping,
 
-	if err := unmarshalCollectorRules(&evaluator); err != nil {
-		return nil, err
-	}
+		RemoteWriteProtocol:    cfg.RemoteWriteProtocol,
+		RemoteWriteCompression: cfg.RemoteWriteCompression,
+		RemoteWriteTimeout:     cfg.RemoteWriteTimeout,
+		StalenessMarkers:       cfg.StalenessMarkers,
+		Explain:                cfg.Explain,
 
-	if evaluator.interval == 0 {
-		evaluator.interval = 30 * time.Second
-	}
-
-	fromClient, err := forwarder.CreateFromClient(cfg, cfg.Metrics, evaluator.interval, "evaluate_query", cfg.Logger)
-	if err != nil {
-		return nil, err
+		Logger:  cfg.Logger,
+		Metrics: cfg.Metrics,
 	}
-	evaluator.fromClient = fromClient
-
-	return &evaluator, nil
+	e.setRules(collectRules)
+	return nil
 }
 
+// Reconfigure applies the provided Config to the evaluator and to the forwarder of the
+// fired collect rules. If the Config is invalid, the evaluator keeps its current configuration.
 func (e *Evaluator) Reconfigure(cfg forwarder.Config) error {
-	evaluator, err := New(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to reconfigure: %w", err)
-	}
-
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
-	e.fromClient = evaluator.fromClient
-	e.interval = evaluator.interval
-	e.from = evaluator.from
-	e.collectRules = evaluator.collectRules
-	if err = unmarshalCollectorRules(e); err != nil {
-		return err
+	if err := e.configure(cfg); err != nil {
+		return fmt.Errorf("failed to reconfigure: %w", err)
+	}
+
+	// Keep collecting the metrics of the rules that are still firing.
+	e.config.Rules = e.getMatches()
+	if len(e.config.Rules) == 0 || e.forwardWorker != nil {
+		if err := e.updateWorker(); err != nil {
+			return err
+		}
 	}
+	e.observe()
+	e.saveState()
 
 	// Signal a restart to Run func.
 	// Do this in a goroutine since we do not care if restarting the Run loop is asynchronous.
@@ -141,6 +232,15 @@ func (e *Evaluator) Reconfigure(cfg forwarder.Config) error {
 }
 
 func (e *Evaluator) Run(ctx context.Context) {
+	// Resume collecting the metrics enabled by the rules restored as firing.
+	e.lock.Lock()
+	if len(e.config.Rules) > 0 {
+		if err := e.updateWorker(); err != nil {
+			rlogger.Log(e.logger, rlogger.Error, "msg", "failed to start forwarder to collect metrics", "error", err)
+		}
+	}
+	e.lock.Unlock()
+
 	for {
 		// Ensure that the Worker does not access critical configuration during a reconfiguration.
 		e.lock.Lock()
@@ -161,60 +261,99 @@ func (e *Evaluator) Run(ctx context.Context) {
 	}
 }
 
-func unmarshalCollectorRules(e *Evaluator) error {
-	rules = []CollectRule{}
-	for _, ruleStr := range e.collectRules {
+func unmarshalCollectorRules(logger log.Logger, rules []string) ([]CollectRule, error) {
+	collectRules := []CollectRule{}
+	for _, ruleStr := range rules {
 		rule := &CollectRule{}
 		err := json.Unmarshal(([]byte)(ruleStr), rule)
 		if err != nil {
-			rlogger.Log(e.logger, rlogger.Error, "msg", "Input error", "err", err, "rule", rule)
-			return err
+			rlogger.Log(logger, rlogger.Error, "msg", "Input error", "err", err, "rule", rule)
+			return nil, err
+		}
+		if err := rule.parseTemplates(); err != nil {
+			rlogger.Log(logger, rlogger.Error, "msg", "Input error", "err", err, "rule", rule.Name)
+			return nil, err
 		}
 		if rule.DurationStr != "" {
 			rule.Duration, err = time.ParseDuration(rule.DurationStr)
 			if err != nil {
-				rlogger.Log(e.logger, rlogger.Error, "msg", "wrong duration string found in collect rule", "for", rule.DurationStr)
+				rlogger.Log(logger, rlogger.Error, "msg", "wrong duration string found in collect rule", "for", rule.DurationStr)
 			}
 		}
-		rules = append(rules, *rule)
-		if pendingRules[rule.Name] == nil {
-			pendingRules[rule.Name] = &EvaluatedRule{
+		collectRules = append(collectRules, *rule)
+	}
+	return collectRules, nil
+}
+
+// setRules replaces the evaluated collect rules. The state of the rules that are kept is
+// preserved, and the matches enabled by removed rules are disabled.
+func (e *Evaluator) setRules(collectRules []CollectRule) {
+	names := map[string]bool{}
+	for _, rule := range collectRules {
+		names[rule.Name] = true
+		if e.pendingRules[rule.Name] == nil {
+			e.pendingRules[rule.Name] = &EvaluatedRule{
 				triggerTime: map[uint64]*time.Time{},
 			}
 		}
-		if firingRules[rule.Name] == nil {
-			firingRules[rule.Name] = &EvaluatedRule{
+		if e.firingRules[rule.Name] == nil {
+			e.firingRules[rule.Name] = &EvaluatedRule{
 				triggerTime: map[uint64]*time.Time{},
 				resolveTime: map[uint64]*time.Time{},
 			}
 		}
 	}
-	return nil
+	for name, firing := range e.firingRules {
+		if names[name] {
+			continue
+		}
+		for h := range firing.triggerTime {
+			delete(e.enabledMatches, h)
+		}
+		delete(e.firingRules, name)
+	}
+	for name := range e.pendingRules {
+		if !names[name] {
+			delete(e.pendingRules, name)
+		}
+	}
+	e.rules = collectRules
 }
 
-func getMatches() []string {
+func (e *Evaluator) getMatches() []string {
 	matches := []string{}
-	for _, v := range enabledMatches {
+	for _, v := range e.enabledMatches {
 		matches = append(matches, v[:]...)
 	}
 	return matches
 }
 
-func startWorker() error {
-	if forwardWorker == nil {
-		var err error
-		forwardWorker, err = forwarder.New(config)
+// updateWorker collects the metrics enabled by the fired rules. The forwarder is started
+// or reconfigured when there are metrics to collect, and stopped otherwise.
+func (e *Evaluator) updateWorker() error {
+	e.config.Rules = e.getMatches()
+	if len(e.config.Rules) == 0 {
+		if e.forwardWorker != nil && e.cancel != nil {
+			e.cancel()
+			e.forwardWorker = nil
+			rlogger.Log(e.logger, rlogger.Info, "msg", "forwarder stopped")
+		}
+		return nil
+	}
+
+	if e.forwardWorker == nil {
+		worker, err := forwarder.New(e.config)
 		if err != nil {
 			return fmt.Errorf("failed to configure forwarder for additional metrics: %w", err)
 		}
-		var ctx context.Context
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel := context.WithCancel(context.Background())
+		e.forwardWorker, e.cancel = worker, cancel
 		go func() {
-			forwardWorker.Run(ctx)
+			worker.Run(ctx)
 			cancel()
 		}()
 	} else {
-		err := forwardWorker.Reconfigure(config)
+		err := e.forwardWorker.Reconfigure(e.config)
 		if err != nil {
 			return fmt.Errorf("failed to reconfigure forwarder for additional metrics: %w", err)
 		}
@@ -223,44 +362,27 @@ func startWorker() error {
 	return nil
 }
 
-func renderMatches(r CollectRule, ls labels.Labels) []string {
-	matches := []string{}
-	for _, name := range r.Names {
-		matches = append(matches, fmt.Sprintf(`{__name__="%s"}`, name))
-	}
-	labelsMap := map[string]string{}
-	for _, match := range r.Matches {
-		r := regexp.MustCompile(`\{\{ \$labels\.(.*) \}\}`)
-		m := r.FindAllStringSubmatch(match, -1)
-		for _, v := range m {
-			if _, ok := labelsMap[v[1]]; !ok {
-				for _, l := range ls {
-					if l.Name == v[1] {
-						labelsMap[l.Name] = l.Value
-						break
-					}
-				}
-			}
-			original := fmt.Sprintf("{{ $labels.%s }}", v[1])
-			replace := labelsMap[v[1]]
-			matches = append(matches, fmt.Sprintf("{%s}", strings.ReplaceAll(match, original, replace)))
-		}
-		if len(m) == 0 {
-			matches = append(matches, fmt.Sprintf("{%s}", match))
-		}
+// renderMatches renders the matches enabled by a fired rule. Matches that fail to render
+// are logged and skipped, so that the other metrics of the rule are still collected.
+func (e *Evaluator) renderMatches(r CollectRule, ls labels.Labels) []string {
+	matches, err := renderMatches(r, ls)
+	if err != nil {
+		rlogger.Log(e.logger, rlogger.Warn, "msg", "failed to render collect rule matches", "err", err)
 	}
 	return matches
 }
 
-func evaluateRule(logger log.Logger, r CollectRule, metrics []*clientmodel.MetricFamily) bool {
+func (e *Evaluator) evaluateRule(r CollectRule, metrics []*clientmodel.MetricFamily) bool {
 	isUpdate := false
 	now := time.Now()
 	pendings := map[uint64]string{}
 	firings := map[uint64]string{}
-	for k := range (*pendingRules[r.Name]).triggerTime {
+	pending := e.pendingRules[r.Name]
+	firing := e.firingRules[r.Name]
+	for k := range pending.triggerTime {
 		pendings[k] = ""
 	}
-	for k := range (*firingRules[r.Name]).triggerTime {
+	for k := range firing.triggerTime {
 		firings[k] = ""
 	}
 	for _, metric := range metrics {
@@ -277,58 +399,62 @@ func evaluateRule(logger log.Logger, r CollectRule, metrics []*clientmodel.Metri
 				Value: r.Name,
 			})
 			h := ls.Hash()
-			if (*firingRules[r.Name]).triggerTime[h] != nil {
+			e.seriesLabels[h] = ls
+			if firing.triggerTime[h] != nil {
 				delete(firings, h)
-				if (*firingRules[r.Name]).resolveTime[h] != nil {
+				if firing.resolveTime[h] != nil {
 					// resolved rule triggered again
-					delete((*firingRules[r.Name]).resolveTime, h)
+					delete(firing.resolveTime, h)
 				}
 				continue
 			}
-			if (*pendingRules[r.Name]).triggerTime[h] == nil {
+			if pending.triggerTime[h] == nil {
 				if r.Duration == 0 {
 					// no duration defined, fire immediately
-					(*firingRules[r.Name]).triggerTime[h] = &now
-					enabledMatches[h] = renderMatches(r, ls)
+					firing.triggerTime[h] = &now
+					e.enabledMatches[h] = e.renderMatches(r, ls)
 					isUpdate = true
-					rlogger.Log(logger, rlogger.Info, "msg", "collect rule fired", "name", r.Name, "labels", ls)
+					rlogger.Log(e.logger, rlogger.Info, "msg", "collect rule fired", "name", r.Name, "labels", ls)
 				} else {
-					(*pendingRules[r.Name]).triggerTime[h] = &now
+					pending.triggerTime[h] = &now
 				}
 				continue
 			}
 
 			delete(pendings, h)
-			if time.Since(*(*pendingRules[r.Name]).triggerTime[h]) >= r.Duration {
+			if time.Since(*pending.triggerTime[h]) >= r.Duration {
 				// already passed duration, fire
-				(*firingRules[r.Name]).triggerTime[h] = &now
-				delete((*pendingRules[r.Name]).triggerTime, h)
-				enabledMatches[h] = renderMatches(r, ls)
+				firing.triggerTime[h] = &now
+				delete(pending.triggerTime, h)
+				e.enabledMatches[h] = e.renderMatches(r, ls)
 				isUpdate = true
-				rlogger.Log(logger, rlogger.Info, "msg", "collect rule fired", "name", r.Name, "labels", ls)
+				rlogger.Log(e.logger, rlogger.Info, "msg", "collect rule fired", "name", r.Name, "labels", ls)
 			}
 		}
 	}
 	for k := range pendings {
-		delete((*pendingRules[r.Name]).triggerTime, k)
+		delete(pending.triggerTime, k)
 	}
 	for k := range firings {
-		if (*firingRules[r.Name]).resolveTime[k] == nil {
-			(*firingRules[r.Name]).resolveTime[k] = &now
-		} else if time.Since(*(*firingRules[r.Name]).resolveTime[k]) >= expireDuration {
-			delete((*firingRules[r.Name]).triggerTime, k)
-			delete((*firingRules[r.Name]).resolveTime, k)
-			delete(enabledMatches, k)
+		if firing.resolveTime[k] == nil {
+			firing.resolveTime[k] = &now
+		} else if time.Since(*firing.resolveTime[k]) >= expireDuration {
+			delete(firing.triggerTime, k)
+			delete(firing.resolveTime, k)
+			delete(e.enabledMatches, k)
 			isUpdate = true
-			rlogger.Log(logger, rlogger.Info, "msg", "fired collect rule resolved", "name", r.Name)
+			rlogger.Log(e.logger, rlogger.Info, "msg", "fired collect rule resolved", "name", r.Name)
 		}
 	}
 	return isUpdate
 }
 
 func (e *Evaluator) evaluate(ctx context.Context) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
 	isUpdate := false
-	for _, r := range rules {
+	for _, r := range e.rules {
 		from := e.from
 		from.RawQuery = ""
 		v := e.from.Query()
@@ -341,27 +467,170 @@ func (e *Evaluator) evaluate(ctx context.Context) {
 			rlogger.Log(e.logger, rlogger.Error, "msg", "failed to evaluate collect rule", "err", err, "rule", r.Expr)
 			continue
 		} else {
-			if evaluateRule(e.logger, r, result) {
+			if e.evaluateRule(r, result) {
 				isUpdate = true
 			}
 		}
 	}
+	e.observe()
+	// The pending timers move on every evaluation, so the state is saved even without update.
+	e.saveState()
+
 	if isUpdate {
-		config.Rules = getMatches()
+		err := e.updateWorker()
+		if err != nil {
+			rlogger.Log(e.logger, rlogger.Error, "msg", "failed to start forwarder to collect metrics", "error", err)
+		} else if len(e.config.Rules) > 0 {
+			rlogger.Log(e.logger, rlogger.Info, "msg", "forwarder started/reconfigued to collect metrics")
+		}
+	}
+}
 
-		if len(config.Rules) == 0 {
-			if forwardWorker != nil && cancel != nil {
-				cancel()
-				forwardWorker = nil
-				rlogger.Log(e.logger, rlogger.Info, "msg", "forwarder stopped")
-			}
+// observe forgets the labels of the series that are neither pending nor firing anymore,
+// and reports the state of the rules in the metrics and the ObservabilityAddon status.
+func (e *Evaluator) observe() {
+	tracked := map[uint64]bool{}
+	for _, pending := range e.pendingRules {
+		for h := range pending.triggerTime {
+			tracked[h] = true
+		}
+	}
+	for _, firing := range e.firingRules {
+		for h := range firing.triggerTime {
+			tracked[h] = true
+		}
+	}
+	for h := range e.seriesLabels {
+		if !tracked[h] {
+			delete(e.seriesLabels, h)
+		}
+	}
+
+	var firingNames []string
+	if e.metrics != nil {
+		e.metrics.Pending.Reset()
+		e.metrics.Firing.Reset()
+		e.metrics.DynamicSeries.Reset()
+	}
+	for _, r := range e.rules {
+		pending, firing := e.pendingRules[r.Name], e.firingRules[r.Name]
+		if len(firing.triggerTime) > 0 {
+			firingNames = append(firingNames, r.Name)
+		}
+		if e.metrics == nil {
+			continue
+		}
+		dynamic := 0
+		for h := range firing.triggerTime {
+			dynamic += len(e.enabledMatches[h])
+		}
+		e.metrics.Pending.WithLabelValues(r.Name).Set(float64(len(pending.triggerTime)))
+		e.metrics.Firing.WithLabelValues(r.Name).Set(float64(len(firing.triggerTime)))
+		e.metrics.DynamicSeries.WithLabelValues(r.Name).Set(float64(dynamic))
+	}
+
+	sort.Strings(firingNames)
+	if e.status != nil && !reflect.DeepEqual(firingNames, e.reportedRules) {
+		if err := e.status.UpdateCollectRules(firingNames); err != nil {
+			rlogger.Log(e.logger, rlogger.Warn, "msg", "failed to report firing collect rules", "err", err)
 		} else {
-			err := startWorker()
-			if err != nil {
-				rlogger.Log(e.logger, rlogger.Error, "msg", "failed to start forwarder to collect metrics", "error", err)
-			} else {
-				rlogger.Log(e.logger, rlogger.Info, "msg", "forwarder started/reconfigued to collect metrics")
+			e.reportedRules = firingNames
+		}
+	}
+}
+
+// RuleStatus is the state of a collect rule, as listed by Status.
+type RuleStatus struct {
+	Name    string           `json:"name"`
+	Expr    string           `json:"expr"`
+	For     string           `json:"for,omitempty"`
+	Pending []InstanceStatus `json:"pending"`
+	Firing  []InstanceStatus `json:"firing"`
+}
+
+// InstanceStatus is a series for which a collect rule is pending or firing.
+type InstanceStatus struct {
+	Labels      map[string]string `json:"labels"`
+	TriggerTime time.Time         `json:"triggerTime"`
+	// ResolveTime is set when the series of a firing rule disappeared. The matches it
+	// enabled are collected until the rule has been resolved for 15 minutes.
+	ResolveTime *time.Time `json:"resolveTime,omitempty"`
+	Matches     []string   `json:"matches,omitempty"`
+}
+
+// Status returns the pending and firing instances of every collect rule.
+func (e *Evaluator) Status() []RuleStatus {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	rules := make([]RuleStatus, 0, len(e.rules))
+	for _, r := range e.rules {
+		rs := RuleStatus{
+			Name:    r.Name,
+			Expr:    r.Expr,
+			For:     r.DurationStr,
+			Pending: []InstanceStatus{},
+			Firing:  []InstanceStatus{},
+		}
+		for h, t := range e.pendingRules[r.Name].triggerTime {
+			rs.Pending = append(rs.Pending, InstanceStatus{Labels: e.seriesLabels[h].Map(), TriggerTime: *t})
+		}
+		firing := e.firingRules[r.Name]
+		for h, t := range firing.triggerTime {
+			instance := InstanceStatus{
+				Labels:      e.seriesLabels[h].Map(),
+				TriggerTime: *t,
+				Matches:     e.enabledMatches[h],
+			}
+			if resolved := firing.resolveTime[h]; resolved != nil {
+				instance.ResolveTime = resolved
 			}
+			rs.Firing = append(rs.Firing, instance)
 		}
+		sortInstances(rs.Pending)
+		sortInstances(rs.Firing)
+		rules = append(rules, rs)
 	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// EnabledMatches returns the matches enabled by the firing instances of every collect rule,
+// by rule name.
+func (e *Evaluator) EnabledMatches() map[string][]string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	matches := map[string][]string{}
+	for name, firing := range e.firingRules {
+		for h := range firing.triggerTime {
+			matches[name] = append(matches[name], e.enabledMatches[h]...)
+		}
+	}
+	return matches
+}
+
+// Explain explains the series selected by selector among the metrics collected in the last
+// cycle of the fired rules, see forwarder.Worker.Explain. It returns no series when no rule
+// fired yet.
+func (e *Evaluator) Explain(selector string) ([]forwarder.SeriesExplanation, error) {
+	worker := e.getForwardWorker()
+	if worker == nil {
+		return nil, nil
+	}
+	series, err := worker.Explain(selector, e.EnabledMatches())
+	if errors.Is(err, forwarder.ErrNotFederated) {
+		return nil, nil
+	}
+	return series, err
+}
+
+func (e *Evaluator) getForwardWorker() *forwarder.Worker {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.forwardWorker
+}
+
+func sortInstances(instances []InstanceStatus) {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].TriggerTime.Before(instances[j].TriggerTime) })
 }
@@ -6,12 +6,18 @@ package collectrule
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	clientmodel "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/forwarder"
 )
 
 const (
@@ -56,12 +62,16 @@ func createMetricsFamiliy(k string, v string) []*clientmodel.MetricFamily {
 }
 
 func getCollectRule(d time.Duration) CollectRule {
-	return CollectRule{
+	r := CollectRule{
 		Name:     TEST_RULE_NAME,
 		Duration: d,
 		Names:    []string{"name"},
 		Matches:  []string{`__name__="kube_resourcequota",namespace="{{ $labels.namespace }}"`},
 	}
+	if err := r.parseTemplates(); err != nil {
+		panic(err)
+	}
+	return r
 }
 
 func getEvaluatedRulesMap(h uint64, times ...*time.Time) map[string]*EvaluatedRule {
@@ -232,36 +242,150 @@ func TestEvaluateRule(t *testing.T) {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	for _, c := range caseList {
 		t.Run(c.name, func(t *testing.T) {
-			pendingRules = c.pendingRules
-			firingRules = c.firingRules
-			enabledMatches = c.enabledMatches
-			isUpdate := evaluateRule(logger, c.rule, c.metrics)
+			e := newEvaluator(logger)
+			e.pendingRules = c.pendingRules
+			e.firingRules = c.firingRules
+			e.enabledMatches = c.enabledMatches
+			isUpdate := e.evaluateRule(c.rule, c.metrics)
 			if isUpdate != c.isUpdate {
 				t.Errorf("case (%v) isUpdate: (%v) is not the expected: (%v)", c.name, isUpdate,
 					c.isUpdate)
-			} else if c.pendingSize != len(pendingRules[TEST_RULE_NAME].triggerTime) {
-				t.Errorf("case (%v) pendingRules size: (%v) is not the expected: (%v)", c.name, len(pendingRules[TEST_RULE_NAME].triggerTime),
+			} else if c.pendingSize != len(e.pendingRules[TEST_RULE_NAME].triggerTime) {
+				t.Errorf("case (%v) pendingRules size: (%v) is not the expected: (%v)", c.name, len(e.pendingRules[TEST_RULE_NAME].triggerTime),
 					c.pendingSize)
-			} else if c.pendingSize > 0 && pendingRules[TEST_RULE_NAME].triggerTime[c.pendingHash] == nil {
+			} else if c.pendingSize > 0 && e.pendingRules[TEST_RULE_NAME].triggerTime[c.pendingHash] == nil {
 				t.Errorf("case (%v) pendingRules has no key: (%v)", c.name, c.pendingHash)
-			} else if c.firingSize != len(firingRules[TEST_RULE_NAME].triggerTime) {
-				t.Errorf("case (%v) firingRules size: (%v) is not the expected: (%v)", c.name, len(firingRules[TEST_RULE_NAME].triggerTime),
+			} else if c.firingSize != len(e.firingRules[TEST_RULE_NAME].triggerTime) {
+				t.Errorf("case (%v) firingRules size: (%v) is not the expected: (%v)", c.name, len(e.firingRules[TEST_RULE_NAME].triggerTime),
 					c.firingSize)
-			} else if c.firingSize > 0 && firingRules[TEST_RULE_NAME].triggerTime[c.firingHash] == nil {
+			} else if c.firingSize > 0 && e.firingRules[TEST_RULE_NAME].triggerTime[c.firingHash] == nil {
 				t.Errorf("case (%v) firingRules has no key: (%v)", c.name, c.firingHash)
-			} else if c.enabledMatchesSize != len(enabledMatches) {
-				t.Errorf("case (%v) enabledMatches size: (%v) is not the expected: (%v)", c.name, len(enabledMatches),
+			} else if c.enabledMatchesSize != len(e.enabledMatches) {
+				t.Errorf("case (%v) enabledMatches size: (%v) is not the expected: (%v)", c.name, len(e.enabledMatches),
 					c.enabledMatchesSize)
-			} else if c.enabledMatchesSize > 0 && enabledMatches[c.firingHash] == nil {
+			} else if c.enabledMatchesSize > 0 && e.enabledMatches[c.firingHash] == nil {
 				t.Errorf("case (%v) enabledMatches has no key: (%v)", c.name, c.enabledMatchesHash)
-			} else if c.hasMatchOne && c.MatchOne != enabledMatches[c.firingHash][0] {
-				t.Errorf("case (%v) enabledMatches first match: (%v) is not the expected: (%v)", c.name, enabledMatches[c.firingHash][0], c.MatchOne)
-			} else if c.hasMatchTwo && c.MatchTwo != enabledMatches[c.firingHash][1] {
-				t.Errorf("case (%v) enabledMatches second match: (%v) is not the expected: (%v)", c.name, enabledMatches[c.firingHash][1], c.MatchTwo)
-			} else if c.firingResolveSize != len(firingRules[TEST_RULE_NAME].resolveTime) {
-				t.Errorf("case (%v) firingRules resolveTime size: (%d) is not the expected: (%d)", c.name, len(firingRules[TEST_RULE_NAME].resolveTime),
+			} else if c.hasMatchOne && c.MatchOne != e.enabledMatches[c.firingHash][0] {
+				t.Errorf("case (%v) enabledMatches first match: (%v) is not the expected: (%v)", c.name, e.enabledMatches[c.firingHash][0], c.MatchOne)
+			} else if c.hasMatchTwo && c.MatchTwo != e.enabledMatches[c.firingHash][1] {
+				t.Errorf("case (%v) enabledMatches second match: (%v) is not the expected: (%v)", c.name, e.enabledMatches[c.firingHash][1], c.MatchTwo)
+			} else if c.firingResolveSize != len(e.firingRules[TEST_RULE_NAME].resolveTime) {
+				t.Errorf("case (%v) firingRules resolveTime size: (%d) is not the expected: (%d)", c.name, len(e.firingRules[TEST_RULE_NAME].resolveTime),
 					c.firingResolveSize)
 			}
 		})
 	}
 }
+
+func TestSetRules(t *testing.T) {
+	h := getHash("namespace", "test")
+	e := newEvaluator(log.NewNopLogger())
+	e.pendingRules = getEvaluatedRulesMap(0)
+	e.firingRules = getEvaluatedRulesMap(h, getTimePointer(0))
+	e.enabledMatches = getEnabledMatches()
+
+	// Keeping a rule keeps its state.
+	e.setRules([]CollectRule{getCollectRule(0)})
+	if len(e.firingRules[TEST_RULE_NAME].triggerTime) != 1 || len(e.enabledMatches) != 1 {
+		t.Errorf("expected the state of the kept rule to be preserved")
+	}
+
+	// Removing a rule disables the matches it enabled.
+	e.setRules([]CollectRule{{Name: "other_rule"}})
+	if _, ok := e.firingRules[TEST_RULE_NAME]; ok {
+		t.Errorf("expected the removed rule to be deleted")
+	}
+	if len(e.enabledMatches) != 0 {
+		t.Errorf("expected the matches of the removed rule to be disabled, got %v", e.enabledMatches)
+	}
+	if len(e.rules) != 1 || e.rules[0].Name != "other_rule" {
+		t.Errorf("unexpected rules %v", e.rules)
+	}
+}
+
+func TestStateRestore(t *testing.T) {
+	h := getHash("namespace", "test")
+	e := newEvaluator(log.NewNopLogger())
+	e.stateFile = filepath.Join(t.TempDir(), "state.json")
+	e.pendingRules = getEvaluatedRulesMap(getHash("namespace", "other"), getTimePointer(5*time.Minute))
+	e.firingRules = getEvaluatedRulesMap(h, getTimePointer(10*time.Minute), getTimePointer(time.Minute))
+	e.enabledMatches = getEnabledMatches()
+	e.saveState()
+
+	restored := newEvaluator(log.NewNopLogger())
+	if err := restored.loadState(e.stateFile); err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	restored.setRules([]CollectRule{getCollectRule(10 * time.Minute)})
+
+	pending := restored.pendingRules[TEST_RULE_NAME].triggerTime[getHash("namespace", "other")]
+	if pending == nil || !pending.Equal(*e.pendingRules[TEST_RULE_NAME].triggerTime[getHash("namespace", "other")]) {
+		t.Errorf("expected the pending timer to be restored, got %v", pending)
+	}
+	firing := restored.firingRules[TEST_RULE_NAME]
+	if firing.triggerTime[h] == nil || firing.resolveTime[h] == nil {
+		t.Errorf("expected the firing rule to be restored, got %v", firing)
+	}
+	if !reflect.DeepEqual(restored.enabledMatches, getEnabledMatches()) {
+		t.Errorf("expected the enabled matches to be restored, got %v", restored.enabledMatches)
+	}
+
+	// The state of rules that no longer exist is discarded.
+	restored.setRules(nil)
+	if len(restored.enabledMatches) != 0 || len(restored.pendingRules) != 0 {
+		t.Errorf("expected the state of removed rules to be discarded")
+	}
+
+	// A missing file means there is nothing to restore.
+	if err := newEvaluator(log.NewNopLogger()).loadState(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("unexpected error for a missing state file: %v", err)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	e := newEvaluator(log.NewNopLogger())
+	e.metrics = forwarder.NewWorkerMetrics(prometheus.NewRegistry()).CollectRules
+	e.setRules([]CollectRule{getCollectRule(0)})
+
+	e.evaluateRule(getCollectRule(0), createMetricsFamiliy("namespace", "test"))
+	e.observe()
+
+	if v := testutil.ToFloat64(e.metrics.Firing.WithLabelValues(TEST_RULE_NAME)); v != 1 {
+		t.Errorf("expected one firing series, got %v", v)
+	}
+	if v := testutil.ToFloat64(e.metrics.DynamicSeries.WithLabelValues(TEST_RULE_NAME)); v != 2 {
+		t.Errorf("expected two dynamic series selectors, got %v", v)
+	}
+
+	status := e.Status()
+	if len(status) != 1 || len(status[0].Firing) != 1 || len(status[0].Pending) != 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	firing := status[0].Firing[0]
+	if firing.Labels["namespace"] != "test" || len(firing.Matches) != 2 {
+		t.Errorf("unexpected firing instance %+v", firing)
+	}
+
+	// The labels of series that are no longer tracked are forgotten.
+	e.setRules(nil)
+	e.observe()
+	if len(e.seriesLabels) != 0 {
+		t.Errorf("expected the series labels to be forgotten, got %v", e.seriesLabels)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := forwarder.Config{
+		CollectRules: []string{`{"name":"test_rule","expr":"up == 0","names":["up"]}`},
+		Logger:       log.NewNopLogger(),
+		Metrics:      forwarder.NewWorkerMetrics(prometheus.NewRegistry()),
+	}
+	if err := Validate(cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cfg.CollectRules = append(cfg.CollectRules, `{"name":"invalid"`)
+	if err := Validate(cfg); err == nil {
+		t.Error("expected the invalid collect rule to be rejected")
+	}
+}
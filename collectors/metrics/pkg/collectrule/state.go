@@ -0,0 +1,123 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package collectrule
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	rlogger "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
+)
+
+// evaluatorState is the state of an Evaluator persisted across restarts. Series are
+// identified by the hash of their labels, as in EvaluatedRule.
+type evaluatorState struct {
+	Rules          map[string]ruleState         `json:"rules"`
+	EnabledMatches map[uint64][]string          `json:"enabledMatches"`
+	Labels         map[uint64]map[string]string `json:"labels,omitempty"`
+}
+
+type ruleState struct {
+	Pending  map[uint64]time.Time `json:"pending,omitempty"`
+	Firing   map[uint64]time.Time `json:"firing,omitempty"`
+	Resolved map[uint64]time.Time `json:"resolved,omitempty"`
+}
+
+// saveState writes the state of the evaluator to its state file, if any. The file is
+// replaced atomically so that a crash never leaves a partial state behind.
+func (e *Evaluator) saveState() {
+	if len(e.stateFile) == 0 {
+		return
+	}
+
+	s := evaluatorState{
+		Rules:          map[string]ruleState{},
+		EnabledMatches: e.enabledMatches,
+		Labels:         map[uint64]map[string]string{},
+	}
+	for h, ls := range e.seriesLabels {
+		s.Labels[h] = ls.Map()
+	}
+	for name, pending := range e.pendingRules {
+		rs := s.Rules[name]
+		rs.Pending = times(pending.triggerTime)
+		s.Rules[name] = rs
+	}
+	for name, firing := range e.firingRules {
+		rs := s.Rules[name]
+		rs.Firing = times(firing.triggerTime)
+		rs.Resolved = times(firing.resolveTime)
+		s.Rules[name] = rs
+	}
+
+	data, err := json.Marshal(s)
+	if err == nil {
+		tmp := e.stateFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, e.stateFile)
+		}
+	}
+	if err != nil {
+		rlogger.Log(e.logger, rlogger.Warn, "msg", "failed to save collect rule state", "file", e.stateFile, "err", err)
+	}
+}
+
+// loadState restores the state saved in file. A missing file is not an error, since
+// there is no state to restore on the first start.
+func (e *Evaluator) loadState(file string) error {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var s evaluatorState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid collect rule state in %s: %w", file, err)
+	}
+	for name, rs := range s.Rules {
+		e.pendingRules[name] = &EvaluatedRule{
+			triggerTime: timePointers(rs.Pending),
+		}
+		e.firingRules[name] = &EvaluatedRule{
+			triggerTime: timePointers(rs.Firing),
+			resolveTime: timePointers(rs.Resolved),
+		}
+	}
+	for h, matches := range s.EnabledMatches {
+		e.enabledMatches[h] = matches
+	}
+	for h, ls := range s.Labels {
+		e.seriesLabels[h] = labels.FromMap(ls)
+	}
+	rlogger.Log(e.logger, rlogger.Info, "msg", "restored collect rule state", "file", file, "matches", len(e.enabledMatches))
+	return nil
+}
+
+func times(m map[uint64]*time.Time) map[uint64]time.Time {
+	out := make(map[uint64]time.Time, len(m))
+	for h, t := range m {
+		if t != nil {
+			out[h] = *t
+		}
+	}
+	return out
+}
+
+func timePointers(m map[uint64]time.Time) map[uint64]*time.Time {
+	out := make(map[uint64]*time.Time, len(m))
+	for h := range m {
+		t := m[h]
+		out[h] = &t
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package collectrule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// templateHeader makes the labels of the triggering series available as $labels, as in
+// Prometheus alerting rule templates.
+const templateHeader = "{{ $labels := .Labels }}"
+
+// templateFuncs are the helpers available to the names and matches of collect rules.
+var templateFuncs = template.FuncMap{
+	// reEscape escapes a label value for use in a =~ or !~ matcher.
+	"reEscape": regexp.QuoteMeta,
+	// join joins its arguments with sep, e.g. {{ join "|" $labels.pod $labels.container }}.
+	"join": func(sep string, elems ...string) string {
+		return strings.Join(elems, sep)
+	},
+	// default returns def when value is empty, e.g. {{ $labels.namespace | default "default" }}.
+	"default": func(def, value string) string {
+		if len(value) == 0 {
+			return def
+		}
+		return value
+	},
+}
+
+// templateData is the data the templates of a collect rule are executed with.
+type templateData struct {
+	Labels map[string]string
+}
+
+// parseTemplates parses the names and matches of the rule as Go templates, so that
+// invalid templates reject the rule before it is evaluated.
+func (r *CollectRule) parseTemplates() error {
+	r.nameTemplates = nil
+	r.matchTemplates = nil
+	for _, name := range r.Names {
+		t, err := parseTemplate(r.Name, name)
+		if err != nil {
+			return err
+		}
+		r.nameTemplates = append(r.nameTemplates, t)
+	}
+	for _, match := range r.Matches {
+		t, err := parseTemplate(r.Name, match)
+		if err != nil {
+			return err
+		}
+		r.matchTemplates = append(r.matchTemplates, t)
+	}
+	return nil
+}
+
+func parseTemplate(rule, text string) (*template.Template, error) {
+	t, err := template.New(rule).Funcs(templateFuncs).Option("missingkey=zero").Parse(templateHeader + text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %q in collect rule %s: %w", text, rule, err)
+	}
+	return t, nil
+}
+
+// renderMatches renders the names and matches of the rule with the labels of the series
+// that triggered it. A template that fails to render is skipped and its error returned
+// along with the other matches.
+func renderMatches(r CollectRule, ls labels.Labels) ([]string, error) {
+	data := templateData{Labels: ls.Map()}
+	matches := []string{}
+	var errs []string
+	for _, t := range r.nameTemplates {
+		name, err := execute(t, data)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		matches = append(matches, fmt.Sprintf(`{__name__="%s"}`, name))
+	}
+	for _, t := range r.matchTemplates {
+		match, err := execute(t, data)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		matches = append(matches, fmt.Sprintf("{%s}", match))
+	}
+	if len(errs) > 0 {
+		return matches, fmt.Errorf("failed to render collect rule %s: %s", r.Name, strings.Join(errs, "; "))
+	}
+	return matches, nil
+}
+
+func execute(t *template.Template, data templateData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package collectrule
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestRenderMatches(t *testing.T) {
+	ls := labels.FromStrings("namespace", "test", "pod", "api-1", "container", "server")
+	tests := []struct {
+		name    string
+		names   []string
+		matches []string
+		want    []string
+	}{
+		{
+			name:    "several placeholders in one match",
+			matches: []string{`__name__="container_memory_rss",namespace="{{ $labels.namespace }}",pod="{{ $labels.pod }}"`},
+			want:    []string{`{__name__="container_memory_rss",namespace="test",pod="api-1"}`},
+		},
+		{
+			name:  "templated name",
+			names: []string{"{{ $labels.container }}_requests_total"},
+			want:  []string{`{__name__="server_requests_total"}`},
+		},
+		{
+			name:    "regex escape and join",
+			matches: []string{`pod=~"{{ join "|" (reEscape $labels.pod) "api-.+" }}"`},
+			want:    []string{`{pod=~"api-1|api-.+"}`},
+		},
+		{
+			name:    "default for a missing label",
+			matches: []string{`node="{{ $labels.node | default "none" }}"`},
+			want:    []string{`{node="none"}`},
+		},
+		{
+			name:    "missing label without default",
+			matches: []string{`node="{{ .Labels.node }}"`},
+			want:    []string{`{node=""}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := CollectRule{Name: TEST_RULE_NAME, Names: tt.names, Matches: tt.matches}
+			if err := r.parseTemplates(); err != nil {
+				t.Fatalf("failed to parse templates: %v", err)
+			}
+			got, err := renderMatches(r, ls)
+			if err != nil {
+				t.Fatalf("failed to render matches: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected matches %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInvalidTemplateRejected(t *testing.T) {
+	for _, rule := range []string{
+		`{"name": "a", "expr": "up", "matches": ["namespace=\"{{ $labels.namespace \""]}`,
+		`{"name": "b", "expr": "up", "names": ["{{ unknownFunc $labels.pod }}"]}`,
+	} {
+		if _, err := unmarshalCollectorRules(log.NewNopLogger(), []string{rule}); err == nil {
+			t.Errorf("expected %s to be rejected", rule)
+		}
+	}
+}
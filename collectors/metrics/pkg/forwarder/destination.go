@@ -0,0 +1,238 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	clientmodel "github.com/prometheus/client_model/go"
+
+	metricshttp "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/http"
+	rlogger "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricsclient"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/queue"
+)
+
+// destinationNameRegexp matches the valid destination names, which are DNS labels.
+var destinationNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// ValidateDestinationName returns an error if name is not a valid destination name. The name
+// is used in the queue directory and in the metric labels of the destination.
+func ValidateDestinationName(name string) error {
+	if !destinationNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid destination name %q, it must be a DNS label of lower case alphanumeric characters or '-'", name)
+	}
+	return nil
+}
+
+// Destination is an additional remote write endpoint, such as a long-term store, that receives
+// a subset of the forwarded metrics. The only required fields are `Name` and `URL`.
+type Destination struct {
+	Name string
+	URL  *url.URL
+
+	// CAFile verifies the server certificate in addition to the system certificates.
+	CAFile string
+	// CertFile and KeyFile authenticate the collector with a client certificate.
+	CertFile string
+	KeyFile  string
+	// BearerToken or BearerTokenFile authenticate the collector with a token.
+	BearerToken     string
+	BearerTokenFile string
+
+	// Matches selects the metrics sent to the destination, in the format of the match rules.
+	// The matches apply to the metrics once transformed for the main destination.
+	// All metrics are sent when empty.
+	Matches []string
+	// Transformer is applied to the selected metrics, only for this destination.
+	Transformer metricfamily.Transformer
+
+	MinShards int
+	MaxShards int
+}
+
+// destination sends metrics to a Destination, independently from the other destinations.
+type destination struct {
+	name        string
+	to          *url.URL
+	client      *metricsclient.Client
+	transformer metricfamily.MultiTransformer
+	queue       *queue.Queue
+	queueDir    string
+	staleSeries *metricsclient.StaleSeries
+
+	// sending is held while a write is in flight, so that a slow destination
+	// skips intervals instead of piling up writes.
+	sending sync.Mutex
+
+	logger  log.Logger
+	skipped prometheus.Counter
+}
+
+func newDestination(d Destination, cfg Config, metrics *workerMetrics, interval time.Duration,
+	q *queue.Queue, logger log.Logger) (*destination, error) {
+	if len(d.Name) == 0 {
+		return nil, errors.New("a destination name is required")
+	}
+	if err := ValidateDestinationName(d.Name); err != nil {
+		return nil, err
+	}
+	if d.URL == nil {
+		return nil, fmt.Errorf("a URL is required for destination %s", d.Name)
+	}
+	logger = log.With(logger, "destination", d.Name)
+
+	transport, err := destinationTransport(logger, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transport for destination %s: %w", d.Name, err)
+	}
+	transport.Proxy = http.ProxyFromEnvironment
+	client := &http.Client{Transport: transport}
+	if cfg.Debug {
+		client.Transport = metricshttp.NewDebugRoundTripper(logger, client.Transport)
+	}
+	token := d.BearerToken
+	if len(token) == 0 && len(d.BearerTokenFile) > 0 {
+		data, err := os.ReadFile(filepath.Clean(d.BearerTokenFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bearer token file of destination %s: %w", d.Name, err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if len(token) > 0 {
+		client.Transport = metricshttp.NewBearerRoundTripper(token, client.Transport)
+	}
+
+	dest := &destination{
+		name:     d.Name,
+		to:       d.URL,
+		queue:    q,
+		queueDir: destinationQueueDir(cfg.QueueDir, d.Name),
+		logger:   logger,
+		skipped:  metrics.destinationSkipped.WithLabelValues(d.Name),
+	}
+	dest.client = metricsclient.New(logger, metrics.destinationClientMetrics(d.Name), client, cfg.LimitBytes,
+		interval, "federate_to_"+d.Name).
+		WithNativeHistograms(cfg.NativeHistograms).
+		WithProtocol(cfg.RemoteWriteProtocol, cfg.RemoteWriteCompression).
+		WithRequestTimeout(cfg.RemoteWriteTimeout).
+		WithShards(d.MinShards, d.MaxShards)
+
+	if len(d.Matches) > 0 {
+		allowlist, err := metricfamily.NewAllowlist(d.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matches for destination %s: %w", d.Name, err)
+		}
+		dest.transformer.With(allowlist)
+	}
+	dest.transformer.With(d.Transformer)
+	dest.transformer.With(metricfamily.TransformerFunc(metricfamily.PackMetrics))
+
+	if dest.queue == nil && len(dest.queueDir) > 0 {
+		dest.queue, err = queue.New(queue.Config{
+			Dir:      dest.queueDir,
+			MaxBytes: cfg.QueueMaxBytes,
+			MaxAge:   cfg.QueueMaxAge,
+			Logger:   logger,
+			Metrics:  metrics.destinationQueueMetrics(d.Name),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to open queue of destination %s: %w", d.Name, err)
+		}
+	}
+	return dest, nil
+}
+
+// destinationQueueDir returns the queue directory of a destination, under the main queue directory.
+func destinationQueueDir(queueDir, name string) string {
+	if len(queueDir) == 0 {
+		return ""
+	}
+	return filepath.Join(queueDir, "destinations", name)
+}
+
+// destinationTransport returns the transport to a destination. The server certificates are
+// verified with the system certificates, and with the CA file when it is set.
+func destinationTransport(logger log.Logger, d Destination) (*http.Transport, error) {
+	if len(d.CAFile) > 0 && (len(d.CertFile) > 0 || len(d.KeyFile) > 0) {
+		return metricsclient.MTLSTransport(logger, d.CAFile, d.CertFile, d.KeyFile)
+	}
+
+	transport := metricsclient.DefaultTransport(logger, false)
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(d.CertFile) > 0 || len(d.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(d.CertFile, d.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client cert: %w", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(d.CAFile) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read system certificates: %w", err)
+		}
+		data, err := os.ReadFile(filepath.Clean(d.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			rlogger.Log(logger, rlogger.Warn, "msg", "no certs found in ca file")
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	return transport, nil
+}
+
+// send writes a copy of the families to the destination in the background.
+// If the previous write is still in flight, the families are skipped.
+func (d *destination) send(families []*clientmodel.MetricFamily, interval time.Duration) {
+	if !d.sending.TryLock() {
+		d.skipped.Inc()
+		rlogger.Log(d.logger, rlogger.Warn, "msg", "previous write to destination still in flight, skipping metrics")
+		return
+	}
+	families = metricfamily.Clone(families)
+
+	go func() {
+		defer d.sending.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		if err := d.write(ctx, families, interval); err != nil {
+			rlogger.Log(d.logger, rlogger.Warn, "msg", "failed to send metrics to destination", "err", err)
+		}
+	}()
+}
+
+func (d *destination) write(ctx context.Context, families []*clientmodel.MetricFamily, interval time.Duration) error {
+	if err := metricfamily.Filter(families, d.transformer); err != nil {
+		return err
+	}
+	families = metricfamily.Pack(families)
+	if len(families) == 0 {
+		return nil
+	}
+
+	req := &http.Request{Method: "POST", URL: d.to}
+	return remoteWrite(ctx, d.logger, d.client, d.queue, req, families, interval)
+}
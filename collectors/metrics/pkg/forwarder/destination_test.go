@@ -0,0 +1,228 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package forwarder
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
+)
+
+func testFamilies() []*clientmodel.MetricFamily {
+	var families []*clientmodel.MetricFamily
+	for _, name := range []string{"up", "node_cpu_seconds_total"} {
+		families = append(families, &clientmodel.MetricFamily{
+			Name: proto.String(name),
+			Type: clientmodel.MetricType_GAUGE.Enum(),
+			Metric: []*clientmodel.Metric{{
+				Gauge:       &clientmodel.Gauge{Value: proto.Float64(1)},
+				TimestampMs: proto.Int64(time.Now().UnixMilli()),
+			}},
+		})
+	}
+	return families
+}
+
+// receivedNames decodes a remote write request and returns the metric names it holds.
+func receivedNames(t *testing.T, r *http.Request) []string {
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Errorf("failed to read request: %v", err)
+		return nil
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Errorf("failed to decompress request: %v", err)
+		return nil
+	}
+	var wreq prompb.WriteRequest
+	if err := proto.Unmarshal(data, &wreq); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+		return nil
+	}
+	var names []string
+	for _, ts := range wreq.Timeseries {
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				names = append(names, l.Value)
+			}
+		}
+	}
+	return names
+}
+
+func TestDestinationWrite(t *testing.T) {
+	received := make(chan []string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected the destination token, got %q", r.Header.Get("Authorization"))
+		}
+		received <- receivedNames(t, r)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	d, err := newDestination(Destination{
+		Name:        "longterm",
+		URL:         u,
+		BearerToken: "secret",
+		Matches:     []string{`{__name__="up"}`},
+		Transformer: metricfamily.RenameMetrics{Names: map[string]string{"up": "spoke_up"}},
+	}, Config{}, NewWorkerMetrics(prometheus.NewRegistry()), time.Minute, nil, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("failed to create destination: %v", err)
+	}
+
+	families := testFamilies()
+	d.send(families, time.Minute)
+	select {
+	case names := <-received:
+		if len(names) != 1 || names[0] != "spoke_up" {
+			t.Errorf("expected only the renamed up metric, got %v", names)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("destination did not receive metrics")
+	}
+
+	// The destination works on a copy of the metrics.
+	if families[0].GetName() != "up" || len(families) != 2 {
+		t.Errorf("expected the original metrics to be left untouched")
+	}
+}
+
+func TestDestinationSlowDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	received := make(chan []string, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- receivedNames(t, r)
+	}))
+	defer fast.Close()
+
+	metrics := NewWorkerMetrics(prometheus.NewRegistry())
+	newTestDestination := func(name, rawURL string) *destination {
+		u, _ := url.Parse(rawURL)
+		d, err := newDestination(Destination{Name: name, URL: u}, Config{}, metrics, time.Minute, nil, log.NewNopLogger())
+		if err != nil {
+			t.Fatalf("failed to create destination: %v", err)
+		}
+		return d
+	}
+	slowDest := newTestDestination("slow", slow.URL)
+	fastDest := newTestDestination("fast", fast.URL)
+
+	slowDest.send(testFamilies(), time.Minute)
+	fastDest.send(testFamilies(), time.Minute)
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("fast destination was blocked by the slow one")
+	}
+
+	// The slow destination skips the next interval while its write is in flight.
+	slowDest.send(testFamilies(), time.Minute)
+	if v := testutil.ToFloat64(metrics.destinationSkipped.WithLabelValues("slow")); v != 1 {
+		t.Errorf("expected one skipped interval, got %v", v)
+	}
+}
+
+func TestValidateDestinationName(t *testing.T) {
+	for _, name := range []string{"longterm", "long-term-2"} {
+		if err := ValidateDestinationName(name); err != nil {
+			t.Errorf("expected %q to be a valid destination name: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../x", "a/b", "Longterm", "-longterm", strings.Repeat("a", 64)} {
+		if err := ValidateDestinationName(name); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestReconfigureWaitsForDestinations(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	from, _ := url.Parse("https://redhat.com")
+	to, _ := url.Parse(slow.URL)
+
+	metrics := NewWorkerMetrics(prometheus.NewRegistry())
+	c := Config{
+		From:         from,
+		QueueDir:     t.TempDir(),
+		Destinations: []Destination{{Name: "longterm", URL: to}},
+		Logger:       log.NewNopLogger(),
+		Metrics:      metrics,
+	}
+	w, err := New(c)
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+	if testutil.CollectAndCount(metrics.destinationQueueSegments) != 1 {
+		t.Error("expected the queue of the destination to report its metrics")
+	}
+
+	previous := w.destinations[0]
+	previous.send(testFamilies(), time.Minute)
+	reconfigured := make(chan error)
+	go func() { reconfigured <- w.Reconfigure(c) }()
+	select {
+	case err := <-reconfigured:
+		t.Fatalf("expected the reconfiguration to wait for the write in flight, got %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-reconfigured:
+		if err != nil {
+			t.Fatalf("failed to reconfigure worker: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("reconfiguration did not complete once the write finished")
+	}
+	if w.destinations[0] == previous || w.destinations[0].queue != previous.queue {
+		t.Error("expected a new destination reusing the queue of the previous one")
+	}
+}
+
+func TestDestinationTransportWithoutCA(t *testing.T) {
+	// A client certificate without a CA file verifies the server with the system certificates.
+	transport, err := destinationTransport(log.NewNopLogger(), Destination{
+		Name:     "longterm",
+		CertFile: "../../testdata/tls/tls.crt",
+		KeyFile:  "../../testdata/tls/tls.key",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transport.TLSClientConfig.Certificates) != 1 {
+		t.Errorf("expected the client certificate to be loaded")
+	}
+	if transport.TLSClientConfig.RootCAs != nil {
+		t.Errorf("expected the system certificates to be used")
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricsclient"
+)
+
+// SeriesExplanation explains how a series of a source is federated and transformed. The
+// labels of the series are only returned once transformed, in Transformed.
+type SeriesExplanation struct {
+	Source string `json:"source"`
+	// MatchRules are the match rules that federate the series, CollectRules the collect
+	// rules that currently federate it, by name.
+	MatchRules   []string `json:"matchRules"`
+	CollectRules []string `json:"collectRules,omitempty"`
+	// Steps are the changes of the transformers, Transformed the series they send.
+	Steps       []metricfamily.ExplainStep `json:"steps"`
+	Transformed *clientmodel.MetricFamily  `json:"transformed,omitempty"`
+	// Sent is set when the series is not dropped by the transformers. Error is the error
+	// of the transformer that failed, the series is not sent then.
+	Sent  bool   `json:"sent"`
+	Error string `json:"error,omitempty"`
+	// Last is the sample of the series sent in the last cycle, if any.
+	Last *clientmodel.Metric `json:"last,omitempty"`
+}
+
+// ErrNotFederated is returned by Explain before the first cycle of the worker.
+var ErrNotFederated = errors.New("no metrics were federated yet")
+
+// explainCycle is the last cycle of the worker, that Explain dry-runs.
+type explainCycle struct {
+	sources     []*federatedSource
+	transformer metricfamily.Transformer
+	sent        []*clientmodel.MetricFamily
+}
+
+// federatedSource holds the response of a source, that is decoded again to explain its
+// series. The response takes less memory than the families decoded from it.
+type federatedSource struct {
+	name     string
+	rules    []string
+	response *metricsclient.Response
+}
+
+// Explain dry-runs the transformers over the series federated in the last cycle, and
+// explains which match rules and collect rules federate them. selector selects the series
+// as the transformers send them, so that it cannot test the values of the labels they
+// change or remove. The series dropped by the transformers are only selected by their
+// metric name. collectMatches are the matches currently enabled by the collect rules, by
+// rule name. Nothing is retrieved nor sent, and the cycles in progress are not waited for.
+// It requires Config.Explain.
+func (w *Worker) Explain(selector string, collectMatches map[string][]string) ([]SeriesExplanation, error) {
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %s: %w", selector, err)
+	}
+
+	w.explainLock.Lock()
+	cycle := w.explained
+	w.explainLock.Unlock()
+	if cycle == nil {
+		return nil, ErrNotFederated
+	}
+	transformer, ok := cycle.transformer.(metricfamily.MultiTransformer)
+	if !ok {
+		return nil, errors.New("the transformer of the worker cannot be explained")
+	}
+
+	series := []SeriesExplanation{}
+	for i, source := range cycle.sources {
+		// The collect rules federate from `from` only.
+		var collect map[string][]string
+		if i == 0 {
+			collect = collectMatches
+		}
+		if source.response == nil {
+			continue
+		}
+		// The response was decoded once already, it is decoded to its end or to the same error.
+		_ = source.response.Decode(func(family *clientmodel.MetricFamily) error {
+			for _, m := range family.Metric {
+				if m == nil {
+					continue
+				}
+				e := explainSeries(transformer, cycle.sent, source, family, m, collect)
+				ls := labels.FromStrings(labels.MetricName, family.GetName())
+				if e.Transformed != nil {
+					ls = seriesLabels(e.Transformed.GetName(), e.Transformed.Metric[0])
+				}
+				if matchesAll(ls, matchers) {
+					series = append(series, e)
+				}
+			}
+			return nil
+		})
+	}
+	return series, nil
+}
+
+// setExplained records the cycle that Explain dry-runs.
+func (w *Worker) setExplained(cycle *explainCycle) {
+	w.explainLock.Lock()
+	defer w.explainLock.Unlock()
+	w.explained = cycle
+}
+
+// explainSeries transforms a copy of the metric m of family.
+func explainSeries(transformer metricfamily.MultiTransformer, sent []*clientmodel.MetricFamily, source *federatedSource,
+	family *clientmodel.MetricFamily, m *clientmodel.Metric, collectMatches map[string][]string) SeriesExplanation {
+	ls := seriesLabels(family.GetName(), m)
+	e := SeriesExplanation{
+		Source:     source.name,
+		MatchRules: matchingRules(ls, source.rules),
+	}
+	for name, matches := range collectMatches {
+		if len(matchingRules(ls, matches)) > 0 {
+			e.CollectRules = append(e.CollectRules, name)
+		}
+	}
+	sort.Strings(e.CollectRules)
+
+	transformed := &clientmodel.MetricFamily{
+		Name:   proto.String(family.GetName()),
+		Help:   proto.String(family.GetHelp()),
+		Type:   family.Type,
+		Metric: []*clientmodel.Metric{proto.Clone(m).(*clientmodel.Metric)},
+	}
+	steps, ok, err := transformer.Explain(transformed)
+	e.Steps = append([]metricfamily.ExplainStep{}, steps...)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	if !ok {
+		return e
+	}
+	e.Transformed = transformed
+	e.Sent = true
+	e.Last = lastMetric(sent, transformed)
+	return e
+}
+
+// lastMetric returns the metric of sent with the name and labels of the metric of family.
+func lastMetric(sent []*clientmodel.MetricFamily, family *clientmodel.MetricFamily) *clientmodel.Metric {
+	want := seriesLabels(family.GetName(), family.Metric[0])
+	for _, f := range sent {
+		if f == nil || f.GetName() != family.GetName() {
+			continue
+		}
+		for _, m := range f.Metric {
+			if m != nil && labels.Equal(seriesLabels(f.GetName(), m), want) {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// matchingRules returns the rules that select the series with labels ls. The invalid rules
+// are skipped, Prometheus rejects them.
+func matchingRules(ls labels.Labels, rules []string) []string {
+	matching := []string{}
+	for _, rule := range rules {
+		matchers, err := parser.ParseMetricSelector(rule)
+		if err != nil || !matchesAll(ls, matchers) {
+			continue
+		}
+		matching = append(matching, rule)
+	}
+	return matching
+}
+
+func matchesAll(ls labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(ls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func seriesLabels(name string, m *clientmodel.Metric) labels.Labels {
+	ls := map[string]string{labels.MetricName: name}
+	for _, l := range m.Label {
+		ls[l.GetName()] = l.GetValue()
+	}
+	return labels.FromMap(ls)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
+)
+
+func TestExplain(t *testing.T) {
+	var requests atomic.Int32
+	from := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		now := time.Now().UnixMilli()
+		fmt.Fprintln(w, "# TYPE up gauge")
+		fmt.Fprintf(w, "up{job=\"a\",prometheus=\"p\"} 1 %d\n", now)
+		fmt.Fprintf(w, "up{job=\"b\",prometheus=\"p\"} 0 %d\n", now)
+	}))
+	defer from.Close()
+
+	var transformer metricfamily.MultiTransformer
+	transformer.With(metricfamily.RenameMetrics{Names: map[string]string{"up": "up_renamed"}})
+	transformer.With(metricfamily.NewElide("prometheus"))
+	fromURL, _ := url.Parse(from.URL)
+	w, err := New(Config{
+		From:        fromURL,
+		Rules:       []string{`{__name__="up"}`},
+		Transformer: transformer,
+		Explain:     true,
+		Logger:      log.NewNopLogger(),
+		Metrics:     NewWorkerMetrics(prometheus.NewRegistry()),
+	})
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+	if _, err := w.Explain("up_renamed", nil); !errors.Is(err, ErrNotFederated) {
+		t.Errorf("expected no explanation before the first cycle, got %v", err)
+	}
+	if err := w.forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := w.Explain("up{", nil); err == nil {
+		t.Error("expected an invalid selector to be rejected")
+	}
+	// Explain neither waits for the cycle in progress nor queries the sources.
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	before := requests.Load()
+	series, err := w.Explain("up_renamed", map[string][]string{"collect": {`{__name__="up",job="b"}`}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests.Load() != before {
+		t.Error("expected the series of the last cycle to be explained without federating them again")
+	}
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(series))
+	}
+
+	expectedSteps := []metricfamily.ExplainStep{
+		{Transformer: "metricfamily.RenameMetrics", Name: "up_renamed"},
+		{Transformer: "metricfamily.elide", RemovedLabels: []string{"prometheus"}},
+	}
+	a, b := series[0], series[1]
+	if !reflect.DeepEqual(a.MatchRules, []string{`{__name__="up"}`}) || len(a.CollectRules) != 0 || !a.Sent {
+		t.Errorf("expected job a to be federated by its match rule, got %+v", a)
+	}
+	if !reflect.DeepEqual(a.Steps, expectedSteps) {
+		t.Errorf("expected steps %+v, got %+v", expectedSteps, a.Steps)
+	}
+	// Only the labels of the transformed series are returned.
+	if a.Transformed.GetName() != "up_renamed" || len(a.Transformed.Metric[0].Label) != 1 {
+		t.Errorf("unexpected transformed series %v", a.Transformed)
+	}
+	if a.Last == nil || a.Last.GetGauge().GetValue() != 1 {
+		t.Errorf("expected the sample of the last cycle, got %v", a.Last)
+	}
+	if !reflect.DeepEqual(b.CollectRules, []string{"collect"}) || !b.Sent {
+		t.Errorf("expected job b to be federated by the collect rule, got %+v", b)
+	}
+
+	if series, err := w.Explain(`up_renamed{job="c"}`, nil); err != nil || len(series) != 0 {
+		t.Errorf("expected no series, got %v, %v", series, err)
+	}
+	// The selector cannot test the series as federated.
+	for _, selector := range []string{"up", `up_renamed{prometheus="p"}`} {
+		if series, err := w.Explain(selector, nil); err != nil || len(series) != 0 {
+			t.Errorf("expected %s not to select the transformed series, got %v, %v", selector, series, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package forwarder
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
@@ -14,6 +15,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,11 +25,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
 
 	metricshttp "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/http"
 	rlogger "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricsclient"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/queue"
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/recordingrule"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/simulator"
 	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/status"
 )
@@ -59,6 +65,9 @@ type Config struct {
 	Interval           time.Duration
 	EvaluateInterval   time.Duration
 	LimitBytes         int64
+	NativeHistograms   bool
+	MinShards          int
+	MaxShards          int
 	Rules              []string
 	RulesFile          string
 	RecordingRules     []string
@@ -67,8 +76,63 @@ type Config struct {
 	CollectRulesFile   string
 	Transformer        metricfamily.Transformer
 
+	// AnonymizeKeyID and AnonymizeKeyFile enable the keyed anonymization of the labels instead
+	// of the salted hash. It also anonymizes the labels of AnonymizeSelectors on the metrics they
+	// match, and records the original values in AnonymizeMapping when it is set.
+	AnonymizeKeyID            string
+	AnonymizeKeyFile          string
+	AnonymizeSelectors        []metricfamily.AnonymizeSelector
+	AnonymizeFormatPreserving bool
+	AnonymizeMapping          *metricfamily.AnonymizeMapping
+
+	// RemoteWriteProtocol and RemoteWriteCompression select the remote write encoding,
+	// remote write 1.0 with snappy by default. RemoteWriteTimeout bounds a single request.
+	RemoteWriteProtocol    metricsclient.Protocol
+	RemoteWriteCompression string
+	RemoteWriteTimeout     time.Duration
+
+	// StalenessMarkers ends the series that disappear between two cycles with a staleness
+	// marker, so that remote queries stop returning them right away.
+	StalenessMarkers bool
+
+	// LocalRecordingRules evaluates the recording rules over the federated metrics with the
+	// PromQL engine of the collector, instead of querying `FromQuery` for every rule.
+	LocalRecordingRules bool
+
+	// Explain keeps the federate responses of every cycle, so that Explain can dry-run the
+	// transformers over the series of the last cycle.
+	Explain bool
+
+	// CollectRuleStateFile is where the collect rule evaluator persists its pending and
+	// firing rules, so that they survive a restart.
+	CollectRuleStateFile string
+
+	// QueueDir enables buffering of metrics that failed to be sent in segment files
+	// under this directory. They are replayed once the upload endpoint recovers.
+	QueueDir      string
+	QueueMaxBytes int64
+	QueueMaxAge   time.Duration
+
+	// Destinations receive a subset of the metrics in addition to ToUpload.
+	Destinations []Destination
+
+	// SourceName names `From` in the status, the user workload or platform Prometheus by default.
+	SourceName string
+	// Sources are federated in addition to `From`.
+	Sources []Source
+
+	// MaxSeries and MaxSeriesPerMetric bound the number of series sent in total and per
+	// metric name. MetricSeriesLimits overrides MaxSeriesPerMetric for the given metrics.
+	// Zero means no limit.
+	MaxSeries          int
+	MaxSeriesPerMetric int
+	MetricSeriesLimits map[string]int
+
 	Logger                  log.Logger
 	SimulatedTimeseriesFile string
+	// SimulatedScenarioFile replaces the federation by the metrics of a simulation scenario,
+	// and reports the throughput and latency of their writes.
+	SimulatedScenarioFile string
 
 	Metrics *workerMetrics
 }
@@ -87,14 +151,38 @@ type Worker struct {
 	transformer    metricfamily.Transformer
 	rules          []string
 	recordingRules []string
-
+	// localRules evaluates the recording rules when they are evaluated locally.
+	localRules *recordingrule.Evaluator
+
+	queue        *queue.Queue
+	destinations []*destination
+	staleSeries  *metricsclient.StaleSeries
+	// sourceName names `from` in the status, sources are federated in addition to it.
+	sourceName string
+	sources    []*source
+	// cfg is the configuration the worker was created with.
+	cfg Config
+
+	limit       *metricfamily.CardinalityLimit
 	lastMetrics []*clientmodel.MetricFamily
+	lastSeries  map[string]int
+	lastDropped map[string]int
 	lock        sync.Mutex
 	reconfigure chan struct{}
 
+	// federated holds the responses of the sources in the cycle in progress, when cfg.Explain.
+	federated []*federatedSource
+	// explained is the last cycle that Explain dry-runs. It has its own lock, so that Explain
+	// does not wait for the cycle in progress.
+	explained   *explainCycle
+	explainLock sync.Mutex
+
 	logger log.Logger
 
 	simulatedTimeseriesFile string
+	// generator generates the metrics of the simulation scenario, report measures their writes.
+	generator *simulator.Generator
+	report    *simulator.Report
 
 	status status.StatusReport
 
@@ -167,10 +255,14 @@ func createClients(cfg Config, metrics *metricsclient.ClientMetrics, interval ti
 		}
 		anonymizeSalt = strings.TrimSpace(string(data))
 	}
-	if len(cfg.AnonymizeLabels) != 0 && len(anonymizeSalt) == 0 {
+	keyed := len(cfg.AnonymizeKeyFile) > 0
+	if !keyed && (len(cfg.AnonymizeSelectors) > 0 || cfg.AnonymizeFormatPreserving) {
+		return nil, nil, transformer, errors.New("anonymize-key-file must be specified to anonymize selected metrics or preserve the format")
+	}
+	if !keyed && len(cfg.AnonymizeLabels) != 0 && len(anonymizeSalt) == 0 {
 		return nil, nil, transformer, errors.New("anonymize-salt must be specified if anonymize-labels is set")
 	}
-	if len(cfg.AnonymizeLabels) == 0 {
+	if len(cfg.AnonymizeLabels) == 0 && len(cfg.AnonymizeSelectors) == 0 {
 		rlogger.Log(logger, rlogger.Warn, "msg", "not anonymizing any labels")
 	}
 
@@ -178,7 +270,25 @@ func createClients(cfg Config, metrics *metricsclient.ClientMetrics, interval ti
 	if cfg.Transformer != nil {
 		transformer.With(cfg.Transformer)
 	}
-	if len(cfg.AnonymizeLabels) > 0 {
+	switch {
+	case keyed:
+		key, err := os.ReadFile(cfg.AnonymizeKeyFile)
+		if err != nil {
+			return nil, nil, transformer, fmt.Errorf("failed to read anonymize-key-file: %w", err)
+		}
+		anonymizer, err := metricfamily.NewKeyedAnonymizer(metricfamily.KeyedAnonymizerConfig{
+			KeyID:            cfg.AnonymizeKeyID,
+			Key:              bytes.TrimSpace(key),
+			Labels:           cfg.AnonymizeLabels,
+			Selectors:        cfg.AnonymizeSelectors,
+			FormatPreserving: cfg.AnonymizeFormatPreserving,
+			Mapping:          cfg.AnonymizeMapping,
+		})
+		if err != nil {
+			return nil, nil, transformer, err
+		}
+		transformer.With(anonymizer)
+	case len(cfg.AnonymizeLabels) > 0:
 		transformer.With(metricfamily.NewMetricsAnonymizer(anonymizeSalt, cfg.AnonymizeLabels, nil))
 	}
 	from, err := CreateFromClient(cfg, cfg.Metrics, interval, "federate_from", logger)
@@ -187,6 +297,9 @@ func createClients(cfg Config, metrics *metricsclient.ClientMetrics, interval ti
 	}
 
 	// Create the `toClient`.
+	if err := metricsclient.ValidateProtocol(cfg.RemoteWriteProtocol, cfg.RemoteWriteCompression); err != nil {
+		return nil, nil, transformer, err
+	}
 
 	toTransport, err := metricsclient.MTLSTransport(logger, cfg.ToUploadCA, cfg.ToUploadCert, cfg.ToUploadKey)
 	if err != nil {
@@ -197,15 +310,69 @@ func createClients(cfg Config, metrics *metricsclient.ClientMetrics, interval ti
 	if cfg.Debug {
 		toClient.Transport = metricshttp.NewDebugRoundTripper(logger, toClient.Transport)
 	}
-	to := metricsclient.New(logger, metrics, toClient, cfg.LimitBytes, interval, "federate_to")
+	to := metricsclient.New(logger, metrics, toClient, cfg.LimitBytes, interval, "federate_to").
+		WithNativeHistograms(cfg.NativeHistograms).
+		WithProtocol(cfg.RemoteWriteProtocol, cfg.RemoteWriteCompression).
+		WithRequestTimeout(cfg.RemoteWriteTimeout).
+		WithShards(cfg.MinShards, cfg.MaxShards)
 	return from, to, transformer, nil
 }
 
 type workerMetrics struct {
 	gaugeFederateSamples         prometheus.Gauge
 	gaugeFederateFilteredSamples prometheus.Gauge
+	seriesDropped                *prometheus.CounterVec
+	recordingRuleFailures        *prometheus.CounterVec
 
 	clientMetrics *metricsclient.ClientMetrics
+	queueMetrics  *queue.Metrics
+
+	destinationWriteRequests   *prometheus.CounterVec
+	destinationShards          *prometheus.GaugeVec
+	destinationShardSentSeries *prometheus.CounterVec
+	destinationShardFailed     *prometheus.CounterVec
+	destinationShardDuration   *prometheus.HistogramVec
+	destinationSkipped         *prometheus.CounterVec
+	destinationDroppedSeries   *prometheus.CounterVec
+	destinationStaleMarkers    *prometheus.CounterVec
+	destinationQueueSegments   *prometheus.GaugeVec
+	destinationQueueBytes      *prometheus.GaugeVec
+	destinationQueueDropped    *prometheus.CounterVec
+
+	// CollectRules are the metrics of the collect rule evaluator.
+	CollectRules *CollectRuleMetrics
+}
+
+// CollectRuleMetrics report the state of the collect rules, per rule.
+type CollectRuleMetrics struct {
+	Pending       *prometheus.GaugeVec
+	Firing        *prometheus.GaugeVec
+	DynamicSeries *prometheus.GaugeVec
+}
+
+// destinationClientMetrics returns the client metrics of the destination called name.
+func (m *workerMetrics) destinationClientMetrics(name string) *metricsclient.ClientMetrics {
+	l := prometheus.Labels{"destination": name}
+	return &metricsclient.ClientMetrics{
+		FederateRequests:           m.clientMetrics.FederateRequests,
+		ForwardRemoteWriteRequests: m.destinationWriteRequests.MustCurryWith(l),
+		Shards:                     m.destinationShards.With(l),
+		ShardSentSeries:            m.destinationShardSentSeries.MustCurryWith(l),
+		ShardFailedSeries:          m.destinationShardFailed.MustCurryWith(l),
+		ShardRequestDuration:       m.destinationShardDuration.MustCurryWith(l),
+		DroppedSeries:              m.destinationDroppedSeries.MustCurryWith(l),
+		StaleMarkers:               m.destinationStaleMarkers.With(l),
+	}
+}
+
+// destinationQueueMetrics returns the queue metrics of the destination called name.
+func (m *workerMetrics) destinationQueueMetrics(name string) *queue.Metrics {
+	l := prometheus.Labels{"destination": name}
+	return &queue.Metrics{
+		Segments:     m.destinationQueueSegments.With(l),
+		Bytes:        m.destinationQueueBytes.With(l),
+		DroppedBytes: m.destinationQueueDropped.MustCurryWith(l),
+	}
 }
 
 func NewWorkerMetrics(reg *prometheus.Registry) *workerMetrics {
@@ -218,6 +385,14 @@ func NewWorkerMetrics(reg *prometheus.Registry) *workerMetrics {
 			Name: "federate_filtered_samples",
 			Help: "Tracks the number of samples filtered per federation",
 		}),
+		seriesDropped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "metrics_collector_series_dropped_total",
+			Help: "The number of series dropped because a cardinality limit was exceeded.",
+		}, []string{"metric"}),
+		recordingRuleFailures: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "metrics_collector_recording_rule_failures_total",
+			Help: "The number of times a recording rule evaluated by the collector failed.",
+		}, []string{"rule"}),
 
 		clientMetrics: &metricsclient.ClientMetrics{
 			FederateRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
@@ -229,6 +404,108 @@ func NewWorkerMetrics(reg *prometheus.Registry) *workerMetrics {
 				Name: "forward_write_requests_total",
 				Help: "Counter of forward remote write requests.",
 			}, []string{"status_code"}),
+
+			Shards: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+				Name: "forward_write_shards",
+				Help: "The number of shards concurrently sending remote write requests.",
+			}),
+			ShardSentSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "forward_write_shard_sent_series_total",
+				Help: "The number of time series sent per remote write shard.",
+			}, []string{"shard"}),
+			ShardFailedSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "forward_write_shard_failed_series_total",
+				Help: "The number of time series a remote write shard failed to send.",
+			}, []string{"shard"}),
+			ShardRequestDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "forward_write_shard_request_duration_seconds",
+				Help:    "Duration of remote write requests per shard.",
+				Buckets: prometheus.DefBuckets,
+			}, []string{"shard"}),
+			DroppedSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "forward_write_dropped_series_total",
+				Help: "The number of time series dropped because the remote write endpoint rejected them for good.",
+			}, []string{"reason"}),
+			StaleMarkers: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+				Name: "forward_write_stale_markers_total",
+				Help: "The number of staleness markers written for series that disappeared since the previous write.",
+			}),
+		},
+
+		queueMetrics: &queue.Metrics{
+			Segments: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+				Name: "forward_queue_segments",
+				Help: "The number of remote write requests buffered on disk waiting to be sent.",
+			}),
+			Bytes: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+				Name: "forward_queue_bytes",
+				Help: "The size in bytes of the remote write requests buffered on disk.",
+			}),
+			DroppedBytes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+				Name: "forward_queue_dropped_bytes_total",
+				Help: "Bytes of buffered remote write requests dropped before they could be sent.",
+			}, []string{"reason"}),
+		},
+
+		destinationWriteRequests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_write_requests_total",
+			Help: "Counter of remote write requests per additional destination.",
+		}, []string{"destination", "status_code"}),
+		destinationShards: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forward_destination_write_shards",
+			Help: "The number of shards concurrently sending remote write requests per additional destination.",
+		}, []string{"destination"}),
+		destinationShardSentSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_shard_sent_series_total",
+			Help: "The number of time series sent per additional destination and shard.",
+		}, []string{"destination", "shard"}),
+		destinationShardFailed: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_shard_failed_series_total",
+			Help: "The number of time series that failed to be sent per additional destination and shard.",
+		}, []string{"destination", "shard"}),
+		destinationShardDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "forward_destination_shard_request_duration_seconds",
+			Help:    "Duration of remote write requests per additional destination and shard.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"destination", "shard"}),
+		destinationSkipped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_skipped_total",
+			Help: "The number of intervals skipped for an additional destination because its previous write was still in flight.",
+		}, []string{"destination"}),
+		destinationDroppedSeries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_dropped_series_total",
+			Help: "The number of time series dropped because an additional destination rejected them for good.",
+		}, []string{"destination", "reason"}),
+		destinationStaleMarkers: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_stale_markers_total",
+			Help: "The number of staleness markers written per additional destination for series that disappeared.",
+		}, []string{"destination"}),
+		destinationQueueSegments: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forward_destination_queue_segments",
+			Help: "The number of remote write requests buffered on disk per additional destination.",
+		}, []string{"destination"}),
+		destinationQueueBytes: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "forward_destination_queue_bytes",
+			Help: "The size in bytes of the remote write requests buffered on disk per additional destination.",
+		}, []string{"destination"}),
+		destinationQueueDropped: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "forward_destination_queue_dropped_bytes_total",
+			Help: "Bytes of buffered remote write requests dropped per additional destination before they could be sent.",
+		}, []string{"destination", "reason"}),
+
+		CollectRules: &CollectRuleMetrics{
+			Pending: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+				Name: "collect_rule_pending",
+				Help: "The number of series for which a collect rule is pending.",
+			}, []string{"rule"}),
+			Firing: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+				Name: "collect_rule_firing",
+				Help: "The number of series for which a collect rule is firing.",
+			}, []string{"rule"}),
+			DynamicSeries: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+				Name: "collect_rule_dynamic_series",
+				Help: "The number of series selectors a firing collect rule enabled for collection.",
+			}, []string{"rule"}),
 		},
 	}
 }
@@ -236,6 +513,14 @@ func NewWorkerMetrics(reg *prometheus.Registry) *workerMetrics {
 // New creates a new Worker based on the provided Config. If the Config contains invalid
 // values, then an error is returned.
 func New(cfg Config) (*Worker, error) {
+	return newWorker(cfg, nil, nil)
+}
+
+// newWorker creates a new Worker. The queues already open, by directory, are reused
+// instead of opening a second queue on the same segment files. The series written in the
+// previous cycle are taken from staleSeries, by destination name and "" for --to-upload.
+func newWorker(cfg Config, queues map[string]*queue.Queue,
+	staleSeries map[string]*metricsclient.StaleSeries) (*Worker, error) {
 	if cfg.From == nil {
 		return nil, errors.New("a URL from which to scrape is required")
 	}
@@ -250,6 +535,9 @@ func New(cfg Config) (*Worker, error) {
 		logger:                  log.With(cfg.Logger, "component", "forwarder/worker"),
 		simulatedTimeseriesFile: cfg.SimulatedTimeseriesFile,
 		metrics:                 cfg.Metrics,
+		queue:                   queues[cfg.QueueDir],
+		limit:                   metricfamily.NewCardinalityLimit(cfg.MaxSeries, cfg.MaxSeriesPerMetric, cfg.MetricSeriesLimits),
+		cfg:                     cfg,
 	}
 
 	if w.interval == 0 {
@@ -263,9 +551,60 @@ func New(cfg Config) (*Worker, error) {
 	w.fromClient = fromClient
 	w.toClient = toClient
 	w.transformer = transformer
+	if cfg.StalenessMarkers {
+		w.staleSeries = reuseStaleSeries(staleSeries, "")
+		w.toClient.WithStaleSeries(w.staleSeries)
+	}
+
+	if w.queue == nil && len(cfg.QueueDir) > 0 {
+		w.queue, err = queue.New(queue.Config{
+			Dir:      cfg.QueueDir,
+			MaxBytes: cfg.QueueMaxBytes,
+			MaxAge:   cfg.QueueMaxAge,
+			Logger:   cfg.Logger,
+			Metrics:  w.metrics.queueMetrics,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to open queue: %w", err)
+		}
+	}
+
+	w.sourceName = cfg.SourceName
+	if len(w.sourceName) == 0 {
+		w.sourceName = status.SourceName(urlString(cfg.From))
+	}
+	sourceNames := map[string]bool{w.sourceName: true}
+	for _, s := range cfg.Sources {
+		if sourceNames[s.Name] {
+			return nil, fmt.Errorf("duplicate source %s", s.Name)
+		}
+		sourceNames[s.Name] = true
+		src, err := newSource(s, cfg, w.metrics, w.interval, logger)
+		if err != nil {
+			return nil, err
+		}
+		w.sources = append(w.sources, src)
+	}
 
-	// Configure the matching rules.
-	rules := cfg.Rules
+	names := map[string]bool{}
+	for _, d := range cfg.Destinations {
+		if names[d.Name] {
+			return nil, fmt.Errorf("duplicate destination %s", d.Name)
+		}
+		names[d.Name] = true
+		dest, err := newDestination(d, cfg, w.metrics, w.interval, queues[destinationQueueDir(cfg.QueueDir, d.Name)], logger)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.StalenessMarkers {
+			dest.staleSeries = reuseStaleSeries(staleSeries, d.Name)
+			dest.client.WithStaleSeries(dest.staleSeries)
+		}
+		w.destinations = append(w.destinations, dest)
+	}
+
+	// Configure the matching rules. Copy them, so that cfg is left untouched.
+	rules := append([]string(nil), cfg.Rules...)
 	if len(cfg.RulesFile) > 0 {
 		data, err := os.ReadFile(cfg.RulesFile)
 		if err != nil {
@@ -285,7 +624,7 @@ func New(cfg Config) (*Worker, error) {
 	w.rules = rules
 
 	// Configure the recording rules.
-	recordingRules := cfg.RecordingRules
+	recordingRules := append([]string(nil), cfg.RecordingRules...)
 	for i := 0; i < len(recordingRules); {
 		s := strings.TrimSpace(recordingRules[i])
 		if len(s) == 0 {
@@ -296,6 +635,22 @@ func New(cfg Config) (*Worker, error) {
 		i++
 	}
 	w.recordingRules = recordingRules
+	if cfg.LocalRecordingRules && len(recordingRules) > 0 {
+		rules, err := recordingrule.ParseRules(recordingRules)
+		if err != nil {
+			return nil, err
+		}
+		w.localRules = recordingrule.NewEvaluator(rules, w.interval, logger)
+	}
+
+	if len(cfg.SimulatedScenarioFile) > 0 {
+		scenario, err := simulator.LoadScenario(cfg.SimulatedScenarioFile)
+		if err != nil {
+			return nil, err
+		}
+		w.generator = simulator.NewGenerator(scenario)
+		w.report = simulator.NewReport()
+	}
 
 	s, err := status.New(logger)
 	if err != nil {
@@ -307,24 +662,85 @@ func New(cfg Config) (*Worker, error) {
 }
 
 // Reconfigure temporarily stops a worker and reconfigures is with the provided Config.
+// The clients are always recreated, so that rotated certificates and tokens are read again.
+// If the Config is invalid, the worker keeps running with its current configuration.
 // Is thread safe and can run concurrently with `LastMetrics` and `Run`.
 func (w *Worker) Reconfigure(cfg Config) error {
-	worker, err := New(cfg)
+	w.lock.Lock()
+	current := w.cfg
+	rules := w.rules
+	// Keep the queues whose directory does not change, a second queue must not
+	// manage the same segment files.
+	queues := map[string]*queue.Queue{}
+	if w.queue != nil {
+		queues[current.QueueDir] = w.queue
+	}
+	for _, d := range w.destinations {
+		if d.queue != nil {
+			queues[d.queueDir] = d.queue
+		}
+	}
+	// Keep the series written in the previous cycle, so that the series the new
+	// configuration no longer sends are marked stale.
+	staleSeries := map[string]*metricsclient.StaleSeries{"": w.staleSeries}
+	for _, d := range w.destinations {
+		staleSeries[d.name] = d.staleSeries
+	}
+	destinations := w.destinations
+	w.lock.Unlock()
+
+	worker, err := newWorker(cfg, queues, staleSeries)
 	if err != nil {
 		return fmt.Errorf("failed to reconfigure: %w", err)
 	}
+	// Wait for the writes in flight to the previous destinations, which share their queues
+	// with the new ones. The previous destinations are discarded and never send again.
+	for _, d := range destinations {
+		d.sending.Lock()
+	}
+	if worker.queue != nil {
+		worker.queue.SetLimits(cfg.QueueMaxBytes, cfg.QueueMaxAge)
+	}
+	for _, d := range worker.destinations {
+		if d.queue != nil {
+			d.queue.SetLimits(cfg.QueueMaxBytes, cfg.QueueMaxAge)
+		}
+	}
+
+	changes := configChanges(current, cfg)
+	// Compare the match rules once read from the match file.
+	if !reflect.DeepEqual(rules, worker.rules) {
+		changes = append(changes, "match")
+	}
+	rlogger.Log(w.logger, rlogger.Info, "msg", "reconfiguring worker", "changes", strings.Join(changes, ","))
 
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
+	// Keep measuring the writes of the simulation across reconfigurations.
+	if w.report != nil && worker.report != nil {
+		worker.report = w.report
+	}
+
 	w.fromClient = worker.fromClient
 	w.toClient = worker.toClient
 	w.interval = worker.interval
 	w.from = worker.from
 	w.to = worker.to
 	w.transformer = worker.transformer
+	w.queue = worker.queue
+	w.destinations = worker.destinations
+	w.staleSeries = worker.staleSeries
+	w.sourceName = worker.sourceName
+	w.sources = worker.sources
+	w.limit = worker.limit
 	w.rules = worker.rules
 	w.recordingRules = worker.recordingRules
+	w.localRules = worker.localRules
+	w.simulatedTimeseriesFile = worker.simulatedTimeseriesFile
+	w.generator = worker.generator
+	w.report = worker.report
+	w.cfg = worker.cfg
 
 	// Signal a restart to Run func.
 	// Do this in a goroutine since we do not care if restarting the Run loop is asynchronous.
@@ -332,6 +748,121 @@ func (w *Worker) Reconfigure(cfg Config) error {
 	return nil
 }
 
+// reuseStaleSeries returns the StaleSeries of name in staleSeries, or a new one.
+func reuseStaleSeries(staleSeries map[string]*metricsclient.StaleSeries, name string) *metricsclient.StaleSeries {
+	if s := staleSeries[name]; s != nil {
+		return s
+	}
+	return metricsclient.NewStaleSeries()
+}
+
+// configChanges returns the names of the settings that differ between two configurations.
+// The content of the files referenced by the settings is not compared.
+func configChanges(old, cfg Config) []string {
+	settings := []struct {
+		name    string
+		changed bool
+	}{
+		{"from", urlString(old.From) != urlString(cfg.From)},
+		{"from-query", urlString(old.FromQuery) != urlString(cfg.FromQuery)},
+		{"to-upload", urlString(old.ToUpload) != urlString(cfg.ToUpload)},
+		{"from-token", old.FromToken != cfg.FromToken},
+		{"from-token-file", old.FromTokenFile != cfg.FromTokenFile},
+		{"from-ca-file", old.FromCAFile != cfg.FromCAFile},
+		{"to-upload-ca", old.ToUploadCA != cfg.ToUploadCA},
+		{"to-upload-cert", old.ToUploadCert != cfg.ToUploadCert},
+		{"to-upload-key", old.ToUploadKey != cfg.ToUploadKey},
+		{"anonymize-labels", !reflect.DeepEqual(old.AnonymizeLabels, cfg.AnonymizeLabels)},
+		{"anonymize-salt", old.AnonymizeSalt != cfg.AnonymizeSalt || old.AnonymizeSaltFile != cfg.AnonymizeSaltFile},
+		{"anonymize-key", old.AnonymizeKeyID != cfg.AnonymizeKeyID || old.AnonymizeKeyFile != cfg.AnonymizeKeyFile},
+		{"anonymize-selectors", !reflect.DeepEqual(old.AnonymizeSelectors, cfg.AnonymizeSelectors) ||
+			old.AnonymizeFormatPreserving != cfg.AnonymizeFormatPreserving},
+		{"interval", old.Interval != cfg.Interval},
+		{"evaluate-interval", old.EvaluateInterval != cfg.EvaluateInterval},
+		{"limit-bytes", old.LimitBytes != cfg.LimitBytes},
+		{"native-histograms", old.NativeHistograms != cfg.NativeHistograms},
+		{"shards", old.MinShards != cfg.MinShards || old.MaxShards != cfg.MaxShards},
+		{"staleness-markers", old.StalenessMarkers != cfg.StalenessMarkers},
+		{"explain", old.Explain != cfg.Explain},
+		{"remote-write", old.RemoteWriteProtocol != cfg.RemoteWriteProtocol ||
+			old.RemoteWriteCompression != cfg.RemoteWriteCompression || old.RemoteWriteTimeout != cfg.RemoteWriteTimeout},
+		{"recordingrule", !reflect.DeepEqual(old.RecordingRules, cfg.RecordingRules) ||
+			old.LocalRecordingRules != cfg.LocalRecordingRules},
+		{"collectrule", !reflect.DeepEqual(old.CollectRules, cfg.CollectRules)},
+		{"collectrule-state-file", old.CollectRuleStateFile != cfg.CollectRuleStateFile},
+		{"sources", old.SourceName != cfg.SourceName || !reflect.DeepEqual(sourceURLs(old.Sources), sourceURLs(cfg.Sources))},
+		{"destinations", !reflect.DeepEqual(destinationURLs(old.Destinations), destinationURLs(cfg.Destinations))},
+		{"series-limits", old.MaxSeries != cfg.MaxSeries || old.MaxSeriesPerMetric != cfg.MaxSeriesPerMetric ||
+			!reflect.DeepEqual(old.MetricSeriesLimits, cfg.MetricSeriesLimits)},
+		{"simulated-scenario-file", old.SimulatedScenarioFile != cfg.SimulatedScenarioFile},
+		{"queue", old.QueueDir != cfg.QueueDir || old.QueueMaxBytes != cfg.QueueMaxBytes || old.QueueMaxAge != cfg.QueueMaxAge},
+	}
+
+	var changes []string
+	for _, s := range settings {
+		if s.changed {
+			changes = append(changes, s.name)
+		}
+	}
+	return changes
+}
+
+func destinationURLs(destinations []Destination) map[string]string {
+	urls := map[string]string{}
+	for _, d := range destinations {
+		urls[d.Name] = urlString(d.URL)
+	}
+	return urls
+}
+
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// MetricCardinality is the number of series of a metric in the last scrape.
+type MetricCardinality struct {
+	Metric  string `json:"metric"`
+	Series  int    `json:"series"`
+	Dropped int    `json:"dropped"`
+}
+
+// TopCardinality returns the n metrics with the most series in the last scrape, counted
+// before the cardinality limits are applied. All metrics are returned when n is not positive.
+func (w *Worker) TopCardinality(n int) []MetricCardinality {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	top := make([]MetricCardinality, 0, len(w.lastSeries))
+	for name, series := range w.lastSeries {
+		top = append(top, MetricCardinality{Metric: name, Series: series, Dropped: w.lastDropped[name]})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Series != top[j].Series {
+			return top[i].Series > top[j].Series
+		}
+		return top[i].Metric < top[j].Metric
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// SimulationReport returns the throughput and latency of the writes of the simulated
+// scenario, or nil if no scenario is simulated.
+func (w *Worker) SimulationReport() *simulator.ReportSummary {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.report == nil {
+		return nil
+	}
+	summary := w.report.Summary()
+	return &summary
+}
+
 func (w *Worker) LastMetrics() []*clientmodel.MetricFamily {
 	w.lock.Lock()
 	defer w.lock.Unlock()
@@ -365,46 +896,91 @@ func (w *Worker) Run(ctx context.Context) {
 func (w *Worker) forward(ctx context.Context) error {
 	w.lock.Lock()
 	defer w.lock.Unlock()
+	w.federated = nil
 
 	var families []*clientmodel.MetricFamily
+	var before int
 	var err error
-	if w.simulatedTimeseriesFile != "" {
-		families, err = simulator.FetchSimulatedTimeseries(w.simulatedTimeseriesFile)
-		if err != nil {
-			rlogger.Log(w.logger, rlogger.Warn, "msg", "failed fetch simulated timeseries", "err", err)
+	// failed holds the sources that could not be federated, by name.
+	var failed map[string]string
+	// incomplete is set when some series are missing from the cycle without being gone.
+	incomplete := false
+	if w.generator != nil || w.simulatedTimeseriesFile != "" || os.Getenv("SIMULATE") == "true" {
+		if w.generator != nil {
+			families = w.generator.Next(time.Now())
+		} else if w.simulatedTimeseriesFile != "" {
+			families, err = simulator.FetchSimulatedTimeseries(w.simulatedTimeseriesFile)
+			if err != nil {
+				rlogger.Log(w.logger, rlogger.Warn, "msg", "failed fetch simulated timeseries", "err", err)
+			}
+		} else {
+			families = simulator.SimulateMetrics(w.logger)
+		}
+		before = metricfamily.MetricsCount(families)
+		if err := metricfamily.Filter(families, w.transformer); err != nil {
+			w.reportStatus("Degraded", "Failed to filter metrics", failed)
+			return err
 		}
-	} else if os.Getenv("SIMULATE") == "true" {
-		families = simulator.SimulateMetrics(w.logger)
 	} else {
-		families, err = w.getFederateMetrics(ctx)
+		// The federated metrics are filtered while they are decoded, so that the
+		// metrics that are not sent are never held in memory together. The local
+		// recording rules select the metrics as federated: the families they select
+		// are set aside untransformed, and transformed once the rules are evaluated.
+		transformer := w.transformer
+		var inputs *ruleInputs
+		if w.localRules != nil {
+			inputs = &ruleInputs{rules: w.localRules, transformer: w.transformer}
+			transformer = inputs
+		}
+		families, before, failed, err = w.federate(ctx, transformer)
 		if err != nil {
-			statusErr := w.status.UpdateStatus("Degraded", "Failed to retrieve metrics")
-			if statusErr != nil {
-				rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
-			}
+			w.reportStatus("Degraded", "Failed to retrieve metrics", failed)
 			return err
 		}
 
-		rfamilies, err := w.getRecordingMetrics(ctx)
-		if err != nil && len(rfamilies) == 0 {
-			statusErr := w.status.UpdateStatus("Degraded", "Failed to retrieve recording metrics")
-			if statusErr != nil {
-				rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
+		var rfamilies []*clientmodel.MetricFamily
+		if inputs != nil {
+			var ok bool
+			rfamilies, ok = w.evaluateRecordingRules(ctx, inputs.families)
+			incomplete = incomplete || !ok
+			if err := metricfamily.Filter(inputs.families, w.transformer); err != nil {
+				w.reportStatus("Degraded", "Failed to filter metrics", failed)
+				return err
 			}
-			return err
+			families = append(families, inputs.families...)
 		} else {
-			families = append(families, rfamilies...)
+			rfamilies, err = w.getRecordingMetrics(ctx)
+			if err != nil && len(rfamilies) == 0 {
+				if len(w.sources) == 0 {
+					w.reportStatus("Degraded", "Failed to retrieve recording metrics", failed)
+					return err
+				}
+				// The recording rules query `from`, the other sources are still sent.
+				failed[w.sourceName] = "Failed to retrieve recording metrics"
+			}
+			incomplete = incomplete || err != nil
 		}
+		before += metricfamily.MetricsCount(rfamilies)
+		if err := metricfamily.Filter(rfamilies, w.transformer); err != nil {
+			w.reportStatus("Degraded", "Failed to filter metrics", failed)
+			return err
+		}
+		families = append(families, rfamilies...)
 	}
 
-	before := metricfamily.MetricsCount(families)
-	if err := metricfamily.Filter(families, w.transformer); err != nil {
-		statusErr := w.status.UpdateStatus("Degraded", "Failed to filter metrics")
-		if statusErr != nil {
-			rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
-		}
+	// Limit the cardinality last, so that the limits apply to the series actually sent.
+	w.limit.Reset()
+	if err := metricfamily.Filter(families, w.limit); err != nil {
 		return err
 	}
+	w.lastSeries = w.limit.Series()
+	w.lastDropped = w.limit.Dropped()
+	for name, n := range w.lastDropped {
+		w.metrics.seriesDropped.WithLabelValues(name).Add(float64(n))
+	}
+	if len(w.lastDropped) > 0 {
+		rlogger.Log(w.logger, rlogger.Warn, "msg", "dropped series over the cardinality limits", "metrics", len(w.lastDropped))
+	}
 
 	families = metricfamily.Pack(families)
 	after := metricfamily.MetricsCount(families)
@@ -413,63 +989,262 @@ func (w *Worker) forward(ctx context.Context) error {
 	w.metrics.gaugeFederateFilteredSamples.Set(float64(before - after))
 
 	w.lastMetrics = families
+	if w.cfg.Explain && w.federated != nil {
+		w.setExplained(&explainCycle{sources: w.federated, transformer: w.transformer, sent: families})
+	}
 
 	if len(families) == 0 {
 		rlogger.Log(w.logger, rlogger.Warn, "msg", "no metrics to send, doing nothing")
-		statusErr := w.status.UpdateStatus("Available", "No metrics to send")
-		if statusErr != nil {
-			rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
-		}
+		w.reportStatus("Available", "No metrics to send", failed)
 		return nil
 	}
 
+	if len(failed) > 0 || incomplete {
+		// The series of the failed sources and rules are missing, not gone.
+		w.staleSeries.Skip()
+		for _, d := range w.destinations {
+			d.staleSeries.Skip()
+		}
+	}
+	if len(w.lastDropped) > 0 {
+		// The series over the cardinality limits come back once the metrics have fewer series.
+		limited := make([]string, 0, len(w.lastDropped))
+		for name := range w.lastDropped {
+			limited = append(limited, name)
+		}
+		w.staleSeries.SkipMetrics(limited)
+		for _, d := range w.destinations {
+			d.staleSeries.SkipMetrics(limited)
+		}
+	}
+
+	// The additional destinations are written in the background, so that they
+	// neither delay nor depend on the main destination.
+	for _, d := range w.destinations {
+		d.send(families, w.interval)
+	}
+
 	if w.to == nil {
 		rlogger.Log(w.logger, rlogger.Warn, "msg", "to is nil, doing nothing")
-		statusErr := w.status.UpdateStatus("Available", "Metrics is not required to send")
-		if statusErr != nil {
-			rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
-		}
+		w.reportStatus("Available", "Metrics is not required to send", failed)
 		return nil
 	}
 
 	req := &http.Request{Method: "POST", URL: w.to}
-	err = w.toClient.RemoteWrite(ctx, req, families, w.interval)
+	start := time.Now()
+	err = remoteWrite(ctx, w.logger, w.toClient, w.queue, req, families, w.interval)
+	if w.report != nil {
+		w.report.Record(after, time.Since(start), err)
+		summary := w.report.Summary()
+		rlogger.Log(w.logger, rlogger.Info, "msg", "simulation report", "cycles", summary.Cycles,
+			"failures", summary.Failures, "metrics", summary.Metrics, "metrics_per_second", summary.MetricsPerSecond,
+			"latency_p50", summary.LatencyP50, "latency_p90", summary.LatencyP90, "latency_p99", summary.LatencyP99)
+	}
 	if err != nil {
-		statusErr := w.status.UpdateStatus("Degraded", "Failed to send metrics")
-		if statusErr != nil {
-			rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
-		}
-	} else if w.simulatedTimeseriesFile == "" {
-		statusErr := w.status.UpdateStatus("Available", "Cluster metrics sent successfully")
-		if statusErr != nil {
-			rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", statusErr)
+		w.reportStatus("Degraded", "Failed to send metrics", failed)
+	} else if w.simulatedTimeseriesFile == "" && w.generator == nil {
+		w.reportStatus("Available", "Cluster metrics sent successfully", failed)
+	}
+
+	return err
+}
+
+// remoteWrite sends the families with the client. When q is not nil, the metrics buffered
+// in q are replayed first, so that every series is written in timestamp order, and the
+// metrics that could not be sent are buffered in q.
+func remoteWrite(ctx context.Context, logger log.Logger, client *metricsclient.Client, q *queue.Queue,
+	req *http.Request, families []*clientmodel.MetricFamily, interval time.Duration) error {
+	if q != nil && q.Len() > 0 {
+		rlogger.Log(logger, rlogger.Info, "msg", "replaying queued metrics", "segments", q.Len())
+		err := q.Replay(func(wreq *prompb.WriteRequest) error {
+			return client.WriteTimeseries(ctx, req, wreq.Timeseries, interval)
+		})
+		if err != nil {
+			// Older metrics are still queued, queue the new ones behind them. They are
+			// marked like the written ones, so that the next write marks the series
+			// that disappear after them.
+			timeseries, convErr := client.ConvertToTimeseries(families)
+			if convErr != nil {
+				rlogger.Log(logger, rlogger.Warn, "msg", "failed to queue metrics", "err", convErr)
+				return err
+			}
+			queueTimeseries(logger, q, client.MarkStale(timeseries))
+			return err
 		}
 	}
 
+	err := client.RemoteWrite(ctx, req, families, interval)
+	var writeErr *metricsclient.WriteError
+	if q != nil && errors.As(err, &writeErr) {
+		queueTimeseries(logger, q, writeErr.Unsent)
+	}
 	return err
 }
 
-func (w *Worker) getFederateMetrics(ctx context.Context) ([]*clientmodel.MetricFamily, error) {
+func queueTimeseries(logger log.Logger, q *queue.Queue, timeseries []prompb.TimeSeries) {
+	if len(timeseries) == 0 {
+		return
+	}
+	if err := q.Store(&prompb.WriteRequest{Timeseries: timeseries}); err != nil {
+		rlogger.Log(logger, rlogger.Warn, "msg", "failed to queue metrics", "err", err)
+		return
+	}
+	rlogger.Log(logger, rlogger.Info, "msg", "queued unsent metrics", "timeseries", len(timeseries))
+}
+
+// federate retrieves the metrics of `from` and of the additional sources. A source that
+// fails is skipped and returned in failed with its status message, so that the other
+// sources are still sent. The metrics of a truncated response are sent, and its source is
+// returned in failed too. An error is returned when no source could be federated.
+func (w *Worker) federate(ctx context.Context, transformer metricfamily.Transformer) ([]*clientmodel.MetricFamily, int,
+	map[string]string, error) {
 	var families []*clientmodel.MetricFamily
-	var err error
+	count, unavailable := 0, 0
+	failed := map[string]string{}
+	var lastErr error
+	retrieve := func(name string, client *metricsclient.Client, from *url.URL, rules []string) {
+		sfamilies, n, err := w.getFederateMetrics(ctx, client, from, rules, transformer, w.keepFederated(name, rules))
+		count += n
+		switch {
+		case errors.Is(err, metricsclient.ErrPartialResponse):
+			failed[name] = "Failed to retrieve all metrics"
+			families = append(families, sfamilies...)
+		case err != nil:
+			if name != w.sourceName {
+				rlogger.Log(w.logger, rlogger.Warn, "msg", "Failed to retrieve metrics of source", "source", name, "err", err)
+			}
+			failed[name] = "Failed to retrieve metrics"
+			unavailable++
+			lastErr = err
+		default:
+			families = append(families, sfamilies...)
+		}
+	}
+
+	retrieve(w.sourceName, w.fromClient, w.from, w.rules)
+	for _, s := range w.sources {
+		retrieve(s.name, s.client, s.from, s.rules)
+	}
+	if unavailable == len(w.sources)+1 {
+		return nil, count, failed, lastErr
+	}
+	return families, count, failed, nil
+}
+
+// keepFederated returns the source that keeps the response of a source for Explain, nil when
+// it is not enabled.
+func (w *Worker) keepFederated(name string, rules []string) *federatedSource {
+	if !w.cfg.Explain {
+		return nil
+	}
+	source := &federatedSource{name: name, rules: rules}
+	w.federated = append(w.federated, source)
+	return source
+}
+
+// reportStatus reports the state of every source: the sources in failed with their own
+// message, the other ones with t and m.
+func (w *Worker) reportStatus(t, m string, failed map[string]string) {
+	names := []string{w.sourceName}
+	for _, s := range w.sources {
+		names = append(names, s.name)
+	}
+	sources := make([]status.Source, 0, len(names))
+	for _, name := range names {
+		if msg, ok := failed[name]; ok {
+			sources = append(sources, status.Source{Name: name, Type: "Degraded", Message: msg})
+			continue
+		}
+		sources = append(sources, status.Source{Name: name, Type: t, Message: m})
+	}
+	if err := w.status.UpdateSources(sources); err != nil {
+		rlogger.Log(w.logger, rlogger.Warn, "msg", failedStatusReportMsg, "err", err)
+	}
+}
+
+// getFederateMetrics retrieves the metrics of from matching the rules and applies the
+// transformer to every family as it is decoded, keeping only the families that are sent.
+// The families are kept as federated when transformer is nil. The response is kept in keep,
+// unless it is nil.
+// It also returns the number of metrics retrieved before filtering.
+func (w *Worker) getFederateMetrics(ctx context.Context, client *metricsclient.Client, from *url.URL,
+	rules []string, transformer metricfamily.Transformer, keep *federatedSource) ([]*clientmodel.MetricFamily, int, error) {
+	var families []*clientmodel.MetricFamily
+	count := 0
 
 	// reset query from last invocation, otherwise match rules will be appended
-	from := w.from
 	from.RawQuery = ""
 	v := from.Query()
-	for _, rule := range w.rules {
+	for _, rule := range rules {
 		v.Add("match[]", rule)
 	}
 	from.RawQuery = v.Encode()
 
 	req := &http.Request{Method: "GET", URL: from}
-	families, err = w.fromClient.Retrieve(ctx, req)
+	fn := func(family *clientmodel.MetricFamily) error {
+		count += len(family.Metric)
+		if transformer == nil {
+			families = append(families, family)
+			return nil
+		}
+		ok, err := transformer.Transform(family)
+		if err != nil {
+			return fmt.Errorf("failed to filter metrics: %w", err)
+		}
+		if ok {
+			families = append(families, family)
+		}
+		return nil
+	}
+	var err error
+	if keep != nil {
+		keep.response, err = client.RetrieveResponseFunc(ctx, req, fn)
+	} else {
+		err = client.RetrieveFunc(ctx, req, fn)
+	}
 	if err != nil {
 		rlogger.Log(w.logger, rlogger.Warn, "msg", "Failed to retrieve metrics", "err", err)
-		return families, err
+		return families, count, err
 	}
 
-	return families, nil
+	return families, count, nil
+}
+
+// evaluateRecordingRules evaluates the recording rules over the federated families. A rule
+// that fails is logged and counted, the metrics of the other rules are still sent. It
+// returns false when a rule failed, its series are then missing from the cycle.
+func (w *Worker) evaluateRecordingRules(ctx context.Context, families []*clientmodel.MetricFamily) ([]*clientmodel.MetricFamily, bool) {
+	// The from client expands the histograms into the float series the rules select.
+	timeseries, err := w.fromClient.ConvertToTimeseries(families)
+	if err != nil {
+		rlogger.Log(w.logger, rlogger.Warn, "msg", "Failed to evaluate recording rules", "err", err)
+		return nil, false
+	}
+	rfamilies, failed := w.localRules.Evaluate(ctx, timeseries, time.Now())
+	for name, err := range failed {
+		rlogger.Log(w.logger, rlogger.Warn, "msg", "Failed to evaluate recording rule", "rule", name, "err", err)
+		w.metrics.recordingRuleFailures.WithLabelValues(name).Inc()
+	}
+	return rfamilies, len(failed) == 0
+}
+
+// ruleInputs sets aside the federated families selected by the local recording rules, as
+// federated, and applies the transformer to the other ones.
+type ruleInputs struct {
+	rules       *recordingrule.Evaluator
+	transformer metricfamily.Transformer
+	families    []*clientmodel.MetricFamily
+}
+
+func (r *ruleInputs) Transform(family *clientmodel.MetricFamily) (bool, error) {
+	if r.rules.Selects(family) {
+		r.families = append(r.families, family)
+		return false, nil
+	}
+	if r.transformer == nil {
+		return true, nil
+	}
+	return r.transformer.Transform(family)
 }
 
 func (w *Worker) getRecordingMetrics(ctx context.Context) ([]*clientmodel.MetricFamily, error) {
@@ -11,11 +11,16 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricfamily"
 )
 
 // Base64 encoded CA cert string
@@ -213,6 +218,103 @@ func TestReconfigure(t *testing.T) {
 			t.Errorf("test case %d: got %q, expected %s error", i, err, no)
 		}
 	}
+
+	// An invalid configuration leaves the running configuration in place.
+	if w.from != from2 {
+		t.Errorf("expected worker to keep its last valid configuration, got from %v", w.from)
+	}
+}
+
+func TestReconfigureKeepsQueue(t *testing.T) {
+	from, err := url.Parse("https://redhat.com")
+	if err != nil {
+		t.Fatalf("failed to parse `from` URL: %v", err)
+	}
+	c := Config{
+		From:     from,
+		QueueDir: t.TempDir(),
+		Logger:   log.NewNopLogger(),
+		Metrics:  NewWorkerMetrics(prometheus.NewRegistry()),
+	}
+	w, err := New(c)
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+	q := w.queue
+
+	c.Interval = time.Minute
+	c.QueueMaxBytes = 1024
+	if err := w.Reconfigure(c); err != nil {
+		t.Fatalf("failed to reconfigure worker: %v", err)
+	}
+	if w.queue != q {
+		t.Error("expected the queue to be kept when its directory does not change")
+	}
+
+	c.QueueDir = t.TempDir()
+	if err := w.Reconfigure(c); err != nil {
+		t.Fatalf("failed to reconfigure worker: %v", err)
+	}
+	if w.queue == q {
+		t.Error("expected a new queue when its directory changes")
+	}
+}
+
+func TestReconfigureKeepsStaleSeries(t *testing.T) {
+	from, err := url.Parse("https://redhat.com")
+	if err != nil {
+		t.Fatalf("failed to parse `from` URL: %v", err)
+	}
+	c := Config{
+		From:             from,
+		StalenessMarkers: true,
+		Logger:           log.NewNopLogger(),
+		Metrics:          NewWorkerMetrics(prometheus.NewRegistry()),
+	}
+	w, err := New(c)
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+	s := w.staleSeries
+	if s == nil {
+		t.Fatal("expected the worker to track stale series")
+	}
+
+	c.Rules = []string{`{__name__="up"}`}
+	if err := w.Reconfigure(c); err != nil {
+		t.Fatalf("failed to reconfigure worker: %v", err)
+	}
+	if w.staleSeries != s {
+		t.Error("expected the series of the previous cycle to be kept, so that dropped series are marked stale")
+	}
+
+	c.StalenessMarkers = false
+	if err := w.Reconfigure(c); err != nil {
+		t.Fatalf("failed to reconfigure worker: %v", err)
+	}
+	if w.staleSeries != nil {
+		t.Error("expected no stale series tracking when staleness markers are disabled")
+	}
+}
+
+func TestConfigChanges(t *testing.T) {
+	from, _ := url.Parse("https://redhat.com")
+	from2, _ := url.Parse("https://example.com")
+	old := Config{From: from, Interval: time.Minute, ToUploadCert: "tls.crt"}
+
+	if changes := configChanges(old, old); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	cfg := old
+	cfg.From = from2
+	cfg.Interval = 2 * time.Minute
+	cfg.MaxShards = 4
+	changes := configChanges(old, cfg)
+	expected := []string{"from", "interval", "shards"}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
 }
 
 // TestRun tests the Run method of the Worker type.
@@ -286,3 +388,123 @@ func TestRun(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestForwardLocalRecordingRules(t *testing.T) {
+	from := federateServer(t, "up", `{__name__="up"}`, "")
+	defer from.Close()
+	query := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		t.Error("expected the recording rules not to be queried")
+	}))
+	defer query.Close()
+
+	received := make(chan []string, 1)
+	to := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received <- receivedNames(t, r)
+	}))
+	defer to.Close()
+
+	fromURL, _ := url.Parse(from.URL)
+	queryURL, _ := url.Parse(query.URL)
+	toURL, _ := url.Parse(to.URL)
+	w, err := New(Config{
+		From:      fromURL,
+		FromQuery: queryURL,
+		ToUpload:  toURL,
+		Rules:     []string{`{__name__="up"}`},
+		RecordingRules: []string{
+			`{"name":"up:sum","query":"sum(up)"}`,
+			`{"name":"up:invalid","query":"label_replace(up, \"x\", \"$1\", \"job\", \"(\")"}`,
+		},
+		LocalRecordingRules: true,
+		Logger:              log.NewNopLogger(),
+		Metrics:             NewWorkerMetrics(prometheus.NewRegistry()),
+	})
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+
+	// The rule that fails to evaluate does not degrade the cycle.
+	if err := w.forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case names := <-received:
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, []string{"up", "up:sum"}) {
+			t.Errorf("expected the federated and recorded metrics, got %v", names)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no metrics received")
+	}
+
+	if _, err := New(Config{
+		From:                fromURL,
+		RecordingRules:      []string{`{"name":"up:sum","query":"sum(up"}`},
+		LocalRecordingRules: true,
+		Logger:              log.NewNopLogger(),
+		Metrics:             NewWorkerMetrics(prometheus.NewRegistry()),
+	}); err == nil {
+		t.Error("expected an invalid recording rule to be rejected")
+	}
+}
+
+func TestForwardLocalRecordingRulesTransformedOnce(t *testing.T) {
+	from := federateServer(t, "up", `{__name__="up"}`, "")
+	defer from.Close()
+
+	received := make(chan []string, 1)
+	to := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received <- receivedNames(t, r)
+	}))
+	defer to.Close()
+
+	fromURL, _ := url.Parse(from.URL)
+	toURL, _ := url.Parse(to.URL)
+	w, err := New(Config{
+		From:                fromURL,
+		ToUpload:            toURL,
+		Rules:               []string{`{__name__="up"}`},
+		RecordingRules:      []string{`{"name":"up:sum","query":"sum(up)"}`},
+		LocalRecordingRules: true,
+		// The rules select the federated names, the renames apply to the metrics sent.
+		Transformer: metricfamily.RenameMetrics{Names: map[string]string{"up": "cluster_up", "up:sum": "cluster_up:sum"}},
+		Logger:      log.NewNopLogger(),
+		Metrics:     NewWorkerMetrics(prometheus.NewRegistry()),
+	})
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+
+	if err := w.forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case names := <-received:
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, []string{"cluster_up", "cluster_up:sum"}) {
+			t.Errorf("expected the renamed federated and recorded metrics, got %v", names)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no metrics received")
+	}
+}
+
+func TestTopCardinality(t *testing.T) {
+	w := &Worker{
+		lastSeries:  map[string]int{"a": 10, "b": 300, "c": 10, "d": 1},
+		lastDropped: map[string]int{"b": 100},
+	}
+
+	top := w.TopCardinality(3)
+	expected := []MetricCardinality{
+		{Metric: "b", Series: 300, Dropped: 100},
+		{Metric: "a", Series: 10},
+		{Metric: "c", Series: 10},
+	}
+	if !reflect.DeepEqual(top, expected) {
+		t.Errorf("expected %v, got %v", expected, top)
+	}
+	if all := w.TopCardinality(0); len(all) != 4 {
+		t.Errorf("expected all 4 metrics, got %v", all)
+	}
+}
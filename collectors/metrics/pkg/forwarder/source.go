@@ -0,0 +1,77 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/metricsclient"
+)
+
+// Source is an additional Prometheus instance to federate from, such as the user workload
+// Prometheus, with its own authentication and match rules. Its metrics are transformed
+// and sent along with the metrics of `From`. The only required fields are `Name` and `URL`.
+type Source struct {
+	Name string
+	URL  *url.URL
+
+	// CAFile verifies the server certificate in addition to the system certificates.
+	CAFile string
+	// BearerToken or BearerTokenFile authenticate the collector with a token.
+	BearerToken     string
+	BearerTokenFile string
+
+	// Matches select the federated metrics, in the format of the match rules.
+	Matches []string
+}
+
+// source federates the metrics of a Source.
+type source struct {
+	name   string
+	from   *url.URL
+	client *metricsclient.Client
+	rules  []string
+}
+
+func newSource(s Source, cfg Config, metrics *workerMetrics, interval time.Duration, logger log.Logger) (*source, error) {
+	if len(s.Name) == 0 {
+		return nil, errors.New("a source name is required")
+	}
+	if s.URL == nil {
+		return nil, fmt.Errorf("a URL is required for source %s", s.Name)
+	}
+	if len(s.Matches) == 0 {
+		return nil, fmt.Errorf("match rules are required for source %s", s.Name)
+	}
+
+	// The source is reached like `From`, with its own CA and token.
+	cfg.FromCAFile = s.CAFile
+	cfg.FromToken = s.BearerToken
+	cfg.FromTokenFile = s.BearerTokenFile
+	client, err := CreateFromClient(cfg, metrics, interval, "federate_from_"+s.Name, log.With(logger, "source", s.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client of source %s: %w", s.Name, err)
+	}
+
+	return &source{
+		name:   s.Name,
+		from:   s.URL,
+		client: client,
+		rules:  append([]string(nil), s.Matches...),
+	}, nil
+}
+
+func sourceURLs(sources []Source) map[string]string {
+	urls := map[string]string{}
+	for _, s := range sources {
+		urls[s.Name] = urlString(s.URL)
+	}
+	return urls
+}
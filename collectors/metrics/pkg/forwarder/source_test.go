@@ -0,0 +1,123 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// federateServer serves name as the only federated metric, if the request matches rule.
+func federateServer(t *testing.T, name, rule, token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(token) > 0 && r.Header.Get("Authorization") != "Bearer "+token {
+			t.Errorf("expected the token of source %s, got %q", name, r.Header.Get("Authorization"))
+		}
+		if got := r.URL.Query()["match[]"]; !reflect.DeepEqual(got, []string{rule}) {
+			t.Errorf("expected the match rules of source %s, got %v", name, got)
+		}
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s 1 %d\n", name, name, time.Now().UnixMilli())
+	}))
+}
+
+func TestForwardSources(t *testing.T) {
+	platform := federateServer(t, "up", `{__name__="up"}`, "")
+	defer platform.Close()
+	uwl := federateServer(t, "app_requests_total", `{__name__="app_requests_total"}`, "uwl-token")
+	defer uwl.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	received := make(chan []string, 1)
+	to := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received <- receivedNames(t, r)
+	}))
+	defer to.Close()
+
+	parse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("failed to parse URL: %v", err)
+		}
+		return u
+	}
+	w, err := New(Config{
+		From:     parse(platform.URL),
+		ToUpload: parse(to.URL),
+		Rules:    []string{`{__name__="up"}`},
+		Sources: []Source{
+			{
+				Name:        "user-workload",
+				URL:         parse(uwl.URL),
+				BearerToken: "uwl-token",
+				Matches:     []string{`{__name__="app_requests_total"}`},
+			},
+			{
+				Name:    "broken",
+				URL:     parse(broken.URL),
+				Matches: []string{`{__name__="up"}`},
+			},
+		},
+		Logger:  log.NewNopLogger(),
+		Metrics: NewWorkerMetrics(prometheus.NewRegistry()),
+	})
+	if err != nil {
+		t.Fatalf("failed to create new worker: %v", err)
+	}
+	if w.sourceName != "platform" {
+		t.Errorf("expected the from source to be named platform, got %s", w.sourceName)
+	}
+
+	// A failing source does not prevent the other sources from being sent.
+	if err := w.forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case names := <-received:
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, []string{"app_requests_total", "up"}) {
+			t.Errorf("expected the metrics of both working sources, got %v", names)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no metrics received")
+	}
+}
+
+func TestNewSourceValidation(t *testing.T) {
+	u, _ := url.Parse("https://prometheus-user-workload:9092")
+	metrics := NewWorkerMetrics(prometheus.NewRegistry())
+	for _, s := range []Source{
+		{URL: u, Matches: []string{`{__name__="up"}`}},
+		{Name: "uwl", Matches: []string{`{__name__="up"}`}},
+		{Name: "uwl", URL: u},
+	} {
+		if _, err := newSource(s, Config{}, metrics, time.Minute, log.NewNopLogger()); err == nil {
+			t.Errorf("expected source %+v to be rejected", s)
+		}
+	}
+
+	from, _ := url.Parse("https://redhat.com")
+	_, err := New(Config{
+		From:    from,
+		Sources: []Source{{Name: "platform", URL: u, Matches: []string{`{__name__="up"}`}}},
+		Logger:  log.NewNopLogger(),
+		Metrics: metrics,
+	})
+	if err == nil {
+		t.Error("expected a source named like the from source to be rejected")
+	}
+}
@@ -46,7 +46,7 @@ func ReloadRoutes(mux *http.ServeMux, reload func() error) *http.ServeMux {
 		}
 
 		if err := reload(); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -0,0 +1,142 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+// CardinalityLimit is a Transformer that bounds the number of series sent per metric name and
+// in total. When a limit is exceeded, the series with the lowest label hashes are kept, so that
+// the same series are dropped from one scrape to the next.
+// Reset must be called before the families of each scrape are transformed.
+type CardinalityLimit struct {
+	// MaxSeries bounds the total number of series. Zero means no limit.
+	MaxSeries int
+	// MaxSeriesPerMetric bounds the number of series of every metric name. Zero means no limit.
+	MaxSeriesPerMetric int
+	// MetricLimits overrides MaxSeriesPerMetric for the given metric names.
+	MetricLimits map[string]int
+
+	mu      sync.Mutex
+	total   int
+	series  map[string]int
+	dropped map[string]int
+}
+
+// NewCardinalityLimit creates a new CardinalityLimit.
+func NewCardinalityLimit(maxSeries, maxSeriesPerMetric int, metricLimits map[string]int) *CardinalityLimit {
+	l := &CardinalityLimit{
+		MaxSeries:          maxSeries,
+		MaxSeriesPerMetric: maxSeriesPerMetric,
+		MetricLimits:       metricLimits,
+	}
+	l.Reset()
+	return l
+}
+
+// Reset starts a new scrape.
+func (l *CardinalityLimit) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total = 0
+	l.series = map[string]int{}
+	l.dropped = map[string]int{}
+}
+
+// Series returns the number of series per metric name seen since the last Reset, before limiting.
+func (l *CardinalityLimit) Series() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return copyCounts(l.series)
+}
+
+// Dropped returns the number of series dropped per metric name since the last Reset.
+func (l *CardinalityLimit) Dropped() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return copyCounts(l.dropped)
+}
+
+// Transform implements the Transformer interface.
+func (l *CardinalityLimit) Transform(family *clientmodel.MetricFamily) (bool, error) {
+	if family == nil {
+		return true, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name := family.GetName()
+	var indexes []int
+	for i, m := range family.Metric {
+		if m != nil {
+			indexes = append(indexes, i)
+		}
+	}
+	l.series[name] += len(indexes)
+
+	limit := len(indexes)
+	if n, ok := l.MetricLimits[name]; ok {
+		limit = minInt(limit, n)
+	} else if l.MaxSeriesPerMetric > 0 {
+		limit = minInt(limit, l.MaxSeriesPerMetric)
+	}
+	if l.MaxSeries > 0 {
+		limit = minInt(limit, maxInt(l.MaxSeries-l.total, 0))
+	}
+	l.total += limit
+
+	if limit == len(indexes) {
+		return len(indexes) > 0, nil
+	}
+
+	hashes := make(map[int]uint64, len(indexes))
+	for _, i := range indexes {
+		hashes[i] = metricHash(family.Metric[i])
+	}
+	sort.SliceStable(indexes, func(a, b int) bool { return hashes[indexes[a]] < hashes[indexes[b]] })
+	for _, i := range indexes[limit:] {
+		family.Metric[i] = nil
+	}
+	l.dropped[name] += len(indexes) - limit
+	return limit > 0, nil
+}
+
+func metricHash(m *clientmodel.Metric) uint64 {
+	h := fnv.New64a()
+	for _, l := range m.Label {
+		_, _ = h.Write([]byte(l.GetName()))
+		_, _ = h.Write([]byte{0xff})
+		_, _ = h.Write([]byte(l.GetValue()))
+		_, _ = h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	c := make(map[string]int, len(counts))
+	for k, v := range counts {
+		c[k] = v
+	}
+	return c
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"fmt"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+func familyWithSeries(name string, n int) *clientmodel.MetricFamily {
+	var labels [][]*clientmodel.LabelPair
+	for i := 0; i < n; i++ {
+		labels = append(labels, labelPairs("id", fmt.Sprint(i)))
+	}
+	return familyWithLabels(name, labels...)
+}
+
+func keptIDs(family *clientmodel.MetricFamily) map[string]bool {
+	ids := map[string]bool{}
+	for _, m := range family.Metric {
+		if m != nil {
+			ids[m.Label[0].GetValue()] = true
+		}
+	}
+	return ids
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	l := NewCardinalityLimit(12, 5, map[string]int{"allowed": 10})
+
+	families := []*clientmodel.MetricFamily{
+		familyWithSeries("allowed", 8),
+		familyWithSeries("exploding", 100),
+		familyWithSeries("small", 3),
+	}
+	if err := Filter(families, l); err != nil {
+		t.Fatal(err)
+	}
+
+	// allowed keeps its 8 series under its own limit, exploding is limited per metric,
+	// and small is cut by the total limit of 12 series.
+	for name, want := range map[string]int{"allowed": 8, "exploding": 4, "small": 0} {
+		var family *clientmodel.MetricFamily
+		for i, f := range []string{"allowed", "exploding", "small"} {
+			if f == name {
+				family = families[i]
+			}
+		}
+		if want == 0 {
+			if family != nil {
+				t.Errorf("expected %s to be dropped, got %d series", name, len(keptIDs(family)))
+			}
+			continue
+		}
+		if got := len(keptIDs(family)); got != want {
+			t.Errorf("expected %d series of %s, got %d", want, name, got)
+		}
+	}
+
+	dropped := l.Dropped()
+	if dropped["exploding"] != 96 || dropped["small"] != 3 || dropped["allowed"] != 0 {
+		t.Errorf("unexpected dropped series %v", dropped)
+	}
+	if series := l.Series(); series["exploding"] != 100 {
+		t.Errorf("expected 100 series of exploding before limiting, got %d", series["exploding"])
+	}
+
+	// The same series are kept on the next scrape.
+	kept := keptIDs(families[1])
+	l.Reset()
+	next := familyWithSeries("exploding", 100)
+	if _, err := l.Transform(next); err != nil {
+		t.Fatal(err)
+	}
+	nextKept := keptIDs(next)
+	if len(nextKept) != 5 {
+		t.Errorf("expected 5 series after reset, got %d", len(nextKept))
+	}
+	for id := range kept {
+		if !nextKept[id] {
+			t.Errorf("expected series %s to be kept across scrapes", id)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"github.com/golang/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+// Clone returns a deep copy of the given families, so that they can be
+// transformed without modifying the originals. Nil families are skipped.
+func Clone(families []*clientmodel.MetricFamily) []*clientmodel.MetricFamily {
+	clones := make([]*clientmodel.MetricFamily, 0, len(families))
+	for _, family := range families {
+		if family == nil {
+			continue
+		}
+		clones = append(clones, proto.Clone(family).(*clientmodel.MetricFamily))
+	}
+	return clones
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+// ExplainStep is the change a transformer made to a family.
+type ExplainStep struct {
+	Transformer string `json:"transformer"`
+	// Name is the new name of the family when the transformer renamed it.
+	Name          string   `json:"name,omitempty"`
+	RemovedLabels []string `json:"removedLabels,omitempty"`
+	AddedLabels   []string `json:"addedLabels,omitempty"`
+	ChangedLabels []string `json:"changedLabels,omitempty"`
+	// Dropped is set when the transformer dropped the family or all its metrics.
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+// Explain transforms family like Transform, and returns the changes made by every
+// transformer that changed it, in the order they were applied.
+func (a MultiTransformer) Explain(family *clientmodel.MetricFamily) ([]ExplainStep, bool, error) {
+	var steps []ExplainStep
+	for _, f := range a.builderFuncs {
+		ok, err := explain(f(), family, &steps)
+		if err != nil || !ok {
+			return steps, false, err
+		}
+	}
+	for _, t := range a.transformers {
+		ok, err := explain(t, family, &steps)
+		if err != nil || !ok {
+			return steps, false, err
+		}
+	}
+	return steps, true, nil
+}
+
+func explain(t Transformer, family *clientmodel.MetricFamily, steps *[]ExplainStep) (bool, error) {
+	// The nested transformers are explained one by one.
+	if m, ok := t.(MultiTransformer); ok {
+		nested, ok, err := m.Explain(family)
+		*steps = append(*steps, nested...)
+		return ok, err
+	}
+
+	name, before, metrics := family.GetName(), labelValues(family), metricsCount(family)
+	ok, err := t.Transform(family)
+	if err != nil {
+		return false, err
+	}
+	after := labelValues(family)
+
+	step := ExplainStep{Transformer: transformerName(t), Dropped: !ok || metricsCount(family) == 0 && metrics > 0}
+	if family.GetName() != name {
+		step.Name = family.GetName()
+	}
+	for label, values := range before {
+		v, found := after[label]
+		switch {
+		case !found:
+			step.RemovedLabels = append(step.RemovedLabels, label)
+		case !reflect.DeepEqual(v, values):
+			step.ChangedLabels = append(step.ChangedLabels, label)
+		}
+	}
+	for label := range after {
+		if _, found := before[label]; !found {
+			step.AddedLabels = append(step.AddedLabels, label)
+		}
+	}
+	if step.Dropped {
+		// The labels of the dropped metrics are not removed.
+		step.RemovedLabels, step.ChangedLabels = nil, nil
+	}
+	if step.Dropped || len(step.Name) > 0 || len(step.RemovedLabels) > 0 || len(step.AddedLabels) > 0 || len(step.ChangedLabels) > 0 {
+		sort.Strings(step.RemovedLabels)
+		sort.Strings(step.AddedLabels)
+		sort.Strings(step.ChangedLabels)
+		*steps = append(*steps, step)
+	}
+	return ok, nil
+}
+
+// labelValues returns the sorted values of every label of the metrics of family, so that
+// sorting the metrics does not change them. The metrics that were dropped are skipped.
+func labelValues(family *clientmodel.MetricFamily) map[string][]string {
+	values := map[string][]string{}
+	for _, m := range family.Metric {
+		if m == nil {
+			continue
+		}
+		for _, l := range m.Label {
+			values[l.GetName()] = append(values[l.GetName()], l.GetValue())
+		}
+	}
+	for _, v := range values {
+		sort.Strings(v)
+	}
+	return values
+}
+
+func metricsCount(family *clientmodel.MetricFamily) int {
+	count := 0
+	for _, m := range family.Metric {
+		if m != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// transformerName returns the type of t, or the name of the function of a TransformerFunc.
+func transformerName(t Transformer) string {
+	name := fmt.Sprintf("%T", t)
+	if f, ok := t.(TransformerFunc); ok {
+		name = runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	}
+	return strings.TrimPrefix(name[strings.LastIndex(name, "/")+1:], "*")
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+func TestExplain(t *testing.T) {
+	allowlist, err := NewAllowlist([]string{`{namespace="default"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nested MultiTransformer
+	nested.With(NewElide("node"))
+	var transformer MultiTransformer
+	transformer.WithFunc(func() Transformer {
+		return NewLabel(map[string]string{"cluster": "c1"}, nil)
+	})
+	transformer.With(nested)
+	transformer.With(TransformerFunc(SortMetrics))
+	transformer.With(allowlist)
+
+	f := podFamily("kube_pod_info", "default", "api-1", "worker-1")
+	steps, ok, err := transformer.Explain(f)
+	if err != nil || !ok {
+		t.Fatalf("expected the family to be kept, got %v, %v", ok, err)
+	}
+	// The sort and the allowlist do not change the family.
+	expected := []ExplainStep{
+		{Transformer: "metricfamily.label", AddedLabels: []string{"cluster"}},
+		{Transformer: "metricfamily.elide", RemovedLabels: []string{"node"}},
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected steps %+v, got %+v", expected, steps)
+	}
+
+	f = podFamily("kube_pod_info", "payments", "api-1", "worker-1")
+	f.Metric[0].Label = append(f.Metric[0].Label, &clientmodel.LabelPair{Name: proto.String("cluster"), Value: proto.String("c0")})
+	steps, ok, _ = transformer.Explain(f)
+	expected = []ExplainStep{
+		{Transformer: "metricfamily.label", ChangedLabels: []string{"cluster"}},
+		{Transformer: "metricfamily.elide", RemovedLabels: []string{"node"}},
+		{Transformer: "metricfamily.allowlist", Dropped: true},
+	}
+	if ok || !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected steps %+v, got %v, %+v", expected, ok, steps)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// AnonymizeSelector selects the labels to anonymize on the metrics that match a series selector.
+type AnonymizeSelector struct {
+	Match  string
+	Labels []string
+}
+
+// KeyedAnonymizerConfig configures a KeyedAnonymizer.
+type KeyedAnonymizerConfig struct {
+	// KeyID identifies Key in the anonymized values and in the mapping, so that the key can be
+	// rotated: the values anonymized with the previous key remain attributed to it.
+	KeyID string
+	Key   []byte
+	// Labels are anonymized on every metric, Selectors on the metrics they match.
+	Labels    []string
+	Selectors []AnonymizeSelector
+	// FormatPreserving replaces every letter and digit of a value with a letter or digit of
+	// the same case, and keeps the other characters, so that the values keep their format.
+	// These tokens do not carry the key ID, and are not unique: short values collide, a
+	// single digit has 10 tokens. The mapping keeps every value of a token, with its key ID.
+	FormatPreserving bool
+	// Mapping records the original values, it may be nil.
+	Mapping *AnonymizeMapping
+}
+
+type anonymizeSelector struct {
+	matchers []*labels.Matcher
+	labels   map[string]struct{}
+}
+
+// KeyedAnonymizer replaces label values with their HMAC-SHA256 under a secret key, identified
+// by a key ID so that the key can be rotated. The same value is anonymized the same way in every
+// label, so that the anonymized metrics can still be joined. This type is not thread-safe.
+type KeyedAnonymizer struct {
+	keyID            string
+	key              []byte
+	global           map[string]struct{}
+	selectors        []anonymizeSelector
+	formatPreserving bool
+	mapping          *AnonymizeMapping
+}
+
+// NewKeyedAnonymizer returns a KeyedAnonymizer, or an error if a selector is invalid.
+func NewKeyedAnonymizer(cfg KeyedAnonymizerConfig) (*KeyedAnonymizer, error) {
+	if len(cfg.KeyID) == 0 || len(cfg.Key) == 0 {
+		return nil, errors.New("an anonymization key and key ID are required")
+	}
+	a := &KeyedAnonymizer{
+		keyID:            cfg.KeyID,
+		key:              append([]byte(nil), cfg.Key...),
+		global:           labelSet(cfg.Labels),
+		formatPreserving: cfg.FormatPreserving,
+		mapping:          cfg.Mapping,
+	}
+	for _, s := range cfg.Selectors {
+		matchers, err := parser.ParseMetricSelector(s.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid anonymize selector %s: %w", s.Match, err)
+		}
+		if len(s.Labels) == 0 {
+			return nil, fmt.Errorf("labels are required for anonymize selector %s", s.Match)
+		}
+		a.selectors = append(a.selectors, anonymizeSelector{matchers: matchers, labels: labelSet(s.Labels)})
+	}
+	return a, nil
+}
+
+func labelSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
+
+func (a *KeyedAnonymizer) Transform(family *clientmodel.MetricFamily) (bool, error) {
+	if family == nil {
+		return false, nil
+	}
+	for _, m := range family.Metric {
+		if m == nil {
+			continue
+		}
+		// The selectors are matched against the labels before any of them is anonymized.
+		sets := []map[string]struct{}{a.global}
+		for _, s := range a.selectors {
+			if match(family.GetName(), m, s.matchers...) {
+				sets = append(sets, s.labels)
+			}
+		}
+		for _, pair := range m.Label {
+			if pair.Value == nil || *pair.Value == "" || !inSets(pair.GetName(), sets) {
+				continue
+			}
+			v := a.anonymize(pair.GetValue())
+			a.mapping.record(AnonymizedValue{Token: v, KeyID: a.keyID, Label: pair.GetName(), Value: pair.GetValue()})
+			pair.Value = &v
+		}
+	}
+	return true, nil
+}
+
+func inSets(name string, sets []map[string]struct{}) bool {
+	for _, set := range sets {
+		if _, ok := set[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymize returns the token of value: the key ID and the truncated HMAC of the value,
+// or the value with its letters and digits replaced when the format is preserved.
+func (a *KeyedAnonymizer) anonymize(value string) string {
+	if !a.formatPreserving {
+		sum := a.sum([]byte(value), 0)
+		return a.keyID + "." + base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+
+	token := []rune(value)
+	var stream []byte
+	for i, r := range token {
+		// Every character takes a byte of the HMAC of the value and a block counter.
+		if i%sha256.Size == 0 {
+			stream = a.sum([]byte(value), uint32(i/sha256.Size))
+		}
+		b := stream[i%sha256.Size]
+		switch {
+		case r >= '0' && r <= '9':
+			token[i] = '0' + rune(b%10)
+		case r >= 'a' && r <= 'z':
+			token[i] = 'a' + rune(b%26)
+		case r >= 'A' && r <= 'Z':
+			token[i] = 'A' + rune(b%26)
+		}
+	}
+	return string(token)
+}
+
+func (a *KeyedAnonymizer) sum(value []byte, block uint32) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	var counter [4]byte
+	binary.BigEndian.PutUint32(counter[:], block)
+	mac.Write(counter[:])
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// AnonymizedValue is an anonymized label value and the value it replaces.
+type AnonymizedValue struct {
+	Token string `json:"token"`
+	KeyID string `json:"keyID"`
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// AnonymizeMapping records the original values of the anonymized label values, so that the
+// operators allowed to read it can de-anonymize the metrics during an incident. The values are
+// recorded by key ID, label and token, and all the values of a token are kept, as the format
+// preserving tokens collide. Only the most recently anonymized values are kept.
+// AnonymizeMapping is thread safe and outlives the anonymizers, that are recreated on every
+// reconfiguration.
+type AnonymizeMapping struct {
+	lock sync.Mutex
+	// values holds the recorded values by token.
+	values map[string][]AnonymizedValue
+	// recorded is a ring of the recorded values in the order they were recorded, next is the
+	// index of the oldest one once the ring is full.
+	recorded []AnonymizedValue
+	next     int
+	max      int
+}
+
+// NewAnonymizeMapping returns a mapping of at most maxValues values.
+func NewAnonymizeMapping(maxValues int) *AnonymizeMapping {
+	return &AnonymizeMapping{values: map[string][]AnonymizedValue{}, max: maxValues}
+}
+
+func (m *AnonymizeMapping) record(v AnonymizedValue) {
+	if m == nil || m.max <= 0 {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, recorded := range m.values[v.Token] {
+		if recorded == v {
+			return
+		}
+	}
+	if len(m.recorded) < m.max {
+		m.recorded = append(m.recorded, v)
+	} else {
+		m.remove(m.recorded[m.next])
+		m.recorded[m.next] = v
+		m.next = (m.next + 1) % m.max
+	}
+	m.values[v.Token] = append(m.values[v.Token], v)
+}
+
+func (m *AnonymizeMapping) remove(v AnonymizedValue) {
+	values := m.values[v.Token]
+	for i, recorded := range values {
+		if recorded == v {
+			values = append(values[:i], values[i+1:]...)
+			break
+		}
+	}
+	if len(values) == 0 {
+		delete(m.values, v.Token)
+		return
+	}
+	m.values[v.Token] = values
+}
+
+// Lookup returns the original values of token, anonymized with the key keyID in the label
+// label. An empty keyID or label matches any.
+func (m *AnonymizeMapping) Lookup(token, keyID, label string) []AnonymizedValue {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values := []AnonymizedValue{}
+	for _, v := range m.values[token] {
+		if (keyID == "" || v.KeyID == keyID) && (label == "" || v.Label == label) {
+			values = append(values, v)
+		}
+	}
+	sortAnonymizedValues(values)
+	return values
+}
+
+// Values returns the recorded values, sorted by token.
+func (m *AnonymizeMapping) Values() []AnonymizedValue {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values := make([]AnonymizedValue, 0, len(m.recorded))
+	for _, recorded := range m.values {
+		values = append(values, recorded...)
+	}
+	sortAnonymizedValues(values)
+	return values
+}
+
+func sortAnonymizedValues(values []AnonymizedValue) {
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		if a.KeyID != b.KeyID {
+			return a.KeyID < b.KeyID
+		}
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		return a.Value < b.Value
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+func podFamily(name, namespace, pod, node string) *clientmodel.MetricFamily {
+	return &clientmodel.MetricFamily{
+		Name: proto.String(name),
+		Metric: []*clientmodel.Metric{{
+			Label: []*clientmodel.LabelPair{
+				{Name: proto.String("namespace"), Value: proto.String(namespace)},
+				{Name: proto.String("node"), Value: proto.String(node)},
+				{Name: proto.String("pod"), Value: proto.String(pod)},
+			},
+		}},
+	}
+}
+
+func labelValue(f *clientmodel.MetricFamily, name string) string {
+	for _, l := range f.Metric[0].Label {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestKeyedAnonymizer(t *testing.T) {
+	mapping := NewAnonymizeMapping(10)
+	a, err := NewKeyedAnonymizer(KeyedAnonymizerConfig{
+		KeyID:     "k1",
+		Key:       []byte("secret"),
+		Labels:    []string{"node"},
+		Selectors: []AnonymizeSelector{{Match: `{__name__="kube_pod_info",namespace="payments"}`, Labels: []string{"pod"}}},
+		Mapping:   mapping,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	selected := podFamily("kube_pod_info", "payments", "api-1", "worker-1")
+	other := podFamily("kube_pod_info", "default", "api-1", "worker-1")
+	for _, f := range []*clientmodel.MetricFamily{selected, other} {
+		if _, err := a.Transform(f); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The node is anonymized everywhere, the pod only on the selected metrics.
+	node := labelValue(selected, "node")
+	if !strings.HasPrefix(node, "k1.") || node != labelValue(other, "node") {
+		t.Errorf("expected the node to be anonymized the same way with key k1, got %s and %s", node, labelValue(other, "node"))
+	}
+	if labelValue(selected, "pod") == "api-1" || labelValue(other, "pod") != "api-1" {
+		t.Errorf("expected only the selected pod to be anonymized, got %s and %s", labelValue(selected, "pod"), labelValue(other, "pod"))
+	}
+	if v := mapping.Lookup(node, "", "node"); len(v) != 1 || v[0].Value != "worker-1" || v[0].KeyID != "k1" {
+		t.Errorf("expected the mapping of %s to worker-1, got %+v", node, v)
+	}
+	if n := len(mapping.Values()); n != 2 {
+		t.Errorf("expected 2 mapped values, got %d", n)
+	}
+
+	// A rotated key anonymizes the values differently.
+	rotated, _ := NewKeyedAnonymizer(KeyedAnonymizerConfig{KeyID: "k2", Key: []byte("rotated"), Labels: []string{"node"}})
+	f := podFamily("kube_pod_info", "default", "api-1", "worker-1")
+	_, _ = rotated.Transform(f)
+	if v := labelValue(f, "node"); !strings.HasPrefix(v, "k2.") || strings.TrimPrefix(v, "k2.") == strings.TrimPrefix(node, "k1.") {
+		t.Errorf("expected a different value with the rotated key, got %s", v)
+	}
+}
+
+func TestKeyedAnonymizerFormatPreserving(t *testing.T) {
+	a, err := NewKeyedAnonymizer(KeyedAnonymizerConfig{KeyID: "k1", Key: []byte("secret"), Labels: []string{"node"}, FormatPreserving: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f := podFamily("kube_pod_info", "default", "api-1", "ip-10-0-1-23.ec2.Internal")
+	_, _ = a.Transform(f)
+
+	v := labelValue(f, "node")
+	if v == "ip-10-0-1-23.ec2.Internal" || !regexp.MustCompile(`^[a-z]{2}-[0-9]{2}-[0-9]-[0-9]-[0-9]{2}\.[a-z]{2}[0-9]\.[A-Z][a-z]{7}$`).MatchString(v) {
+		t.Errorf("expected the format of the node to be preserved, got %s", v)
+	}
+}
+
+func TestNewKeyedAnonymizerValidation(t *testing.T) {
+	for _, cfg := range []KeyedAnonymizerConfig{
+		{Key: []byte("secret")},
+		{KeyID: "k1"},
+		{KeyID: "k1", Key: []byte("secret"), Selectors: []AnonymizeSelector{{Match: "{", Labels: []string{"pod"}}}},
+		{KeyID: "k1", Key: []byte("secret"), Selectors: []AnonymizeSelector{{Match: "up"}}},
+	} {
+		if _, err := NewKeyedAnonymizer(cfg); err == nil {
+			t.Errorf("expected config %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestAnonymizeMappingSize(t *testing.T) {
+	m := NewAnonymizeMapping(2)
+	for _, token := range []string{"a", "b", "c"} {
+		m.record(AnonymizedValue{Token: token})
+	}
+	if v := m.Lookup("a", "", ""); len(v) != 0 {
+		t.Errorf("expected the oldest value to be evicted, got %v", v)
+	}
+	if len(m.Values()) != 2 {
+		t.Errorf("expected 2 values, got %v", m.Values())
+	}
+}
+
+func TestAnonymizeMappingCollisions(t *testing.T) {
+	m := NewAnonymizeMapping(10)
+	for _, v := range []AnonymizedValue{
+		{Token: "7", KeyID: "k1", Label: "shard", Value: "1"},
+		{Token: "7", KeyID: "k1", Label: "shard", Value: "4"},
+		{Token: "7", KeyID: "k2", Label: "shard", Value: "2"},
+		{Token: "7", KeyID: "k1", Label: "shard", Value: "1"},
+	} {
+		m.record(v)
+	}
+	// The values of a token are all kept, with the key that anonymized them.
+	if v := m.Lookup("7", "", ""); len(v) != 3 {
+		t.Errorf("expected the 3 values of the token, got %v", v)
+	}
+	if v := m.Lookup("7", "k1", "shard"); len(v) != 2 || v[0].Value != "1" || v[1].Value != "4" {
+		t.Errorf("expected the 2 values of the token with key k1, got %v", v)
+	}
+	if v := m.Lookup("7", "k2", "pod"); len(v) != 0 {
+		t.Errorf("expected no value of the token in another label, got %v", v)
+	}
+}
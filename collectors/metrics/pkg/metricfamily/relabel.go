@@ -0,0 +1,90 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"fmt"
+
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+type relabelTransformer struct {
+	configs []*relabel.Config
+}
+
+// ParseRelabelConfigs parses Prometheus relabel_configs, each given as a YAML or JSON object
+// such as {"source_labels": ["namespace"], "regex": "kube-.*", "action": "drop"}.
+// Unset fields take the Prometheus defaults.
+func ParseRelabelConfigs(rules []string) ([]*relabel.Config, error) {
+	var configs []*relabel.Config
+	for _, rule := range rules {
+		cfg := &relabel.Config{}
+		if err := yaml.UnmarshalStrict([]byte(rule), cfg); err != nil {
+			return nil, fmt.Errorf("invalid relabel config %s: %w", rule, err)
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// NewRelabel returns a Transformer that applies Prometheus relabel_configs to every metric,
+// with the metric name available as the __name__ label. Metrics dropped by a keep, drop or
+// hashmod action are nil'ed. A family is renamed when the relabeling changes __name__;
+// since a family has a single name, metrics relabeled to another name than the first
+// metric of the family are dropped.
+func NewRelabel(configs []*relabel.Config) Transformer {
+	return &relabelTransformer{configs: configs}
+}
+
+// Transform implements the Transformer interface.
+func (t *relabelTransformer) Transform(family *clientmodel.MetricFamily) (bool, error) {
+	if family == nil || len(t.configs) == 0 {
+		return true, nil
+	}
+
+	name := family.GetName()
+	newName := ""
+	ok := false
+	for i, m := range family.Metric {
+		if m == nil {
+			continue
+		}
+		ls := make(labels.Labels, 0, len(m.Label)+1)
+		ls = append(ls, labels.Label{Name: labels.MetricName, Value: name})
+		for _, l := range m.Label {
+			if l == nil {
+				continue
+			}
+			ls = append(ls, labels.Label{Name: l.GetName(), Value: l.GetValue()})
+		}
+
+		ls = relabel.Process(labels.New(ls...), t.configs...)
+		metricName := ls.Get(labels.MetricName)
+		if ls == nil || len(metricName) == 0 || (len(newName) > 0 && metricName != newName) {
+			family.Metric[i] = nil
+			continue
+		}
+		newName = metricName
+
+		pairs := make([]*clientmodel.LabelPair, 0, len(ls)-1)
+		for _, l := range ls {
+			if l.Name == labels.MetricName {
+				continue
+			}
+			lname, lvalue := l.Name, l.Value
+			pairs = append(pairs, &clientmodel.LabelPair{Name: &lname, Value: &lvalue})
+		}
+		m.Label = pairs
+		ok = true
+	}
+
+	if ok && newName != name {
+		family.Name = &newName
+	}
+	return ok, nil
+}
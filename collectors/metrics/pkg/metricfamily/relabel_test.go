@@ -0,0 +1,139 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricfamily
+
+import (
+	"reflect"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+func labelPairs(kv ...string) []*clientmodel.LabelPair {
+	var pairs []*clientmodel.LabelPair
+	for i := 0; i+1 < len(kv); i += 2 {
+		name, value := kv[i], kv[i+1]
+		pairs = append(pairs, &clientmodel.LabelPair{Name: &name, Value: &value})
+	}
+	return pairs
+}
+
+func labelsOf(m *clientmodel.Metric) map[string]string {
+	ls := map[string]string{}
+	for _, l := range m.Label {
+		ls[l.GetName()] = l.GetValue()
+	}
+	return ls
+}
+
+func TestRelabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		family   *clientmodel.MetricFamily
+		ok       bool
+		wantName string
+		want     []map[string]string
+	}{
+		{
+			name:     "replace",
+			rules:    []string{`{"source_labels": ["pod"], "regex": "(.*)-[a-z0-9]+", "target_label": "workload"}`},
+			family:   familyWithLabels("up", labelPairs("pod", "api-x7k2")),
+			ok:       true,
+			wantName: "up",
+			want:     []map[string]string{{"pod": "api-x7k2", "workload": "api"}},
+		},
+		{
+			name:  "keep",
+			rules: []string{`{"source_labels": ["namespace"], "regex": "openshift-.*", "action": "keep"}`},
+			family: familyWithLabels("up",
+				labelPairs("namespace", "openshift-monitoring"), labelPairs("namespace", "default")),
+			ok:       true,
+			wantName: "up",
+			want:     []map[string]string{{"namespace": "openshift-monitoring"}, nil},
+		},
+		{
+			name:     "drop whole family by name",
+			rules:    []string{"source_labels: [__name__]\nregex: up\naction: drop"},
+			family:   familyWithLabels("up", labelPairs("job", "a")),
+			ok:       false,
+			wantName: "up",
+			want:     []map[string]string{nil},
+		},
+		{
+			name:     "labelmap and labeldrop",
+			rules:    []string{`{"regex": "label_(.+)", "action": "labelmap"}`, `{"regex": "label_.+", "action": "labeldrop"}`},
+			family:   familyWithLabels("kube_pod_labels", labelPairs("label_app", "web", "pod", "a")),
+			ok:       true,
+			wantName: "kube_pod_labels",
+			want:     []map[string]string{{"app": "web", "pod": "a"}},
+		},
+		{
+			name: "hashmod",
+			rules: []string{
+				`{"source_labels": ["pod"], "modulus": 1, "target_label": "shard", "action": "hashmod"}`,
+			},
+			family:   familyWithLabels("up", labelPairs("pod", "a")),
+			ok:       true,
+			wantName: "up",
+			want:     []map[string]string{{"pod": "a", "shard": "0"}},
+		},
+		{
+			name:     "rename",
+			rules:    []string{`{"source_labels": ["__name__"], "regex": "up", "target_label": "__name__", "replacement": "spoke_up"}`},
+			family:   familyWithLabels("up", labelPairs("job", "a")),
+			ok:       true,
+			wantName: "spoke_up",
+			want:     []map[string]string{{"job": "a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := ParseRelabelConfigs(tt.rules)
+			if err != nil {
+				t.Fatalf("failed to parse relabel configs: %v", err)
+			}
+			ok, err := NewRelabel(configs).Transform(tt.family)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tt.ok {
+				t.Errorf("expected ok to be %t, got %t", tt.ok, ok)
+			}
+			if tt.family.GetName() != tt.wantName {
+				t.Errorf("expected family name %s, got %s", tt.wantName, tt.family.GetName())
+			}
+			for i, want := range tt.want {
+				m := tt.family.Metric[i]
+				if want == nil {
+					if m != nil {
+						t.Errorf("expected metric %d to be dropped, got %v", i, m)
+					}
+					continue
+				}
+				if m == nil {
+					t.Errorf("expected metric %d to be kept", i)
+					continue
+				}
+				if got := labelsOf(m); !reflect.DeepEqual(got, want) {
+					t.Errorf("expected labels %v, got %v", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRelabelConfigsInvalid(t *testing.T) {
+	for _, rule := range []string{
+		`{"action": "unknown"}`,
+		`{"regex": "(", "action": "drop", "source_labels": ["a"]}`,
+		`{"unknown_field": "a"}`,
+	} {
+		if _, err := ParseRelabelConfigs([]string{rule}); err == nil {
+			t.Errorf("expected %s to be rejected", rule)
+		}
+	}
+}
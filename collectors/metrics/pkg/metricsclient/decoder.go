@@ -0,0 +1,136 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// newDecoder returns a decoder reading one metric family at a time from r. The protobuf
+// delimited format is streamed by expfmt already, but its text decoder parses the whole
+// input before returning the first family, so the text format is split into families
+// before being parsed.
+func newDecoder(r io.Reader, format expfmt.Format) expfmt.Decoder {
+	if format == expfmt.FmtProtoDelim {
+		return expfmt.NewDecoder(r, format)
+	}
+	return &textDecoder{r: bufio.NewReader(r)}
+}
+
+// textDecoder decodes the text exposition format. Only the lines of the family being
+// decoded are held in memory.
+type textDecoder struct {
+	r *bufio.Reader
+	// next is the first line of the next family, read while looking for the end of the
+	// previous one.
+	next []byte
+	err  error
+}
+
+// Decode implements the expfmt.Decoder interface.
+func (d *textDecoder) Decode(v *clientmodel.MetricFamily) error {
+	for {
+		chunk, err := d.readFamily()
+		if len(chunk) == 0 {
+			return err
+		}
+		var parser expfmt.TextParser
+		families, perr := parser.TextToMetricFamilies(bytes.NewReader(chunk))
+		if perr != nil {
+			return perr
+		}
+		// A chunk holds a single family, or none when it only has comments.
+		for _, family := range families {
+			v.Name, v.Help, v.Type, v.Metric = family.Name, family.Help, family.Type, family.Metric
+			return nil
+		}
+	}
+}
+
+// readFamily returns the lines of the next family. It returns io.EOF, or the read
+// error, once the input is consumed.
+func (d *textDecoder) readFamily() ([]byte, error) {
+	var chunk []byte
+	name, typ := "", ""
+	for {
+		line := d.next
+		d.next = nil
+		if line == nil {
+			if d.err != nil {
+				return chunk, d.err
+			}
+			line, d.err = d.r.ReadBytes('\n')
+			if len(line) == 0 {
+				continue
+			}
+		}
+
+		lineName, lineType, isDescriptor := parseTextLine(line)
+		if len(lineName) > 0 {
+			if len(name) > 0 && !belongsToFamily(name, typ, lineName, isDescriptor) {
+				d.next = line
+				return chunk, nil
+			}
+			if len(name) == 0 {
+				name = lineName
+			}
+			if len(lineType) > 0 {
+				typ = lineType
+			}
+		}
+		chunk = append(chunk, line...)
+		if len(line) > 0 && line[len(line)-1] != '\n' {
+			chunk = append(chunk, '\n')
+		}
+	}
+}
+
+// parseTextLine returns the metric name of a sample, HELP or TYPE line, and the type
+// declared by a TYPE line. Blank lines and other comments have no name.
+func parseTextLine(line []byte) (name, typ string, isDescriptor bool) {
+	s := strings.TrimSpace(string(line))
+	if len(s) == 0 {
+		return "", "", false
+	}
+	if s[0] == '#' {
+		fields := strings.Fields(s[1:])
+		if len(fields) < 2 || (fields[0] != "HELP" && fields[0] != "TYPE") {
+			return "", "", false
+		}
+		if fields[0] == "TYPE" && len(fields) > 2 {
+			typ = strings.ToLower(fields[2])
+		}
+		return fields[1], typ, true
+	}
+	if i := strings.IndexAny(s, "{ \t"); i >= 0 {
+		s = s[:i]
+	}
+	return s, "", false
+}
+
+// belongsToFamily reports whether a line named lineName is part of the family name,
+// following the rules of the text format: the samples of histograms and summaries
+// carry a suffix, while HELP and TYPE lines always name the family itself.
+func belongsToFamily(name, typ, lineName string, isDescriptor bool) bool {
+	if lineName == name {
+		return true
+	}
+	if isDescriptor {
+		return false
+	}
+	switch typ {
+	case "histogram", "gaugehistogram":
+		return lineName == name+"_bucket" || lineName == name+"_sum" || lineName == name+"_count"
+	case "summary":
+		return lineName == name+"_sum" || lineName == name+"_count"
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const testExposition = `# TYPE up untyped
+up{job="a"} 1 1700000000000
+up{job="b"} 0 1700000000000
+# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.5"} 3
+http_request_duration_seconds_bucket{le="+Inf"} 4
+http_request_duration_seconds_sum 1.5
+http_request_duration_seconds_count 4
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.2
+rpc_duration_seconds_sum 10
+rpc_duration_seconds_count 40
+node_load1 0.5
+`
+
+func TestTextDecoder(t *testing.T) {
+	var parser expfmt.TextParser
+	want, err := parser.TextToMetricFamilies(strings.NewReader(testExposition))
+	if err != nil {
+		t.Fatalf("failed to parse the test exposition: %v", err)
+	}
+
+	decoder := newDecoder(strings.NewReader(testExposition), expfmt.FmtText)
+	var names []string
+	for {
+		family := &clientmodel.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			if err != io.EOF {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			break
+		}
+		names = append(names, family.GetName())
+		w, ok := want[family.GetName()]
+		if !ok {
+			t.Errorf("unexpected family %s", family.GetName())
+			continue
+		}
+		if family.GetType() != w.GetType() || len(family.Metric) != len(w.Metric) {
+			t.Errorf("expected family %s to be %v, got %v", family.GetName(), w, family)
+		}
+	}
+
+	wantNames := []string{"up", "http_request_duration_seconds", "rpc_duration_seconds", "node_load1"}
+	if strings.Join(names, ",") != strings.Join(wantNames, ",") {
+		t.Errorf("expected families %v in order, got %v", wantNames, names)
+	}
+}
+
+func TestRetrieveFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", string(expfmt.FmtText))
+		_, _ = w.Write([]byte(testExposition))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	metrics := &ClientMetrics{
+		FederateRequests: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "federate_requests_total"},
+			[]string{"type", "status_code"}),
+	}
+	c := New(log.NewNopLogger(), metrics, http.DefaultClient, 0, time.Minute, "federate_from")
+
+	var names []string
+	err := c.RetrieveFunc(context.Background(), &http.Request{Method: "GET", URL: u},
+		func(family *clientmodel.MetricFamily) error {
+			names = append(names, family.GetName())
+			if len(names) == 2 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected the callback error to stop the retrieval, got %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("expected two families before stopping, got %v", names)
+	}
+
+	// A truncated response is an error, the families decoded before it were passed on.
+	names = nil
+	c = New(log.NewNopLogger(), metrics, http.DefaultClient, int64(strings.Index(testExposition, "# HELP")+10),
+		time.Minute, "federate_from")
+	err = c.RetrieveFunc(context.Background(), &http.Request{Method: "GET", URL: u},
+		func(family *clientmodel.MetricFamily) error {
+			names = append(names, family.GetName())
+			return nil
+		})
+	if !errors.Is(err, ErrPartialResponse) {
+		t.Errorf("expected a truncated response to be an error, got %v", err)
+	}
+	if strings.Join(names, ",") != "up" {
+		t.Errorf("expected the families before the truncation, got %v", names)
+	}
+}
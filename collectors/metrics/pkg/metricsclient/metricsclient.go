@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"os"
@@ -21,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -30,6 +32,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	clientmodel "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/promql"
@@ -50,12 +53,39 @@ type Client struct {
 	metricsName string
 	logger      log.Logger
 
+	nativeHistograms bool
+	requestTimeout   time.Duration
+	staleSeries      *StaleSeries
+
+	// encodingLock guards the protocol and compression, which are negotiated with the endpoint.
+	encodingLock sync.Mutex
+	encoding     encoding
+
+	// metadataLock guards the metadata of the last written families, by series name.
+	metadataLock sync.RWMutex
+	metadata     map[string]prompb.MetricMetadata
+
+	// shardLock guards the number of shards, which is adapted after every write.
+	shardLock sync.Mutex
+	shards    int
+	minShards int
+	maxShards int
+
 	metrics *ClientMetrics
 }
 
 type ClientMetrics struct {
 	FederateRequests           *prometheus.CounterVec
 	ForwardRemoteWriteRequests *prometheus.CounterVec
+
+	Shards               prometheus.Gauge
+	ShardSentSeries      *prometheus.CounterVec
+	ShardFailedSeries    *prometheus.CounterVec
+	ShardRequestDuration prometheus.ObserverVec
+	// DroppedSeries counts the series the endpoint rejected for good, by reason.
+	DroppedSeries *prometheus.CounterVec
+	// StaleMarkers counts the staleness markers written for series that disappeared.
+	StaleMarkers prometheus.Counter
 }
 
 type PartitionedMetrics struct {
@@ -70,9 +100,23 @@ func New(logger log.Logger, metrics *ClientMetrics, client *http.Client, maxByte
 		metricsName: metricsName,
 		logger:      log.With(logger, "component", "metricsclient"),
 		metrics:     metrics,
+		shards:      1,
+		minShards:   1,
+		maxShards:   1,
+
+		encoding:       encoding{protocol: ProtocolV1, compression: CompressionSnappy},
+		requestTimeout: defaultRequestTimeout,
 	}
 }
 
+// WithNativeHistograms makes RemoteWrite send histograms that carry native buckets
+// as Prometheus native histograms instead of expanding them into classic series.
+// The receiving endpoint must have native histogram ingestion enabled.
+func (c *Client) WithNativeHistograms(enabled bool) *Client {
+	c.nativeHistograms = enabled
+	return c
+}
+
 type MetricsJson struct {
 	Status string      `json:"status"`
 	Data   MetricsData `json:"data"`
@@ -178,7 +222,54 @@ func (c *Client) RetrievRecordingMetrics(
 	return families, nil
 }
 
+// Retrieve federates the metrics of req and returns the decoded families.
 func (c *Client) Retrieve(ctx context.Context, req *http.Request) ([]*clientmodel.MetricFamily, error) {
+	families := make([]*clientmodel.MetricFamily, 0, 100)
+	err := c.RetrieveFunc(ctx, req, func(family *clientmodel.MetricFamily) error {
+		families = append(families, family)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return families, nil
+}
+
+// ErrPartialResponse is returned by RetrieveFunc when the response cannot be decoded to its end.
+var ErrPartialResponse = errors.New("the response is truncated or invalid")
+
+// Response is a federate response as it was received, so that its families can be decoded
+// again without federating them.
+type Response struct {
+	format expfmt.Format
+	body   []byte
+}
+
+// Decode calls fn with every family of the response. An error returned by fn stops the
+// decoding and is returned.
+func (r *Response) Decode(fn func(*clientmodel.MetricFamily) error) error {
+	return decodeFamilies(bytes.NewReader(r.body), r.format, fn)
+}
+
+// RetrieveFunc federates the metrics of req and calls fn with every family as soon as it
+// is decoded, so that a caller filtering the families only holds the ones it keeps.
+// The response is limited to maxBytes, unless maxBytes is zero. An error returned by fn
+// stops the retrieval and is returned. ErrPartialResponse is returned when the response is
+// truncated or invalid, fn was then called with the families decoded before.
+func (c *Client) RetrieveFunc(ctx context.Context, req *http.Request, fn func(*clientmodel.MetricFamily) error) error {
+	_, err := c.retrieve(ctx, req, fn, false)
+	return err
+}
+
+// RetrieveResponseFunc is RetrieveFunc, and also returns the response as it was received,
+// up to the error if any. It holds the whole response in memory.
+func (c *Client) RetrieveResponseFunc(ctx context.Context, req *http.Request,
+	fn func(*clientmodel.MetricFamily) error) (*Response, error) {
+	return c.retrieve(ctx, req, fn, true)
+}
+
+func (c *Client) retrieve(ctx context.Context, req *http.Request, fn func(*clientmodel.MetricFamily) error,
+	keep bool) (*Response, error) {
 	if req.Header == nil {
 		req.Header = make(http.Header)
 	}
@@ -188,7 +279,7 @@ func (c *Client) Retrieve(ctx context.Context, req *http.Request) ([]*clientmode
 	req = req.WithContext(ctx)
 	defer cancel()
 
-	families := make([]*clientmodel.MetricFamily, 0, 100)
+	var response *Response
 	err := withCancel(ctx, c.client, req, func(resp *http.Response) error {
 		switch resp.StatusCode {
 		case http.StatusOK:
@@ -207,28 +298,42 @@ func (c *Client) Retrieve(ctx context.Context, req *http.Request) ([]*clientmode
 			return fmt.Errorf("prometheus server reported unexpected error code: %d", resp.StatusCode)
 		}
 
-		// read the response into memory
+		var r io.Reader = resp.Body
+		if c.maxBytes > 0 {
+			r = &reader.LimitedReader{R: resp.Body, N: c.maxBytes}
+		}
 		format := expfmt.ResponseFormat(resp.Header)
-		r := &reader.LimitedReader{R: resp.Body, N: c.maxBytes}
-		decoder := expfmt.NewDecoder(r, format)
-		for {
-			family := &clientmodel.MetricFamily{}
-			families = append(families, family)
-			if err := decoder.Decode(family); err != nil {
-				if err != io.EOF {
-					logger.Log(c.logger, logger.Error, "msg", "error reading body", "err", err)
-				}
-				break
-			}
+		var body bytes.Buffer
+		if keep {
+			r = io.TeeReader(r, &body)
+			defer func() {
+				response = &Response{format: format, body: body.Bytes()}
+			}()
 		}
-
-		return nil
+		err := decodeFamilies(r, format, fn)
+		if errors.Is(err, ErrPartialResponse) {
+			logger.Log(c.logger, logger.Error, "msg", "error reading body", "err", err)
+		}
+		return err
 	})
-	if err != nil {
-		return nil, err
-	}
+	return response, err
+}
 
-	return families, nil
+// decodeFamilies decodes the response r one family at a time, and calls fn with every family.
+func decodeFamilies(r io.Reader, format expfmt.Format, fn func(*clientmodel.MetricFamily) error) error {
+	decoder := newDecoder(r, format)
+	for {
+		family := &clientmodel.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			if err != io.EOF {
+				return fmt.Errorf("%w: %v", ErrPartialResponse, err)
+			}
+			return nil
+		}
+		if err := fn(family); err != nil {
+			return err
+		}
+	}
 }
 
 // // TODO(saswatamcode): This is no longer used, remove it in the future.
@@ -377,65 +482,197 @@ func DefaultTransport(logger log.Logger, isTLS bool) *http.Transport {
 	}
 }
 
-func convertToTimeseries(p *PartitionedMetrics, now time.Time) ([]prompb.TimeSeries, error) {
+func convertToTimeseries(p *PartitionedMetrics, now time.Time, nativeHistograms bool) ([]prompb.TimeSeries, error) {
 	var timeseries []prompb.TimeSeries
 
 	timestamp := now.UnixNano() / int64(time.Millisecond)
 	for _, f := range p.Families {
 		for _, m := range f.Metric {
-			var ts prompb.TimeSeries
-
-			labelpairs := []prompb.Label{{
-				Name:  nameLabelName,
-				Value: *f.Name,
-			}}
-
-			dedup := make(map[string]struct{})
-			for _, l := range m.Label {
-				// Skip empty labels.
-				if *l.Name == "" || *l.Value == "" {
-					continue
-				}
-				// Check for duplicates
-				if _, ok := dedup[*l.Name]; ok {
-					continue
-				}
-				labelpairs = append(labelpairs, prompb.Label{
-					Name:  *l.Name,
-					Value: *l.Value,
-				})
-				dedup[*l.Name] = struct{}{}
+			if m == nil {
+				continue
 			}
 
-			s := prompb.Sample{
-				Timestamp: *m.TimestampMs,
-			}
+			t := *m.TimestampMs
 			// If the sample is in the future, overwrite it.
-			if *m.TimestampMs > timestamp {
-				s.Timestamp = timestamp
+			if t > timestamp {
+				t = timestamp
 			}
 
 			switch *f.Type {
 			case clientmodel.MetricType_COUNTER:
-				s.Value = *m.Counter.Value
+				timeseries = append(timeseries, sampleSeries(f.GetName(), m, t, m.GetCounter().GetValue()))
 			case clientmodel.MetricType_GAUGE:
-				s.Value = *m.Gauge.Value
+				timeseries = append(timeseries, sampleSeries(f.GetName(), m, t, m.GetGauge().GetValue()))
 			case clientmodel.MetricType_UNTYPED:
-				s.Value = *m.Untyped.Value
+				timeseries = append(timeseries, sampleSeries(f.GetName(), m, t, m.GetUntyped().GetValue()))
+			case clientmodel.MetricType_SUMMARY:
+				timeseries = append(timeseries, summarySeries(f.GetName(), m, t)...)
+			case clientmodel.MetricType_HISTOGRAM, clientmodel.MetricType_GAUGE_HISTOGRAM:
+				timeseries = append(timeseries, histogramSeries(f.GetName(), m, t, nativeHistograms)...)
 			default:
 				return nil, fmt.Errorf("metric type %s not supported", f.Type.String())
 			}
+		}
+	}
+
+	return timeseries, nil
+}
+
+// metricLabels returns the sorted remote write labels of a metric named name.
+// The extra labels, such as le or quantile, take precedence over the metric labels.
+func metricLabels(name string, m *clientmodel.Metric, extra ...prompb.Label) []prompb.Label {
+	labelpairs := []prompb.Label{{
+		Name:  nameLabelName,
+		Value: name,
+	}}
+
+	dedup := map[string]struct{}{nameLabelName: {}}
+	for _, l := range extra {
+		labelpairs = append(labelpairs, l)
+		dedup[l.Name] = struct{}{}
+	}
+	for _, l := range m.Label {
+		// Skip empty labels.
+		if *l.Name == "" || *l.Value == "" {
+			continue
+		}
+		// Check for duplicates
+		if _, ok := dedup[*l.Name]; ok {
+			continue
+		}
+		labelpairs = append(labelpairs, prompb.Label{
+			Name:  *l.Name,
+			Value: *l.Value,
+		})
+		dedup[*l.Name] = struct{}{}
+	}
+
+	sortLabels(labelpairs)
+	return labelpairs
+}
+
+func sampleSeries(name string, m *clientmodel.Metric, timestamp int64, value float64, extra ...prompb.Label) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  metricLabels(name, m, extra...),
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
+
+// summarySeries expands a summary into its quantile, _sum and _count series,
+// the same way Prometheus stores a scraped summary.
+func summarySeries(name string, m *clientmodel.Metric, timestamp int64) []prompb.TimeSeries {
+	s := m.GetSummary()
+	series := make([]prompb.TimeSeries, 0, len(s.GetQuantile())+2)
+	for _, q := range s.GetQuantile() {
+		series = append(series, sampleSeries(name, m, timestamp, q.GetValue(), prompb.Label{
+			Name:  model.QuantileLabel,
+			Value: formatFloat(q.GetQuantile()),
+		}))
+	}
+	series = append(series,
+		sampleSeries(name+"_sum", m, timestamp, s.GetSampleSum()),
+		sampleSeries(name+"_count", m, timestamp, float64(s.GetSampleCount())),
+	)
+	return series
+}
 
-			ts.Labels = append(ts.Labels, labelpairs...)
-			sortLabels(ts.Labels)
+// histogramSeries expands a classic histogram into its _bucket, _sum and _count series.
+// If nativeHistograms is set and the histogram has native buckets, it is sent as a
+// single native histogram series instead.
+func histogramSeries(name string, m *clientmodel.Metric, timestamp int64, nativeHistograms bool) []prompb.TimeSeries {
+	h := m.GetHistogram()
+	if nativeHistograms && isNativeHistogram(h) {
+		return []prompb.TimeSeries{{
+			Labels:     metricLabels(name, m),
+			Histograms: []prompb.Histogram{toPromHistogram(h, timestamp)},
+		}}
+	}
 
-			ts.Samples = append(ts.Samples, s)
+	count := float64(h.GetSampleCount())
+	if h.GetSampleCountFloat() > 0 {
+		count = h.GetSampleCountFloat()
+	}
 
-			timeseries = append(timeseries, ts)
+	series := make([]prompb.TimeSeries, 0, len(h.GetBucket())+3)
+	infSeen := false
+	for _, b := range h.GetBucket() {
+		bucketCount := float64(b.GetCumulativeCount())
+		if b.GetCumulativeCountFloat() > 0 {
+			bucketCount = b.GetCumulativeCountFloat()
+		}
+		if math.IsInf(b.GetUpperBound(), +1) {
+			infSeen = true
 		}
+		series = append(series, sampleSeries(name+"_bucket", m, timestamp, bucketCount, prompb.Label{
+			Name:  model.BucketLabel,
+			Value: formatFloat(b.GetUpperBound()),
+		}))
 	}
+	// The +Inf bucket is implicit in the exposition, but required by histogram_quantile.
+	if len(h.GetBucket()) > 0 && !infSeen {
+		series = append(series, sampleSeries(name+"_bucket", m, timestamp, count, prompb.Label{
+			Name:  model.BucketLabel,
+			Value: formatFloat(math.Inf(+1)),
+		}))
+	}
+	series = append(series,
+		sampleSeries(name+"_sum", m, timestamp, h.GetSampleSum()),
+		sampleSeries(name+"_count", m, timestamp, count),
+	)
+	return series
+}
 
-	return timeseries, nil
+// isNativeHistogram reports whether h carries native (sparse) buckets.
+// Based on https://github.com/prometheus/prometheus/blob/main/model/textparse/protobufparse.go
+func isNativeHistogram(h *clientmodel.Histogram) bool {
+	return h.GetZeroThreshold() > 0 ||
+		h.GetZeroCount() > 0 ||
+		len(h.GetNegativeDelta()) > 0 ||
+		len(h.GetPositiveDelta()) > 0 ||
+		len(h.GetNegativeCount()) > 0 ||
+		len(h.GetPositiveCount()) > 0
+}
+
+func toPromHistogram(h *clientmodel.Histogram, timestamp int64) prompb.Histogram {
+	ph := prompb.Histogram{
+		Sum:           h.GetSampleSum(),
+		Schema:        h.GetSchema(),
+		ZeroThreshold: h.GetZeroThreshold(),
+		NegativeSpans: toPromBucketSpans(h.GetNegativeSpan()),
+		PositiveSpans: toPromBucketSpans(h.GetPositiveSpan()),
+		Timestamp:     timestamp,
+	}
+	if h.GetSampleCountFloat() > 0 || h.GetZeroCountFloat() > 0 {
+		ph.Count = &prompb.Histogram_CountFloat{CountFloat: h.GetSampleCountFloat()}
+		ph.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: h.GetZeroCountFloat()}
+		ph.NegativeCounts = h.GetNegativeCount()
+		ph.PositiveCounts = h.GetPositiveCount()
+	} else {
+		ph.Count = &prompb.Histogram_CountInt{CountInt: h.GetSampleCount()}
+		ph.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: h.GetZeroCount()}
+		ph.NegativeDeltas = h.GetNegativeDelta()
+		ph.PositiveDeltas = h.GetPositiveDelta()
+	}
+	return ph
+}
+
+func toPromBucketSpans(spans []*clientmodel.BucketSpan) []prompb.BucketSpan {
+	if len(spans) == 0 {
+		return nil
+	}
+	out := make([]prompb.BucketSpan, 0, len(spans))
+	for _, s := range spans {
+		out = append(out, prompb.BucketSpan{
+			Offset: s.GetOffset(),
+			Length: s.GetLength(),
+		})
+	}
+	return out
+}
+
+// formatFloat formats le and quantile label values like the Prometheus text exposition.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
 }
 
 func sortLabels(labels []prompb.Label) {
@@ -451,22 +688,29 @@ func (sl *sortableLabels) Len() int           { return len(*sl) }
 func (sl *sortableLabels) Swap(i, j int)      { (*sl)[i], (*sl)[j] = (*sl)[j], (*sl)[i] }
 func (sl *sortableLabels) Less(i, j int) bool { return (*sl)[i].Name < (*sl)[j].Name }
 
+// ConvertToTimeseries converts families to the remote write time series this client would send.
+func (c *Client) ConvertToTimeseries(families []*clientmodel.MetricFamily) ([]prompb.TimeSeries, error) {
+	return convertToTimeseries(&PartitionedMetrics{Families: families}, time.Now(), c.nativeHistograms)
+}
+
 // RemoteWrite is used to push the metrics to remote thanos endpoint.
 func (c *Client) RemoteWrite(ctx context.Context, req *http.Request,
 	families []*clientmodel.MetricFamily, interval time.Duration) error {
 
-	timeseries, err := convertToTimeseries(&PartitionedMetrics{Families: families}, time.Now())
+	timeseries, err := c.ConvertToTimeseries(families)
 	if err != nil {
 		msg := "failed to convert timeseries"
 		logger.Log(c.logger, logger.Warn, "msg", msg, "err", err)
 		return errors.New(msg)
 	}
+	timeseries = c.MarkStale(timeseries)
 
 	if len(timeseries) == 0 {
 		logger.Log(c.logger, logger.Info, "msg", "no time series to forward to receive endpoint")
 		return nil
 	}
 	logger.Log(c.logger, logger.Debug, "timeseries number", len(timeseries))
+	c.recordMetadata(families)
 
 	//uncomment here to generate timeseries
 	/*
@@ -481,47 +725,175 @@ func (c *Client) RemoteWrite(ctx context.Context, req *http.Request,
 		}
 	*/
 
+	return c.WriteTimeseries(ctx, req, timeseries, interval)
+}
+
+// WriteError is returned when some of the time series could not be delivered to the
+// remote endpoint. Unsent holds the series that were not accepted, so that callers can
+// retry them later.
+type WriteError struct {
+	Err    error
+	Unsent []prompb.TimeSeries
+}
+
+func (e *WriteError) Error() string { return e.Err.Error() }
+
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// WriteTimeseries pushes already converted time series to the remote endpoint.
+// The series are spread over the client's shards by their labels, and every shard sends its
+// series concurrently in chunks of at most maxSeriesLength series, each with its own retries.
+// If a chunk cannot be delivered, a *WriteError holding the series of that chunk and of all
+// following chunks of the same shard is returned.
+func (c *Client) WriteTimeseries(ctx context.Context, req *http.Request,
+	timeseries []prompb.TimeSeries, interval time.Duration) error {
+
+	start := time.Now()
+	shards := shardTimeseries(timeseries, c.currentShards())
+
+	errs := make([]error, len(shards))
+	unsent := make([][]prompb.TimeSeries, len(shards))
+	var wg sync.WaitGroup
+	for i := range shards {
+		if len(shards[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			unsent[i], errs[i] = c.writeShard(strconv.Itoa(i), req.URL.String(), shards[i], interval)
+		}(i)
+	}
+	wg.Wait()
+
+	var writeErr *WriteError
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if writeErr == nil {
+			writeErr = &WriteError{Err: err}
+		}
+		writeErr.Unsent = append(writeErr.Unsent, unsent[i]...)
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	c.reshard(len(timeseries), len(shards), time.Since(start), interval)
+	logger.Log(c.logger, logger.Info, "msg", "metrics pushed successfully")
+	return nil
+}
+
+// writeShard sends the series of a single shard sequentially. It returns the series
+// that could not be delivered along with the error.
+func (c *Client) writeShard(shard string, serverURL string,
+	timeseries []prompb.TimeSeries, interval time.Duration) ([]prompb.TimeSeries, error) {
+
+	// Do not set max elapsed time more than half the scrape interval
+	halfInterval := len(timeseries) * 2 / maxSeriesLength
+	if halfInterval < 2 {
+		halfInterval = 2
+	}
+	maxElapsedTime := interval / time.Duration(halfInterval)
+
 	for i := 0; i < len(timeseries); i += maxSeriesLength {
 		length := len(timeseries)
 		if i+maxSeriesLength < length {
 			length = i + maxSeriesLength
 		}
-		subTimeseries := timeseries[i:length]
-
-		wreq := &prompb.WriteRequest{Timeseries: subTimeseries}
-		data, err := proto.Marshal(wreq)
+		unsent, err := c.writeChunk(shard, serverURL, timeseries[i:length], maxElapsedTime)
 		if err != nil {
-			msg := "failed to marshal proto"
-			logger.Log(c.logger, logger.Warn, "msg", msg, "err", err)
-			return errors.New(msg)
+			unsent = append(unsent, timeseries[length:]...)
+			if c.metrics.ShardFailedSeries != nil {
+				c.metrics.ShardFailedSeries.WithLabelValues(shard).Add(float64(len(unsent)))
+			}
+			return unsent, err
 		}
-		compressed := snappy.Encode(nil, data)
+	}
+	return nil, nil
+}
 
-		// retry RemoteWrite with exponential back-off
-		b := backoff.NewExponentialBackOff()
-		// Do not set max elapsed time more than half the scrape interval
-		halfInterval := len(timeseries) * 2 / maxSeriesLength
-		if halfInterval < 2 {
-			halfInterval = 2
+// writeChunk sends the series in a single request, retried with exponential back-off as
+// long as the endpoint answers with a retryable status. When the endpoint rejects the
+// request as too large, it is split in two. Series the endpoint rejects for good are
+// dropped and counted instead of failing the write. It returns the series that could
+// not be delivered along with the error.
+func (c *Client) writeChunk(shard string, serverURL string,
+	timeseries []prompb.TimeSeries, maxElapsedTime time.Duration) ([]prompb.TimeSeries, error) {
+
+	enc := c.currentEncoding()
+	body, err := c.encodeRequest(enc, timeseries)
+	if err != nil {
+		msg := "failed to marshal proto"
+		logger.Log(c.logger, logger.Warn, "msg", msg, "err", err, "shard", shard)
+		return timeseries, errors.New(msg)
+	}
+
+	// retry RemoteWrite with exponential back-off
+	b := &retryAfterBackOff{ExponentialBackOff: backoff.NewExponentialBackOff()}
+	b.MaxElapsedTime = maxElapsedTime
+	retryable := func() error {
+		start := time.Now()
+		defer func() {
+			if c.metrics.ShardRequestDuration != nil {
+				c.metrics.ShardRequestDuration.WithLabelValues(shard).Observe(time.Since(start).Seconds())
+			}
+		}()
+		err := c.sendRequest(serverURL, enc, body)
+		var reqErr *requestError
+		if !errors.As(err, &reqErr) {
+			return err
 		}
-		b.MaxElapsedTime = interval / time.Duration(halfInterval)
-		retryable := func() error {
-			return c.sendRequest(req.URL.String(), compressed)
+		if reqErr.statusCode == http.StatusUnsupportedMediaType && c.downgrade(enc) {
+			enc = c.currentEncoding()
+			var encodeErr error
+			if body, encodeErr = c.encodeRequest(enc, timeseries); encodeErr != nil {
+				return backoff.Permanent(encodeErr)
+			}
+			return err
+		}
+		if !reqErr.retryable() {
+			return backoff.Permanent(err)
 		}
-		notify := func(err error, t time.Duration) {
-			msg := fmt.Sprintf("error: %v happened at time: %v", err, t)
-			logger.Log(c.logger, logger.Warn, "msg", msg)
+		b.retryAfter = reqErr.retryAfter
+		return err
+	}
+	notify := func(err error, t time.Duration) {
+		msg := fmt.Sprintf("error: %v happened at time: %v", err, t)
+		logger.Log(c.logger, logger.Warn, "msg", msg, "shard", shard)
+	}
+	err = backoff.RetryNotify(retryable, b, notify)
+
+	var reqErr *requestError
+	switch {
+	case err == nil:
+		if c.metrics.ShardSentSeries != nil {
+			c.metrics.ShardSentSeries.WithLabelValues(shard).Add(float64(len(timeseries)))
 		}
-		err = backoff.RetryNotify(retryable, b, notify)
+		return nil, nil
+	case !errors.As(err, &reqErr):
+		return timeseries, err
+	case reqErr.statusCode == http.StatusRequestEntityTooLarge && len(timeseries) > 1:
+		logger.Log(c.logger, logger.Info, "msg", "request too large, splitting it", "series", len(timeseries), "shard", shard)
+		half := len(timeseries) / 2
+		unsent, err := c.writeChunk(shard, serverURL, timeseries[:half], maxElapsedTime)
 		if err != nil {
-			return err
+			return append(unsent, timeseries[half:]...), err
 		}
+		return c.writeChunk(shard, serverURL, timeseries[half:], maxElapsedTime)
+	case reqErr.droppable():
+		logger.Log(c.logger, logger.Warn, "msg", "dropping series rejected by the endpoint",
+			"series", len(timeseries), "shard", shard, "err", err)
+		if c.metrics.DroppedSeries != nil {
+			c.metrics.DroppedSeries.WithLabelValues(reqErr.reason()).Add(float64(len(timeseries)))
+		}
+		return nil, nil
 	}
-	logger.Log(c.logger, logger.Info, "msg", "metrics pushed successfully")
-	return nil
+	return timeseries, err
 }
 
-func (c *Client) sendRequest(serverURL string, body []byte) error {
+func (c *Client) sendRequest(serverURL string, enc encoding, body []byte) error {
 	req1, err := http.NewRequest(http.MethodPost, serverURL, bytes.NewBuffer(body))
 	if err != nil {
 		msg := "failed to create forwarding request"
@@ -531,8 +903,11 @@ func (c *Client) sendRequest(serverURL string, body []byte) error {
 	}
 
 	//req.Header.Add("THANOS-TENANT", tenantID)
+	req1.Header.Set("Content-Encoding", enc.compression)
+	req1.Header.Set("Content-Type", enc.contentType())
+	req1.Header.Set(versionHeader, enc.version())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
 	defer cancel()
 
 	req1 = req1.WithContext(ctx)
@@ -544,6 +919,7 @@ func (c *Client) sendRequest(serverURL string, body []byte) error {
 		c.metrics.ForwardRemoteWriteRequests.WithLabelValues("0").Inc()
 		return errors.New(msg)
 	}
+	defer resp.Body.Close()
 
 	c.metrics.ForwardRemoteWriteRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
 
@@ -556,8 +932,11 @@ func (c *Client) sendRequest(serverURL string, body []byte) error {
 		bodyString := string(bodyBytes)
 		msg := fmt.Sprintf("response status code is %s, response body is %s", resp.Status, bodyString)
 		logger.Log(c.logger, logger.Warn, msg)
-		return errors.New(msg)
-
+		return &requestError{
+			statusCode: resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			msg:        msg,
+		}
 	}
 	return nil
 }
@@ -33,6 +33,8 @@ func Test_convertToTimeseries(t *testing.T) {
 	counter := clientmodel.MetricType_COUNTER
 	untyped := clientmodel.MetricType_UNTYPED
 	gauge := clientmodel.MetricType_GAUGE
+	summary := clientmodel.MetricType_SUMMARY
+	histogram := clientmodel.MetricType_HISTOGRAM
 
 	fooMetricName := "foo_metric"
 	fooHelp := "foo help text"
@@ -49,6 +51,11 @@ func Test_convertToTimeseries(t *testing.T) {
 
 	value42 := 42.0
 	value50 := 50.0
+	count7 := uint64(7)
+	count3 := uint64(3)
+	quantile50 := 0.5
+	quantile99 := 0.99
+	bound1 := 1.0
 	timestamp := int64(15615582020000)
 	now := time.Now()
 	nowTimestamp := now.UnixNano() / int64(time.Millisecond)
@@ -230,10 +237,77 @@ func Test_convertToTimeseries(t *testing.T) {
 			Labels:  []prompb.Label{{Name: nameLabelName, Value: barMetricName}, {Name: barLabelName, Value: barLabelValue1}, {Name: fooLabelName, Value: fooLabelValue2}},
 			Samples: []prompb.Sample{{Value: value50, Timestamp: nowTimestamp}},
 		}},
+	}, {
+		name: "summary",
+		in: &PartitionedMetrics{
+			Families: []*clientmodel.MetricFamily{{
+				Name: &fooMetricName,
+				Help: &fooHelp,
+				Type: &summary,
+				Metric: []*clientmodel.Metric{{
+					Label: []*clientmodel.LabelPair{{Name: &fooLabelName, Value: &fooLabelValue1}},
+					Summary: &clientmodel.Summary{
+						SampleCount: &count7,
+						SampleSum:   &value50,
+						Quantile: []*clientmodel.Quantile{
+							{Quantile: &quantile50, Value: &value42},
+							{Quantile: &quantile99, Value: &value50},
+						},
+					},
+					TimestampMs: &timestamp,
+				}},
+			}},
+		},
+		want: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName}, {Name: fooLabelName, Value: fooLabelValue1}, {Name: "quantile", Value: "0.5"}},
+			Samples: []prompb.Sample{{Value: value42, Timestamp: nowTimestamp}},
+		}, {
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName}, {Name: fooLabelName, Value: fooLabelValue1}, {Name: "quantile", Value: "0.99"}},
+			Samples: []prompb.Sample{{Value: value50, Timestamp: nowTimestamp}},
+		}, {
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName + "_sum"}, {Name: fooLabelName, Value: fooLabelValue1}},
+			Samples: []prompb.Sample{{Value: value50, Timestamp: nowTimestamp}},
+		}, {
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName + "_count"}, {Name: fooLabelName, Value: fooLabelValue1}},
+			Samples: []prompb.Sample{{Value: 7, Timestamp: nowTimestamp}},
+		}},
+	}, {
+		name: "histogram",
+		in: &PartitionedMetrics{
+			Families: []*clientmodel.MetricFamily{{
+				Name: &fooMetricName,
+				Help: &fooHelp,
+				Type: &histogram,
+				Metric: []*clientmodel.Metric{{
+					Label: []*clientmodel.LabelPair{{Name: &fooLabelName, Value: &fooLabelValue1}},
+					Histogram: &clientmodel.Histogram{
+						SampleCount: &count7,
+						SampleSum:   &value42,
+						Bucket: []*clientmodel.Bucket{
+							{UpperBound: &bound1, CumulativeCount: &count3},
+						},
+					},
+					TimestampMs: &timestamp,
+				}},
+			}},
+		},
+		want: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName + "_bucket"}, {Name: fooLabelName, Value: fooLabelValue1}, {Name: "le", Value: "1"}},
+			Samples: []prompb.Sample{{Value: 3, Timestamp: nowTimestamp}},
+		}, {
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName + "_bucket"}, {Name: fooLabelName, Value: fooLabelValue1}, {Name: "le", Value: "+Inf"}},
+			Samples: []prompb.Sample{{Value: 7, Timestamp: nowTimestamp}},
+		}, {
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName + "_sum"}, {Name: fooLabelName, Value: fooLabelValue1}},
+			Samples: []prompb.Sample{{Value: value42, Timestamp: nowTimestamp}},
+		}, {
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: fooMetricName + "_count"}, {Name: fooLabelName, Value: fooLabelValue1}},
+			Samples: []prompb.Sample{{Value: 7, Timestamp: nowTimestamp}},
+		}},
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			out, err := convertToTimeseries(tt.in, now)
+			out, err := convertToTimeseries(tt.in, now, false)
 			if err != nil {
 				t.Errorf("converting timeseries errored: %v", err)
 			}
@@ -244,6 +318,62 @@ func Test_convertToTimeseries(t *testing.T) {
 	}
 }
 
+func Test_convertToTimeseriesNativeHistogram(t *testing.T) {
+	histogram := clientmodel.MetricType_HISTOGRAM
+	name := "foo_seconds"
+	timestamp := int64(15615582020000)
+	schema := int32(3)
+	zeroThreshold := 1e-128
+	count := uint64(5)
+	zeroCount := uint64(1)
+	sum := 12.5
+	offset := int32(0)
+	length := uint32(2)
+
+	in := &PartitionedMetrics{
+		Families: []*clientmodel.MetricFamily{{
+			Name: &name,
+			Type: &histogram,
+			Metric: []*clientmodel.Metric{{
+				Histogram: &clientmodel.Histogram{
+					SampleCount:   &count,
+					SampleSum:     &sum,
+					Schema:        &schema,
+					ZeroThreshold: &zeroThreshold,
+					ZeroCount:     &zeroCount,
+					PositiveSpan:  []*clientmodel.BucketSpan{{Offset: &offset, Length: &length}},
+					PositiveDelta: []int64{2, 0},
+				},
+				TimestampMs: &timestamp,
+			}},
+		}},
+	}
+
+	out, err := convertToTimeseries(in, time.Now(), true)
+	if err != nil {
+		t.Fatalf("converting timeseries errored: %v", err)
+	}
+	if len(out) != 1 || len(out[0].Histograms) != 1 || len(out[0].Samples) != 0 {
+		t.Fatalf("expected a single native histogram series, got %v", out)
+	}
+	h := out[0].Histograms[0]
+	if h.GetCountInt() != count || h.GetZeroCountInt() != zeroCount || h.Sum != sum || h.Schema != schema {
+		t.Errorf("native histogram doesn't match: %v", h)
+	}
+	if len(h.PositiveSpans) != 1 || h.PositiveSpans[0].Length != length || len(h.PositiveDeltas) != 2 {
+		t.Errorf("native histogram buckets don't match: %v", h)
+	}
+
+	// Without native histograms enabled only _sum and _count can be derived.
+	out, err = convertToTimeseries(in, time.Now(), false)
+	if err != nil {
+		t.Fatalf("converting timeseries errored: %v", err)
+	}
+	if len(out) != 2 {
+		t.Errorf("expected _sum and _count series, got %d series", len(out))
+	}
+}
+
 func timeseriesEqual(t1 []prompb.TimeSeries, t2 []prompb.TimeSeries) (bool, error) {
 	if len(t1) != len(t2) {
 		return false, fmt.Errorf("timeseries don't match amount of series: %d != %d", len(t1), len(t2))
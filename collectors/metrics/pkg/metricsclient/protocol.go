@@ -0,0 +1,262 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
+)
+
+// Protocol is the version of the remote write protocol spoken by a Client.
+type Protocol string
+
+const (
+	// ProtocolV1 sends prompb.WriteRequest messages, as in remote write 1.0.
+	ProtocolV1 Protocol = "1.0"
+	// ProtocolV2 sends io.prometheus.write.v2.Request messages, which intern label names
+	// and values in a symbol table and carry the metadata of every series.
+	ProtocolV2 Protocol = "2.0"
+
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+
+	defaultRequestTimeout = 5 * time.Second
+)
+
+const (
+	contentTypeV1 = "application/x-protobuf"
+	contentTypeV2 = "application/x-protobuf;proto=io.prometheus.write.v2.Request"
+	versionHeader = "X-Prometheus-Remote-Write-Version"
+	versionV1     = "0.1.0"
+	versionV2     = "2.0.0"
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+)
+
+// ValidateProtocol returns an error if the protocol or the compression is not supported.
+// Empty values select remote write 1.0 and snappy.
+func ValidateProtocol(protocol Protocol, compression string) error {
+	switch protocol {
+	case "", ProtocolV1, ProtocolV2:
+	default:
+		return fmt.Errorf("unsupported remote write protocol %q, must be %s or %s", protocol, ProtocolV1, ProtocolV2)
+	}
+	switch compression {
+	case "", CompressionSnappy, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported remote write compression %q, must be %s or %s", compression, CompressionSnappy, CompressionZstd)
+	}
+	return nil
+}
+
+// encoding is the protocol and compression of the remote write requests.
+type encoding struct {
+	protocol    Protocol
+	compression string
+}
+
+func (e encoding) contentType() string {
+	if e.protocol == ProtocolV2 {
+		return contentTypeV2
+	}
+	return contentTypeV1
+}
+
+func (e encoding) version() string {
+	if e.protocol == ProtocolV2 {
+		return versionV2
+	}
+	return versionV1
+}
+
+// WithProtocol makes the client send its requests with the given protocol and compression.
+// Both are negotiated: when the endpoint answers 415 Unsupported Media Type, the client
+// falls back to snappy, then to remote write 1.0, and keeps the accepted encoding for the
+// following requests.
+func (c *Client) WithProtocol(protocol Protocol, compression string) *Client {
+	if len(protocol) == 0 {
+		protocol = ProtocolV1
+	}
+	if len(compression) == 0 {
+		compression = CompressionSnappy
+	}
+
+	c.encodingLock.Lock()
+	defer c.encodingLock.Unlock()
+	c.encoding = encoding{protocol: protocol, compression: compression}
+	return c
+}
+
+// WithRequestTimeout sets the timeout of a single remote write request. Retries are
+// bounded separately by the interval.
+func (c *Client) WithRequestTimeout(timeout time.Duration) *Client {
+	if timeout > 0 {
+		c.requestTimeout = timeout
+	}
+	return c
+}
+
+func (c *Client) currentEncoding() encoding {
+	c.encodingLock.Lock()
+	defer c.encodingLock.Unlock()
+	return c.encoding
+}
+
+// downgrade falls back from the encoding e, rejected by the endpoint. It returns false
+// when there is nothing left to fall back to.
+func (c *Client) downgrade(e encoding) bool {
+	c.encodingLock.Lock()
+	defer c.encodingLock.Unlock()
+	if c.encoding != e {
+		// Another shard already fell back.
+		return true
+	}
+	switch {
+	case e.compression != CompressionSnappy:
+		c.encoding.compression = CompressionSnappy
+	case e.protocol != ProtocolV1:
+		c.encoding.protocol = ProtocolV1
+	default:
+		return false
+	}
+	logger.Log(c.logger, logger.Warn, "msg", "remote write encoding not supported by the endpoint, falling back",
+		"protocol", c.encoding.protocol, "compression", c.encoding.compression)
+	return true
+}
+
+// encodeRequest marshals and compresses the series with the encoding e.
+func (c *Client) encodeRequest(e encoding, timeseries []prompb.TimeSeries) ([]byte, error) {
+	var data []byte
+	var err error
+	if e.protocol == ProtocolV2 {
+		data, err = marshalV2(timeseries, c.seriesMetadata)
+	} else {
+		data, err = proto.Marshal(&prompb.WriteRequest{
+			Timeseries: timeseries,
+			Metadata:   c.requestMetadata(timeseries),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if e.compression == CompressionZstd {
+		zstdOnce.Do(func() {
+			zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		})
+		if zstdErr != nil {
+			return nil, zstdErr
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// recordMetadata keeps the metadata of the families by the name of the series they are
+// converted to, so that it is sent along with those series.
+func (c *Client) recordMetadata(families []*clientmodel.MetricFamily) {
+	metadata := make(map[string]prompb.MetricMetadata, len(families))
+	for _, f := range families {
+		if f == nil {
+			continue
+		}
+		md := prompb.MetricMetadata{
+			Type:             metadataType(f.GetType()),
+			MetricFamilyName: f.GetName(),
+			Help:             f.GetHelp(),
+		}
+		for _, name := range seriesNames(f) {
+			metadata[name] = md
+		}
+	}
+
+	c.metadataLock.Lock()
+	defer c.metadataLock.Unlock()
+	c.metadata = metadata
+}
+
+// seriesMetadata returns the metadata of the series named name.
+func (c *Client) seriesMetadata(name string) (prompb.MetricMetadata, bool) {
+	c.metadataLock.RLock()
+	defer c.metadataLock.RUnlock()
+	md, ok := c.metadata[name]
+	return md, ok
+}
+
+// requestMetadata returns the metadata of the families of the series, which remote write
+// 1.0 sends once per family instead of once per series.
+func (c *Client) requestMetadata(timeseries []prompb.TimeSeries) []prompb.MetricMetadata {
+	c.metadataLock.RLock()
+	defer c.metadataLock.RUnlock()
+	if len(c.metadata) == 0 {
+		return nil
+	}
+
+	var metadata []prompb.MetricMetadata
+	seen := map[string]struct{}{}
+	for i := range timeseries {
+		md, ok := c.metadata[seriesName(timeseries[i].Labels)]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[md.MetricFamilyName]; ok {
+			continue
+		}
+		seen[md.MetricFamilyName] = struct{}{}
+		metadata = append(metadata, md)
+	}
+	return metadata
+}
+
+// seriesNames returns the names of the series a family is converted to.
+func seriesNames(f *clientmodel.MetricFamily) []string {
+	name := f.GetName()
+	switch f.GetType() {
+	case clientmodel.MetricType_SUMMARY:
+		return []string{name, name + "_sum", name + "_count"}
+	case clientmodel.MetricType_HISTOGRAM, clientmodel.MetricType_GAUGE_HISTOGRAM:
+		// Native histograms keep the name of the family.
+		return []string{name, name + "_bucket", name + "_sum", name + "_count"}
+	}
+	return []string{name}
+}
+
+func seriesName(labels []prompb.Label) string {
+	for _, l := range labels {
+		if l.Name == nameLabelName {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func metadataType(t clientmodel.MetricType) prompb.MetricMetadata_MetricType {
+	switch t {
+	case clientmodel.MetricType_COUNTER:
+		return prompb.MetricMetadata_COUNTER
+	case clientmodel.MetricType_GAUGE:
+		return prompb.MetricMetadata_GAUGE
+	case clientmodel.MetricType_SUMMARY:
+		return prompb.MetricMetadata_SUMMARY
+	case clientmodel.MetricType_HISTOGRAM:
+		return prompb.MetricMetadata_HISTOGRAM
+	case clientmodel.MetricType_GAUGE_HISTOGRAM:
+		return prompb.MetricMetadata_GAUGEHISTOGRAM
+	}
+	return prompb.MetricMetadata_UNKNOWN
+}
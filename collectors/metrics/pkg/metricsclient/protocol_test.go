@@ -0,0 +1,203 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// v2Series is a decoded remote write 2.0 time series.
+type v2Series struct {
+	labels  map[string]string
+	samples int
+	help    string
+	mtype   uint64
+}
+
+// decodeV2 decodes the symbols and series of a remote write 2.0 request.
+func decodeV2(t *testing.T, data []byte) ([]string, []v2Series) {
+	var symbols []string
+	var raw [][]byte
+	forEachField(t, data, func(num protowire.Number, v []byte, _ uint64) {
+		switch num {
+		case requestSymbolsField:
+			symbols = append(symbols, string(v))
+		case requestTimeseriesField:
+			raw = append(raw, v)
+		}
+	})
+
+	var series []v2Series
+	for _, b := range raw {
+		s := v2Series{labels: map[string]string{}}
+		forEachField(t, b, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case seriesLabelsRefsField:
+				var refs []uint64
+				for len(v) > 0 {
+					ref, n := protowire.ConsumeVarint(v)
+					refs = append(refs, ref)
+					v = v[n:]
+				}
+				for i := 0; i+1 < len(refs); i += 2 {
+					s.labels[symbols[refs[i]]] = symbols[refs[i+1]]
+				}
+			case seriesSamplesField:
+				s.samples++
+			case seriesMetadataField:
+				forEachField(t, v, func(num protowire.Number, _ []byte, n uint64) {
+					switch num {
+					case metadataTypeField:
+						s.mtype = n
+					case metadataHelpRefField:
+						s.help = symbols[n]
+					}
+				})
+			}
+		})
+		series = append(series, s)
+	}
+	return symbols, series
+}
+
+func forEachField(t *testing.T, b []byte, fn func(protowire.Number, []byte, uint64)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			fn(num, v, 0)
+			n = m
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			fn(num, nil, v)
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+}
+
+func TestMarshalV2(t *testing.T) {
+	c := New(log.NewNopLogger(), testClientMetrics(), http.DefaultClient, 0, time.Minute, "test")
+	c.recordMetadata([]*clientmodel.MetricFamily{{
+		Name: stringPtr("foo"),
+		Help: stringPtr("The foo of the cluster."),
+		Type: clientmodel.MetricType_COUNTER.Enum(),
+	}})
+
+	data, err := marshalV2(testTimeseries(3), c.seriesMetadata)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	symbols, series := decodeV2(t, data)
+
+	// The empty string, __name__, foo, id, 0, 1, 2 and the help are interned once.
+	if len(symbols) != 8 || symbols[0] != "" {
+		t.Errorf("unexpected symbols %q", symbols)
+	}
+	if len(series) != 3 {
+		t.Fatalf("expected 3 series, got %d", len(series))
+	}
+	for i, s := range series {
+		want := map[string]string{nameLabelName: "foo", "id": fmt.Sprint(i)}
+		if !reflect.DeepEqual(s.labels, want) {
+			t.Errorf("expected labels %v, got %v", want, s.labels)
+		}
+		if s.samples != 1 {
+			t.Errorf("expected 1 sample, got %d", s.samples)
+		}
+		if s.help != "The foo of the cluster." || s.mtype != uint64(prompb.MetricMetadata_COUNTER) {
+			t.Errorf("expected the counter metadata, got type %d and help %q", s.mtype, s.help)
+		}
+	}
+}
+
+func TestRequestMetadata(t *testing.T) {
+	c := New(log.NewNopLogger(), testClientMetrics(), http.DefaultClient, 0, time.Minute, "test")
+	c.recordMetadata([]*clientmodel.MetricFamily{{
+		Name: stringPtr("foo"),
+		Help: stringPtr("help"),
+		Type: clientmodel.MetricType_HISTOGRAM.Enum(),
+	}})
+
+	timeseries := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: nameLabelName, Value: "foo_bucket"}, {Name: "le", Value: "1"}}},
+		{Labels: []prompb.Label{{Name: nameLabelName, Value: "foo_sum"}}},
+		{Labels: []prompb.Label{{Name: nameLabelName, Value: "bar"}}},
+	}
+	want := []prompb.MetricMetadata{{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "foo", Help: "help"}}
+	if got := c.requestMetadata(timeseries); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected metadata %v, got %v", want, got)
+	}
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		received = append(received, r.Header.Get(versionHeader)+" "+r.Header.Get("Content-Encoding"))
+		lock.Unlock()
+		if r.Header.Get("Content-Type") != contentTypeV1 || r.Header.Get("Content-Encoding") != CompressionSnappy {
+			http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	metrics := testClientMetrics()
+	c := New(log.NewNopLogger(), metrics, http.DefaultClient, 0, time.Minute, "test").
+		WithProtocol(ProtocolV2, CompressionZstd)
+	for i := 0; i < 2; i++ {
+		if err := c.WriteTimeseries(context.Background(), &http.Request{Method: "POST", URL: u}, testTimeseries(4), time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := []string{"2.0.0 zstd", "2.0.0 snappy", "0.1.0 snappy", "0.1.0 snappy"}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("expected requests %v, got %v", want, received)
+	}
+	if v := testutil.ToFloat64(metrics.ShardSentSeries.WithLabelValues("0")); v != 8 {
+		t.Errorf("expected 8 sent series, got %v", v)
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	if err := ValidateProtocol(ProtocolV2, CompressionZstd); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateProtocol("3.0", ""); err == nil {
+		t.Error("expected an error for an unknown protocol")
+	}
+	if err := ValidateProtocol("", "gzip"); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+}
+
+func stringPtr(s string) *string { return &s }
@@ -0,0 +1,93 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// requestError is returned when the remote write endpoint answers with a non-2xx status.
+type requestError struct {
+	statusCode int
+	// retryAfter is the wait requested by the endpoint in its Retry-After header.
+	retryAfter time.Duration
+	msg        string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+// retryable reports whether the request may succeed when sent again. As in the remote
+// write specification, server errors and 429 Too Many Requests are retried.
+func (e *requestError) retryable() bool {
+	return e.statusCode/100 == 5 || e.statusCode == http.StatusTooManyRequests
+}
+
+// droppable reports whether the endpoint rejected the samples for good, such as
+// out-of-order or duplicate samples, or a single series too large to be accepted.
+// Other client errors, like authentication failures, are left to fail the write so
+// that they are noticed.
+func (e *requestError) droppable() bool {
+	switch e.statusCode {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge:
+		return true
+	}
+	return false
+}
+
+// reason is the reason label of the dropped series.
+func (e *requestError) reason() string {
+	switch e.statusCode {
+	case http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case http.StatusConflict:
+		return "conflict"
+	}
+	return "bad_request"
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retryAfterBackOff is an exponential back-off that waits at least for the Retry-After
+// duration of the last response. It stops when that wait would exceed MaxElapsedTime,
+// so that a write never outlasts its interval.
+type retryAfterBackOff struct {
+	*backoff.ExponentialBackOff
+	retryAfter time.Duration
+}
+
+// NextBackOff implements the backoff.BackOff interface.
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.ExponentialBackOff.NextBackOff()
+	wait := b.retryAfter
+	b.retryAfter = 0
+	if next == backoff.Stop || wait <= next {
+		return next
+	}
+	if b.MaxElapsedTime != 0 && b.GetElapsedTime()+wait > b.MaxElapsedTime {
+		return backoff.Stop
+	}
+	return wait
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"5":                             5 * time.Second,
+		"-1":                            0,
+		"Sun, 01 Oct 2023 12:00:30 GMT": 30 * time.Second,
+		"Sun, 01 Oct 2023 11:00:00 GMT": 0,
+		"soon":                          0,
+	} {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("expected Retry-After %q to be %s, got %s", value, want, got)
+		}
+	}
+}
+
+func testClientMetrics() *ClientMetrics {
+	return &ClientMetrics{
+		ForwardRemoteWriteRequests: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"status_code"}),
+		Shards:                     prometheus.NewGauge(prometheus.GaugeOpts{Name: "shards"}),
+		ShardSentSeries:            prometheus.NewCounterVec(prometheus.CounterOpts{Name: "sent"}, []string{"shard"}),
+		ShardFailedSeries:          prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failed"}, []string{"shard"}),
+		ShardRequestDuration:       prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"shard"}),
+		DroppedSeries:              prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped"}, []string{"reason"}),
+	}
+}
+
+// requestSeries returns the number of series of a remote write request.
+func requestSeries(t *testing.T, r *http.Request) int {
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Errorf("failed to read request: %v", err)
+		return 0
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Errorf("failed to decompress request: %v", err)
+		return 0
+	}
+	var wreq prompb.WriteRequest
+	if err := proto.Unmarshal(data, &wreq); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+		return 0
+	}
+	return len(wreq.Timeseries)
+}
+
+func TestWriteTimeseriesResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    func(requests int32, series int, w http.ResponseWriter)
+		minWait    time.Duration
+		wantErr    bool
+		wantUnsent int
+		wantSent   float64
+		wantDrop   float64
+		wantCalls  int32
+	}{
+		{
+			name: "out of order samples are dropped",
+			handler: func(_ int32, _ int, w http.ResponseWriter) {
+				http.Error(w, "out of order sample", http.StatusBadRequest)
+			},
+			wantDrop:  4,
+			wantCalls: 1,
+		},
+		{
+			name: "too large requests are split",
+			handler: func(_ int32, series int, w http.ResponseWriter) {
+				if series > 1 {
+					http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+				}
+			},
+			wantSent:  4,
+			wantCalls: 7,
+		},
+		{
+			name: "too many requests are retried after the requested wait",
+			handler: func(requests int32, _ int, w http.ResponseWriter) {
+				if requests == 1 {
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, "slow down", http.StatusTooManyRequests)
+				}
+			},
+			minWait:   time.Second,
+			wantSent:  4,
+			wantCalls: 2,
+		},
+		{
+			name: "authentication failures are neither retried nor dropped",
+			handler: func(_ int32, _ int, w http.ResponseWriter) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			},
+			wantErr:    true,
+			wantUnsent: 4,
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			var last time.Time
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				if n > 1 && time.Since(last) < tt.minWait {
+					t.Errorf("expected the client to wait for Retry-After, retried after %s", time.Since(last))
+				}
+				last = time.Now()
+				tt.handler(n, requestSeries(t, r), w)
+			}))
+			defer ts.Close()
+			u, _ := url.Parse(ts.URL)
+
+			metrics := testClientMetrics()
+			c := New(log.NewNopLogger(), metrics, http.DefaultClient, 0, time.Minute, "test")
+			err := c.WriteTimeseries(context.Background(), &http.Request{Method: "POST", URL: u}, testTimeseries(4), time.Minute)
+
+			var writeErr *WriteError
+			if tt.wantErr {
+				if !errors.As(err, &writeErr) || len(writeErr.Unsent) != tt.wantUnsent {
+					t.Errorf("expected a write error with %d unsent series, got %v", tt.wantUnsent, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if v := testutil.ToFloat64(metrics.ShardSentSeries.WithLabelValues("0")); v != tt.wantSent {
+				t.Errorf("expected %v sent series, got %v", tt.wantSent, v)
+			}
+			dropped := testutil.ToFloat64(metrics.DroppedSeries.WithLabelValues("bad_request"))
+			if dropped != tt.wantDrop {
+				t.Errorf("expected %v dropped series, got %v", tt.wantDrop, dropped)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("expected %d requests, got %d", tt.wantCalls, got)
+			}
+		})
+	}
+}
+
+func TestWriteTimeseriesWithoutShardMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestSeries(t, r) > 1 {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	// The shard metrics are optional, like the other metrics added to ClientMetrics.
+	metrics := &ClientMetrics{
+		ForwardRemoteWriteRequests: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"status_code"}),
+	}
+	c := New(log.NewNopLogger(), metrics, http.DefaultClient, 0, time.Minute, "test")
+	if err := c.WriteTimeseries(context.Background(), &http.Request{Method: "POST", URL: u}, testTimeseries(2), time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ts.Close()
+	if err := c.WriteTimeseries(context.Background(), &http.Request{Method: "POST", URL: u}, testTimeseries(2), time.Second); err == nil {
+		t.Error("expected the write to a closed server to fail")
+	}
+}
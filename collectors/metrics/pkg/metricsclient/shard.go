@@ -0,0 +1,115 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
+)
+
+const (
+	// reshardTolerance is the relative change in desired shards below which the
+	// number of shards is kept, to avoid flapping on small throughput variations.
+	reshardTolerance = 0.3
+)
+
+// WithShards sets the bounds of the number of concurrent senders used by WriteTimeseries.
+// The client starts with minShards and adapts the number of shards to the observed
+// throughput, so that a write completes within a quarter of the interval.
+// Setting minShards equal to maxShards disables resharding.
+func (c *Client) WithShards(minShards, maxShards int) *Client {
+	if minShards < 1 {
+		minShards = 1
+	}
+	if maxShards < minShards {
+		maxShards = minShards
+	}
+
+	c.shardLock.Lock()
+	defer c.shardLock.Unlock()
+	c.minShards = minShards
+	c.maxShards = maxShards
+	c.shards = minShards
+	c.setShardsMetric()
+	return c
+}
+
+func (c *Client) currentShards() int {
+	c.shardLock.Lock()
+	defer c.shardLock.Unlock()
+	return c.shards
+}
+
+// reshard adapts the number of shards to the throughput of the last write, which
+// sent series time series over n shards in elapsed time.
+func (c *Client) reshard(series, n int, elapsed, interval time.Duration) {
+	c.shardLock.Lock()
+	defer c.shardLock.Unlock()
+
+	if c.minShards == c.maxShards || series == 0 || n == 0 || elapsed <= 0 || interval <= 0 {
+		return
+	}
+
+	// Series per second a single shard is able to send. At that throughput, the
+	// write completes in a quarter of the interval with the desired number of shards.
+	throughput := float64(series) / float64(n) / elapsed.Seconds()
+	desired := float64(n) * elapsed.Seconds() / (interval / 4).Seconds()
+	if math.Abs(desired-float64(c.shards))/float64(c.shards) < reshardTolerance {
+		return
+	}
+
+	shards := int(math.Ceil(desired))
+	if shards < c.minShards {
+		shards = c.minShards
+	}
+	if shards > c.maxShards {
+		shards = c.maxShards
+	}
+	if shards == c.shards {
+		return
+	}
+
+	logger.Log(c.logger, logger.Info, "msg", "resharding remote write", "from", c.shards, "to", shards,
+		"throughput_per_shard", throughput)
+	c.shards = shards
+	c.setShardsMetric()
+}
+
+func (c *Client) setShardsMetric() {
+	if c.metrics != nil && c.metrics.Shards != nil {
+		c.metrics.Shards.Set(float64(c.shards))
+	}
+}
+
+// shardTimeseries spreads timeseries over n shards by the hash of their labels, so
+// that a series is always sent by the same shard as long as n does not change.
+func shardTimeseries(timeseries []prompb.TimeSeries, n int) [][]prompb.TimeSeries {
+	if n <= 1 {
+		return [][]prompb.TimeSeries{timeseries}
+	}
+
+	shards := make([][]prompb.TimeSeries, n)
+	for _, ts := range timeseries {
+		i := labelsHash(ts.Labels) % uint64(n)
+		shards[i] = append(shards[i], ts)
+	}
+	return shards
+}
+
+func labelsHash(ls []prompb.Label) uint64 {
+	h := fnv.New64a()
+	for _, l := range ls {
+		_, _ = h.Write([]byte(l.Name))
+		_, _ = h.Write([]byte{0xff})
+		_, _ = h.Write([]byte(l.Value))
+		_, _ = h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
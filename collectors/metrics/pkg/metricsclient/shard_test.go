@@ -0,0 +1,90 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testTimeseries(n int) []prompb.TimeSeries {
+	timeseries := make([]prompb.TimeSeries, 0, n)
+	for i := 0; i < n; i++ {
+		timeseries = append(timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: nameLabelName, Value: "foo"}, {Name: "id", Value: fmt.Sprint(i)}},
+			Samples: []prompb.Sample{{Value: float64(i), Timestamp: 1000}},
+		})
+	}
+	return timeseries
+}
+
+func TestShardTimeseries(t *testing.T) {
+	timeseries := testTimeseries(1000)
+
+	shards := shardTimeseries(timeseries, 4)
+	if len(shards) != 4 {
+		t.Fatalf("expected 4 shards, got %d", len(shards))
+	}
+	total := 0
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			t.Errorf("expected shard %d to hold series", i)
+		}
+		total += len(shard)
+		for _, ts := range shard {
+			if labelsHash(ts.Labels)%4 != uint64(i) {
+				t.Errorf("series %v is not in its hashed shard %d", ts.Labels, i)
+			}
+		}
+	}
+	if total != len(timeseries) {
+		t.Errorf("expected %d series over all shards, got %d", len(timeseries), total)
+	}
+
+	if shards := shardTimeseries(timeseries, 1); len(shards) != 1 || len(shards[0]) != len(timeseries) {
+		t.Errorf("expected a single shard holding all series")
+	}
+}
+
+func TestReshard(t *testing.T) {
+	c := New(log.NewNopLogger(), &ClientMetrics{
+		Shards: prometheus.NewGauge(prometheus.GaugeOpts{Name: "shards"}),
+	}, nil, 0, time.Minute, "test").WithShards(1, 8)
+	interval := 4 * time.Minute
+
+	// A write taking half the interval on one shard needs two times the target of a quarter interval.
+	c.reshard(1000, 1, 2*time.Minute, interval)
+	if c.currentShards() != 2 {
+		t.Errorf("expected 2 shards, got %d", c.currentShards())
+	}
+
+	// Small variations do not change the number of shards.
+	c.reshard(1000, 2, 70*time.Second, interval)
+	if c.currentShards() != 2 {
+		t.Errorf("expected 2 shards, got %d", c.currentShards())
+	}
+
+	// The number of shards is bounded.
+	c.reshard(1000, 2, time.Hour, interval)
+	if c.currentShards() != 8 {
+		t.Errorf("expected 8 shards, got %d", c.currentShards())
+	}
+	c.reshard(1000, 8, time.Millisecond, interval)
+	if c.currentShards() != 1 {
+		t.Errorf("expected 1 shard, got %d", c.currentShards())
+	}
+
+	// Resharding is disabled with equal bounds.
+	c.WithShards(3, 3)
+	c.reshard(1000, 3, time.Hour, interval)
+	if c.currentShards() != 3 {
+		t.Errorf("expected 3 shards, got %d", c.currentShards())
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
+)
+
+// StaleSeries remembers the series written in the previous cycle, so that the series that
+// disappeared since are ended with a staleness marker, as Prometheus does when a target
+// stops exposing a series. Without the marker, queries return the last sample of a vanished
+// series for the whole lookback delta.
+type StaleSeries struct {
+	lock sync.Mutex
+	last map[uint64][]prompb.Label
+	// skip makes the next Mark keep the previous series instead of marking them, and
+	// skipMetrics the previous series of some metrics only.
+	skip        bool
+	skipMetrics map[string]bool
+}
+
+// NewStaleSeries returns a StaleSeries that has not seen any series yet.
+func NewStaleSeries() *StaleSeries {
+	return &StaleSeries{last: map[uint64][]prompb.Label{}}
+}
+
+// Mark returns timeseries followed by a staleness marker at timestamp for every series
+// of the previous call that is not part of timeseries, and remembers timeseries for the
+// next call.
+func (s *StaleSeries) Mark(timeseries []prompb.TimeSeries, timestamp int64) []prompb.TimeSeries {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := make(map[uint64][]prompb.Label, len(timeseries))
+	for _, ts := range timeseries {
+		current[labelsHash(ts.Labels)] = ts.Labels
+	}
+	for h, ls := range s.last {
+		if _, ok := current[h]; ok {
+			continue
+		}
+		if s.skip || s.skipMetrics[metricName(ls)] {
+			current[h] = ls
+			continue
+		}
+		timeseries = append(timeseries, prompb.TimeSeries{
+			Labels:  ls,
+			Samples: []prompb.Sample{{Value: math.Float64frombits(value.StaleNaN), Timestamp: timestamp}},
+		})
+	}
+	s.last = current
+	s.skip = false
+	s.skipMetrics = nil
+	return timeseries
+}
+
+// Skip makes the next Mark remember the series of the previous call along with the new
+// ones instead of marking them stale, for a cycle in which some series are missing because
+// they could not be retrieved. They are marked on a later call if they are still missing.
+func (s *StaleSeries) Skip() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.skip = true
+}
+
+// SkipMetrics is Skip for the series of the metrics of names only, for a cycle in which some
+// of their series are dropped by a limit.
+func (s *StaleSeries) SkipMetrics(names []string) {
+	if s == nil || len(names) == 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.skipMetrics == nil {
+		s.skipMetrics = map[string]bool{}
+	}
+	for _, name := range names {
+		s.skipMetrics[name] = true
+	}
+}
+
+func metricName(labels []prompb.Label) string {
+	for _, l := range labels {
+		if l.Name == nameLabelName {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// WithStaleSeries makes RemoteWrite end the series that disappeared since its previous
+// call with a staleness marker. The StaleSeries is kept by the caller, so that it outlives
+// the client when the client is recreated. A nil StaleSeries disables the markers.
+func (c *Client) WithStaleSeries(s *StaleSeries) *Client {
+	c.staleSeries = s
+	return c
+}
+
+// MarkStale returns timeseries followed by the staleness markers of the series that
+// disappeared since the previous call, when the client has a StaleSeries. It is called
+// by RemoteWrite, and must be called for the series written otherwise.
+func (c *Client) MarkStale(timeseries []prompb.TimeSeries) []prompb.TimeSeries {
+	if c.staleSeries == nil {
+		return timeseries
+	}
+	n := len(timeseries)
+	timeseries = c.staleSeries.Mark(timeseries, time.Now().UnixNano()/int64(time.Millisecond))
+	if stale := len(timeseries) - n; stale > 0 {
+		logger.Log(c.logger, logger.Debug, "msg", "marking disappeared series as stale", "series", stale)
+		if c.metrics.StaleMarkers != nil {
+			c.metrics.StaleMarkers.Add(float64(stale))
+		}
+	}
+	return timeseries
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestStaleSeriesMark(t *testing.T) {
+	s := NewStaleSeries()
+
+	if got := s.Mark(testTimeseries(3), 1000); len(got) != 3 {
+		t.Fatalf("expected no staleness marker on the first call, got %d series", len(got))
+	}
+	if got := s.Mark(testTimeseries(3), 2000); len(got) != 3 {
+		t.Fatalf("expected no staleness marker when no series disappeared, got %d series", len(got))
+	}
+
+	got := s.Mark(testTimeseries(1), 3000)
+	if len(got) != 3 {
+		t.Fatalf("expected 2 staleness markers, got %d series", len(got)-1)
+	}
+	for _, ts := range got[1:] {
+		if ts.Labels[1].Value == "0" {
+			t.Errorf("unexpected staleness marker for a present series %v", ts.Labels)
+		}
+		if len(ts.Samples) != 1 || !value.IsStaleNaN(ts.Samples[0].Value) || ts.Samples[0].Timestamp != 3000 {
+			t.Errorf("expected a staleness marker at 3000, got %v", ts.Samples)
+		}
+	}
+	if math.Float64bits(got[1].Samples[0].Value) != value.StaleNaN {
+		t.Errorf("expected the staleness NaN, got %x", math.Float64bits(got[1].Samples[0].Value))
+	}
+
+	if got := s.Mark(nil, 4000); len(got) != 1 {
+		t.Errorf("expected a staleness marker for the last series, got %d series", len(got))
+	}
+	if got := s.Mark(nil, 5000); len(got) != 0 {
+		t.Errorf("expected stale series to be marked only once, got %d series", len(got))
+	}
+}
+
+func TestStaleSeriesSkip(t *testing.T) {
+	s := NewStaleSeries()
+	s.Mark(testTimeseries(3), 1000)
+
+	// The missing series are kept instead of being marked.
+	s.Skip()
+	if got := s.Mark(testTimeseries(1), 2000); len(got) != 1 {
+		t.Fatalf("expected no staleness marker after Skip, got %d series", len(got))
+	}
+	if got := s.Mark(testTimeseries(1), 3000); len(got) != 3 {
+		t.Errorf("expected the series still missing to be marked, got %d series", len(got))
+	}
+
+	var nilSeries *StaleSeries
+	nilSeries.Skip()
+}
+
+func TestStaleSeriesSkipMetrics(t *testing.T) {
+	s := NewStaleSeries()
+	timeseries := append(testTimeseries(2), prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: nameLabelName, Value: "bar"}, {Name: "id", Value: "0"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	})
+	s.Mark(timeseries, 1000)
+
+	// Only the missing series of the skipped metrics are kept.
+	s.SkipMetrics([]string{"foo"})
+	got := s.Mark(testTimeseries(1), 2000)
+	if len(got) != 2 || got[1].Labels[0].Value != "bar" {
+		t.Fatalf("expected a staleness marker for bar only, got %v", got)
+	}
+	if got := s.Mark(testTimeseries(1), 3000); len(got) != 2 {
+		t.Errorf("expected the series of foo still missing to be marked, got %d series", len(got))
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package metricsclient
+
+import (
+	"math"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the io.prometheus.write.v2 messages of the remote write 2.0
+// specification, whose generated code is not part of the Prometheus version in use.
+const (
+	requestSymbolsField    protowire.Number = 4
+	requestTimeseriesField protowire.Number = 5
+
+	seriesLabelsRefsField protowire.Number = 1
+	seriesSamplesField    protowire.Number = 2
+	seriesHistogramsField protowire.Number = 3
+	seriesExemplarsField  protowire.Number = 4
+	seriesMetadataField   protowire.Number = 5
+
+	sampleValueField     protowire.Number = 1
+	sampleTimestampField protowire.Number = 2
+
+	exemplarLabelsRefsField protowire.Number = 1
+	exemplarValueField      protowire.Number = 2
+	exemplarTimestampField  protowire.Number = 3
+
+	metadataTypeField    protowire.Number = 1
+	metadataHelpRefField protowire.Number = 3
+	metadataUnitRefField protowire.Number = 4
+)
+
+// symbolTable interns the strings of a remote write 2.0 request. The first symbol is
+// always the empty string.
+type symbolTable struct {
+	symbols []string
+	refs    map[string]uint32
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{symbols: []string{""}, refs: map[string]uint32{"": 0}}
+}
+
+func (t *symbolTable) ref(s string) uint32 {
+	if ref, ok := t.refs[s]; ok {
+		return ref
+	}
+	ref := uint32(len(t.symbols))
+	t.symbols = append(t.symbols, s)
+	t.refs[s] = ref
+	return ref
+}
+
+// labelRefs returns the name and value references of the labels.
+func (t *symbolTable) labelRefs(labels []prompb.Label) []uint32 {
+	refs := make([]uint32, 0, 2*len(labels))
+	for _, l := range labels {
+		refs = append(refs, t.ref(l.Name), t.ref(l.Value))
+	}
+	return refs
+}
+
+// marshalV2 encodes the series as a remote write 2.0 request. metadata returns the metadata
+// of a series by its name.
+func marshalV2(timeseries []prompb.TimeSeries, metadata func(string) (prompb.MetricMetadata, bool)) ([]byte, error) {
+	symbols := newSymbolTable()
+
+	var series []byte
+	for i := range timeseries {
+		ts := &timeseries[i]
+
+		b := appendRefs(nil, seriesLabelsRefsField, symbols.labelRefs(ts.Labels))
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, sampleValueField, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, sampleTimestampField, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+			b = appendMessage(b, seriesSamplesField, sb)
+		}
+		for j := range ts.Histograms {
+			// The histogram message is the same in both versions of the protocol.
+			hb, err := proto.Marshal(&ts.Histograms[j])
+			if err != nil {
+				return nil, err
+			}
+			b = appendMessage(b, seriesHistogramsField, hb)
+		}
+		for _, e := range ts.Exemplars {
+			eb := appendRefs(nil, exemplarLabelsRefsField, symbols.labelRefs(e.Labels))
+			eb = protowire.AppendTag(eb, exemplarValueField, protowire.Fixed64Type)
+			eb = protowire.AppendFixed64(eb, math.Float64bits(e.Value))
+			eb = protowire.AppendTag(eb, exemplarTimestampField, protowire.VarintType)
+			eb = protowire.AppendVarint(eb, uint64(e.Timestamp))
+			b = appendMessage(b, seriesExemplarsField, eb)
+		}
+		if md, ok := metadata(seriesName(ts.Labels)); ok {
+			var mb []byte
+			// Both versions number the metric types alike.
+			mb = protowire.AppendTag(mb, metadataTypeField, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, uint64(md.Type))
+			if len(md.Help) > 0 {
+				mb = protowire.AppendTag(mb, metadataHelpRefField, protowire.VarintType)
+				mb = protowire.AppendVarint(mb, uint64(symbols.ref(md.Help)))
+			}
+			if len(md.Unit) > 0 {
+				mb = protowire.AppendTag(mb, metadataUnitRefField, protowire.VarintType)
+				mb = protowire.AppendVarint(mb, uint64(symbols.ref(md.Unit)))
+			}
+			b = appendMessage(b, seriesMetadataField, mb)
+		}
+		series = appendMessage(series, requestTimeseriesField, b)
+	}
+
+	var out []byte
+	for _, s := range symbols.symbols {
+		out = protowire.AppendTag(out, requestSymbolsField, protowire.BytesType)
+		out = protowire.AppendString(out, s)
+	}
+	return append(out, series...), nil
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// appendRefs appends the references as a packed repeated field.
+func appendRefs(b []byte, num protowire.Number, refs []uint32) []byte {
+	if len(refs) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, ref := range refs {
+		packed = protowire.AppendVarint(packed, uint64(ref))
+	}
+	return appendMessage(b, num, packed)
+}
@@ -0,0 +1,303 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+
+	rlogger "github.com/stolostron/multicluster-observability-operator/collectors/metrics/pkg/logger"
+)
+
+const (
+	segmentSuffix = ".seg"
+	tmpSuffix     = ".tmp"
+
+	dropReasonSize    = "size"
+	dropReasonAge     = "age"
+	dropReasonCorrupt = "corrupt"
+)
+
+// Metrics are the metrics reported by a Queue.
+type Metrics struct {
+	Segments     prometheus.Gauge
+	Bytes        prometheus.Gauge
+	DroppedBytes *prometheus.CounterVec
+}
+
+// Config defines the parameters of a Queue.
+// The only required field is `Dir`.
+type Config struct {
+	// Dir is the directory holding the segment files, typically an emptyDir or a PVC.
+	Dir string
+	// MaxBytes bounds the total size of the segment files. The oldest segments are
+	// dropped when the bound is exceeded. Zero means no limit.
+	MaxBytes int64
+	// MaxAge is the age after which a segment is dropped instead of replayed.
+	// Zero means no limit.
+	MaxAge time.Duration
+
+	Logger  log.Logger
+	Metrics *Metrics
+}
+
+type segment struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+// Queue is a bounded, persistent FIFO of remote write requests that could not be delivered.
+// Each request is stored as a snappy compressed segment file, so queued requests survive
+// a restart of the collector. Queue is thread safe.
+type Queue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	lock     sync.Mutex
+	segments []segment
+	// replayLock serializes the replays, which send without holding lock.
+	replayLock sync.Mutex
+	size       int64
+	next       uint64
+
+	logger  log.Logger
+	metrics *Metrics
+}
+
+// New opens the Queue in cfg.Dir, creating the directory if needed and loading
+// the segments left by a previous run.
+func New(cfg Config) (*Queue, error) {
+	if len(cfg.Dir) == 0 {
+		return nil, errors.New("a queue directory is required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	q := &Queue{
+		dir:      cfg.Dir,
+		maxBytes: cfg.MaxBytes,
+		maxAge:   cfg.MaxAge,
+		logger:   log.With(cfg.Logger, "component", "queue"),
+		metrics:  cfg.Metrics,
+	}
+
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, segmentSuffix+tmpSuffix) {
+			// A segment whose write was interrupted by a crash, it was never committed.
+			rlogger.Log(q.logger, rlogger.Warn, "msg", "removing uncommitted queue segment", "file", name)
+			if err := os.Remove(filepath.Join(cfg.Dir, name)); err != nil {
+				return nil, fmt.Errorf("failed to remove uncommitted queue segment %s: %w", name, err)
+			}
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			rlogger.Log(q.logger, rlogger.Warn, "msg", "ignoring unknown file in queue directory", "file", name)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat queue segment %s: %w", name, err)
+		}
+		q.segments = append(q.segments, segment{seq: seq, size: info.Size(), created: info.ModTime()})
+		q.size += info.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].seq < q.segments[j].seq })
+	if len(q.segments) > 0 {
+		q.next = q.segments[len(q.segments)-1].seq + 1
+		rlogger.Log(q.logger, rlogger.Info, "msg", "loaded queued segments", "segments", len(q.segments), "bytes", q.size)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.enforceLimits(time.Now())
+	return q, nil
+}
+
+// SetLimits changes the size and age limits of the queue, dropping the oldest
+// requests if the queue does not fit the new limits.
+func (q *Queue) SetLimits(maxBytes int64, maxAge time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.maxBytes = maxBytes
+	q.maxAge = maxAge
+	q.enforceLimits(time.Now())
+}
+
+// Len returns the number of queued requests.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.segments)
+}
+
+// Store appends a request to the end of the queue.
+// The oldest requests are dropped if the queue grows over its size limit.
+func (q *Queue) Store(wreq *prompb.WriteRequest) error {
+	data, err := proto.Marshal(wreq)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+	compressed := snappy.Encode(nil, data)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	seq := q.next
+	path := q.segmentPath(seq)
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, compressed, 0600); err != nil {
+		return fmt.Errorf("failed to write queue segment: %w", err)
+	}
+	// Rename is atomic, so a crash never leaves a partially written segment behind.
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to commit queue segment: %w", err)
+	}
+	q.next++
+
+	q.segments = append(q.segments, segment{seq: seq, size: int64(len(compressed)), created: time.Now()})
+	q.size += int64(len(compressed))
+	q.enforceLimits(time.Now())
+	return nil
+}
+
+// Replay passes the queued requests to send, oldest first, and removes every request that
+// was sent successfully. It stops at the first error, leaving that request and the newer
+// ones queued for the next attempt. Requests older than the maximum age are dropped.
+// The queue is not locked while a request is sent, so that requests can be stored meanwhile.
+func (q *Queue) Replay(send func(*prompb.WriteRequest) error) error {
+	q.replayLock.Lock()
+	defer q.replayLock.Unlock()
+
+	for {
+		s, wreq, ok := q.head()
+		if !ok {
+			return nil
+		}
+		if err := send(wreq); err != nil {
+			return err
+		}
+		q.lock.Lock()
+		q.removeSegment(s.seq)
+		q.lock.Unlock()
+	}
+}
+
+// head returns the oldest readable segment and its request, dropping the expired and the
+// unreadable segments.
+func (q *Queue) head() (segment, *prompb.WriteRequest, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.enforceLimits(time.Now())
+	for len(q.segments) > 0 {
+		s := q.segments[0]
+		wreq, err := q.read(s)
+		if err != nil {
+			rlogger.Log(q.logger, rlogger.Warn, "msg", "dropping unreadable queue segment", "seq", s.seq, "err", err)
+			q.drop(dropReasonCorrupt)
+			continue
+		}
+		return s, wreq, true
+	}
+	return segment{}, nil, false
+}
+
+func (q *Queue) read(s segment) (*prompb.WriteRequest, error) {
+	compressed, err := os.ReadFile(q.segmentPath(s.seq))
+	if err != nil {
+		return nil, err
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+	wreq := &prompb.WriteRequest{}
+	if err := proto.Unmarshal(data, wreq); err != nil {
+		return nil, err
+	}
+	return wreq, nil
+}
+
+// enforceLimits drops the oldest segments until the queue fits its limits.
+// Must be called with the lock held.
+func (q *Queue) enforceLimits(now time.Time) {
+	for len(q.segments) > 0 && q.maxAge > 0 && now.Sub(q.segments[0].created) > q.maxAge {
+		q.drop(dropReasonAge)
+	}
+	for len(q.segments) > 0 && q.maxBytes > 0 && q.size > q.maxBytes {
+		q.drop(dropReasonSize)
+	}
+	q.updateMetrics()
+}
+
+// drop removes the oldest segment and accounts for it as dropped data.
+// Must be called with the lock held.
+func (q *Queue) drop(reason string) {
+	s := q.segments[0]
+	rlogger.Log(q.logger, rlogger.Warn, "msg", "dropping queued metrics", "seq", s.seq, "bytes", s.size, "reason", reason)
+	if q.metrics != nil {
+		q.metrics.DroppedBytes.WithLabelValues(reason).Add(float64(s.size))
+	}
+	q.remove()
+}
+
+// remove deletes the oldest segment. Must be called with the lock held.
+func (q *Queue) remove() {
+	q.removeSegment(q.segments[0].seq)
+}
+
+// removeSegment deletes the segment seq, if the limits did not drop it already.
+// Must be called with the lock held.
+func (q *Queue) removeSegment(seq uint64) {
+	for i, s := range q.segments {
+		if s.seq != seq {
+			continue
+		}
+		if err := os.Remove(q.segmentPath(s.seq)); err != nil && !os.IsNotExist(err) {
+			rlogger.Log(q.logger, rlogger.Warn, "msg", "failed to remove queue segment", "seq", s.seq, "err", err)
+		}
+		q.segments = append(q.segments[:i], q.segments[i+1:]...)
+		q.size -= s.size
+		q.updateMetrics()
+		return
+	}
+}
+
+func (q *Queue) updateMetrics() {
+	if q.metrics == nil {
+		return
+	}
+	q.metrics.Segments.Set(float64(len(q.segments)))
+	q.metrics.Bytes.Set(float64(q.size))
+}
+
+func (q *Queue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
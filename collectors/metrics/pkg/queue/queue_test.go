@@ -0,0 +1,183 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func newTestMetrics() *Metrics {
+	return &Metrics{
+		Segments:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "segments"}),
+		Bytes:        prometheus.NewGauge(prometheus.GaugeOpts{Name: "bytes"}),
+		DroppedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped_bytes"}, []string{"reason"}),
+	}
+}
+
+func writeRequest(name string) *prompb.WriteRequest {
+	return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}}
+}
+
+func replayNames(t *testing.T, q *Queue) []string {
+	var names []string
+	err := q.Replay(func(wreq *prompb.WriteRequest) error {
+		names = append(names, wreq.Timeseries[0].Labels[0].Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	return names
+}
+
+func TestQueueReplayOrderAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	q, err := New(Config{Dir: dir, Logger: log.NewNopLogger(), Metrics: newTestMetrics()})
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		if err := q.Store(writeRequest(name)); err != nil {
+			t.Fatalf("failed to store request: %v", err)
+		}
+	}
+
+	// A failed send keeps the request queued.
+	sendErr := errors.New("unavailable")
+	if err := q.Replay(func(*prompb.WriteRequest) error { return sendErr }); err != sendErr {
+		t.Fatalf("expected replay to return the send error, got %v", err)
+	}
+	if q.Len() != 3 {
+		t.Fatalf("expected 3 queued requests, got %d", q.Len())
+	}
+
+	// Reopen the queue as a restarted collector would.
+	metrics := newTestMetrics()
+	q, err = New(Config{Dir: dir, Logger: log.NewNopLogger(), Metrics: metrics})
+	if err != nil {
+		t.Fatalf("failed to reopen queue: %v", err)
+	}
+	if v := testutil.ToFloat64(metrics.Segments); v != 3 {
+		t.Errorf("expected segments gauge to be 3, got %v", v)
+	}
+	if err := q.Store(writeRequest("fourth")); err != nil {
+		t.Fatalf("failed to store request: %v", err)
+	}
+
+	names := replayNames(t, q)
+	want := []string{"first", "second", "third", "fourth"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, names)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("expected an empty queue after replay, got %d", q.Len())
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	dir := t.TempDir()
+	metrics := newTestMetrics()
+	q, err := New(Config{Dir: dir, Logger: log.NewNopLogger(), Metrics: metrics})
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	if err := q.Store(writeRequest("old")); err != nil {
+		t.Fatalf("failed to store request: %v", err)
+	}
+	segmentSize := q.size
+
+	// Exceeding the size limit drops the oldest request.
+	q.maxBytes = segmentSize
+	if err := q.Store(writeRequest("new")); err != nil {
+		t.Fatalf("failed to store request: %v", err)
+	}
+	if names := replayNames(t, q); len(names) != 1 || names[0] != "new" {
+		t.Errorf("expected only the newest request to be kept, got %v", names)
+	}
+	if v := testutil.ToFloat64(metrics.DroppedBytes.WithLabelValues(dropReasonSize)); v != float64(segmentSize) {
+		t.Errorf("expected %d dropped bytes, got %v", segmentSize, v)
+	}
+
+	// Requests older than the age limit are dropped on replay.
+	q.maxBytes = 0
+	q.maxAge = time.Minute
+	if err := q.Store(writeRequest("expired")); err != nil {
+		t.Fatalf("failed to store request: %v", err)
+	}
+	q.segments[0].created = time.Now().Add(-time.Hour)
+	if names := replayNames(t, q); len(names) != 0 {
+		t.Errorf("expected expired request to be dropped, got %v", names)
+	}
+
+	// Corrupted segments are dropped instead of blocking the queue.
+	if err := q.Store(writeRequest("corrupt")); err != nil {
+		t.Fatalf("failed to store request: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000003.seg"), []byte("garbage"), 0600); err != nil {
+		t.Fatalf("failed to corrupt segment: %v", err)
+	}
+	if names := replayNames(t, q); len(names) != 0 {
+		t.Errorf("expected corrupted request to be dropped, got %v", names)
+	}
+}
+
+func TestQueueStoreDuringReplay(t *testing.T) {
+	dir := t.TempDir()
+	// A write interrupted by a crash leaves an uncommitted segment behind.
+	tmp := filepath.Join(dir, "00000000000000000007.seg.tmp")
+	if err := os.WriteFile(tmp, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	q, err := New(Config{Dir: dir, Logger: log.NewNopLogger(), Metrics: newTestMetrics()})
+	if err != nil {
+		t.Fatalf("failed to create queue: %v", err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("expected the uncommitted segment to be removed, got %v", err)
+	}
+	if err := q.Store(writeRequest("first")); err != nil {
+		t.Fatalf("failed to store request: %v", err)
+	}
+
+	// The queue is not locked while a request is sent.
+	var names []string
+	err = q.Replay(func(wreq *prompb.WriteRequest) error {
+		names = append(names, wreq.Timeseries[0].Labels[0].Value)
+		if len(names) == 1 {
+			stored := make(chan error)
+			go func() { stored <- q.Store(writeRequest("second")) }()
+			select {
+			case err := <-stored:
+				return err
+			case <-time.After(5 * time.Second):
+				t.Fatal("store blocked by the replay")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if len(names) != 2 || names[0] != "first" || names[1] != "second" || q.Len() != 0 {
+		t.Errorf("expected both requests to be replayed in order, got %v with %d queued", names, q.Len())
+	}
+}
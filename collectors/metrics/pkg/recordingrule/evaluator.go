@@ -0,0 +1,208 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+// Package recordingrule evaluates the recording rules of the collector over the federated
+// series with the PromQL engine, instead of sending every rule as a query to Prometheus.
+package recordingrule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// maxSamples bounds the number of samples a rule can load, as --query.max-samples does.
+const maxSamples = 50000000
+
+// Rule is a recording rule, in the JSON format of the --recordingrule flag.
+type Rule struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// ParseRules parses recording rules in the JSON format of the --recordingrule flag and
+// checks that their queries are valid PromQL expressions.
+func ParseRules(rules []string) ([]Rule, error) {
+	parsed := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		var r Rule
+		if err := json.Unmarshal([]byte(rule), &r); err != nil {
+			return nil, fmt.Errorf("invalid recording rule %s: %w", rule, err)
+		}
+		if len(r.Name) == 0 {
+			return nil, fmt.Errorf("a name is required for recording rule %s", rule)
+		}
+		if _, err := parser.ParseExpr(r.Query); err != nil {
+			return nil, fmt.Errorf("invalid query of recording rule %s: %w", r.Name, err)
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// Evaluator evaluates recording rules over the series of a federation. The rules only see
+// the series that are federated, and a single sample of each: aggregations such as
+// `sum by (namespace)` are supported, range functions such as `rate` return no result.
+type Evaluator struct {
+	engine *promql.Engine
+	rules  []Rule
+	// selectors are the series selectors of the rules, nil selects every family.
+	selectors [][]*labels.Matcher
+}
+
+// NewEvaluator returns an Evaluator of rules. A rule is aborted once it runs for timeout.
+func NewEvaluator(rules []Rule, timeout time.Duration, logger log.Logger) *Evaluator {
+	return &Evaluator{
+		engine: promql.NewEngine(promql.EngineOpts{
+			Logger:     log.With(logger, "component", "recordingrule/engine"),
+			MaxSamples: maxSamples,
+			Timeout:    timeout,
+			NoStepSubqueryIntervalFn: func(int64) int64 {
+				return time.Minute.Milliseconds()
+			},
+		}),
+		rules:     append([]Rule(nil), rules...),
+		selectors: ruleSelectors(rules),
+	}
+}
+
+// ruleSelectors returns the matchers of the series selectors of rules, or nil when a rule
+// cannot be parsed.
+func ruleSelectors(rules []Rule) [][]*labels.Matcher {
+	var selectors [][]*labels.Matcher
+	for _, r := range rules {
+		expr, err := parser.ParseExpr(r.Query)
+		if err != nil {
+			return nil
+		}
+		parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+			if vs, ok := node.(*parser.VectorSelector); ok {
+				selectors = append(selectors, vs.LabelMatchers)
+			}
+			return nil
+		})
+	}
+	return selectors
+}
+
+// Selects returns whether the rules may select series of family, by their metric names. The
+// series of histograms and summaries are named with their suffixes.
+func (e *Evaluator) Selects(family *clientmodel.MetricFamily) bool {
+	if e.selectors == nil {
+		return true
+	}
+	names := []string{family.GetName()}
+	switch family.GetType() {
+	case clientmodel.MetricType_HISTOGRAM, clientmodel.MetricType_GAUGE_HISTOGRAM:
+		names = append(names, family.GetName()+"_bucket", family.GetName()+"_sum", family.GetName()+"_count")
+	case clientmodel.MetricType_SUMMARY:
+		names = append(names, family.GetName()+"_sum", family.GetName()+"_count")
+	}
+	for _, matchers := range e.selectors {
+		for _, name := range names {
+			if matchesName(matchers, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesName(matchers []*labels.Matcher, name string) bool {
+	for _, m := range matchers {
+		if m.Name == labels.MetricName && !m.Matches(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate evaluates the rules at ts over timeseries. It returns a family named after each
+// rule that succeeded and the error of each rule that failed, by rule name, so that a
+// failing rule does not prevent the other ones from being recorded.
+func (e *Evaluator) Evaluate(ctx context.Context, timeseries []prompb.TimeSeries,
+	ts time.Time) ([]*clientmodel.MetricFamily, map[string]error) {
+	failed := map[string]error{}
+	if len(e.rules) == 0 {
+		return nil, failed
+	}
+
+	storage, err := newSeriesStorage(timeseries)
+	if err != nil {
+		for _, r := range e.rules {
+			failed[r.Name] = err
+		}
+		return nil, failed
+	}
+
+	var families []*clientmodel.MetricFamily
+	for _, r := range e.rules {
+		vector, err := e.query(ctx, storage, r.Query, ts)
+		if err != nil {
+			failed[r.Name] = err
+			continue
+		}
+		if len(vector) > 0 {
+			families = append(families, vectorFamily(r.Name, vector))
+		}
+	}
+	return families, failed
+}
+
+func (e *Evaluator) query(ctx context.Context, storage *seriesStorage, query string, ts time.Time) (promql.Vector, error) {
+	q, err := e.engine.NewInstantQuery(storage, &promql.QueryOpts{}, query, ts)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		return v, nil
+	case promql.Scalar:
+		return promql.Vector{{Point: promql.Point{T: v.T, V: v.V}}}, nil
+	default:
+		return nil, errors.New("rule result is not an instant vector or a scalar")
+	}
+}
+
+// vectorFamily returns the samples of vector as an untyped family named name, the way
+// the recording rules queried from Prometheus are recorded.
+func vectorFamily(name string, vector promql.Vector) *clientmodel.MetricFamily {
+	family := &clientmodel.MetricFamily{
+		Name: proto.String(name),
+		Type: clientmodel.MetricType_UNTYPED.Enum(),
+	}
+	for _, s := range vector {
+		m := &clientmodel.Metric{
+			Untyped:     &clientmodel.Untyped{Value: proto.Float64(s.V)},
+			TimestampMs: proto.Int64(s.T),
+		}
+		for _, l := range s.Metric {
+			if l.Name == labels.MetricName || l.Value == "" {
+				continue
+			}
+			m.Label = append(m.Label, &clientmodel.LabelPair{
+				Name:  proto.String(l.Name),
+				Value: proto.String(l.Value),
+			})
+		}
+		family.Metric = append(family.Metric, m)
+	}
+	return family
+}
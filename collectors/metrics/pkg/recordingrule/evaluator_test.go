@@ -0,0 +1,126 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package recordingrule
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testSeries(name, namespace string, value float64, timestamp int64) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: name},
+			{Name: "namespace", Value: namespace},
+		},
+		Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	ts := now.Add(-30 * time.Second).UnixMilli()
+	timeseries := []prompb.TimeSeries{
+		testSeries("kube_pod_info", "a", 1, ts),
+		testSeries("kube_pod_info", "a", 1, ts),
+		testSeries("kube_pod_info", "b", 1, ts),
+		testSeries("kube_pod_container_resource_requests", "a", 2, ts),
+		testSeries("kube_pod_container_resource_requests", "b", 3, ts),
+	}
+	timeseries[1].Labels = append(timeseries[1].Labels, prompb.Label{Name: "pod", Value: "api-1"})
+
+	rules, err := ParseRules([]string{
+		`{"name":"namespace:kube_pod_info:count","query":"count by (namespace) (kube_pod_info)"}`,
+		`{"name":"cluster:requests:sum","query":"sum(kube_pod_container_resource_requests)"}`,
+		`{"name":"broken","query":"kube_pod_info + on(namespace) kube_pod_info"}`,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	families, failed := NewEvaluator(rules, time.Minute, log.NewNopLogger()).Evaluate(context.Background(), timeseries, now)
+
+	// The many-to-many match fails, the other rules are still recorded.
+	if _, ok := failed["broken"]; !ok || len(failed) != 1 {
+		t.Errorf("expected only the broken rule to fail, got %v", failed)
+	}
+	got := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.Metric {
+			key := f.GetName()
+			for _, l := range m.Label {
+				key += "," + l.GetName() + "=" + l.GetValue()
+			}
+			got[key] = m.GetUntyped().GetValue()
+			if m.GetTimestampMs() != now.UnixMilli() {
+				t.Errorf("expected the evaluation timestamp, got %d", m.GetTimestampMs())
+			}
+		}
+	}
+	want := map[string]float64{
+		"namespace:kube_pod_info:count,namespace=a": 2,
+		"namespace:kube_pod_info:count,namespace=b": 1,
+		"cluster:requests:sum":                      5,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	for _, rule := range []string{
+		`{"name":"foo"`,
+		`{"query":"up"}`,
+		`{"name":"foo","query":"sum("}`,
+	} {
+		if _, err := ParseRules([]string{rule}); err == nil {
+			t.Errorf("expected rule %s to be rejected", rule)
+		}
+	}
+}
+
+func TestSelects(t *testing.T) {
+	rules, err := ParseRules([]string{
+		`{"name":"namespace:kube_pod_info:count","query":"count by (namespace) (kube_pod_info)"}`,
+		`{"name":"cluster:latency:sum","query":"sum(apiserver_latency_seconds_sum{verb=\"GET\"})"}`,
+		`{"name":"cluster:requests:sum","query":"sum({__name__=~\"kube_pod_container_.+\"})"}`,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	e := NewEvaluator(rules, time.Minute, log.NewNopLogger())
+	for _, tc := range []struct {
+		name     string
+		typ      clientmodel.MetricType
+		expected bool
+	}{
+		{name: "kube_pod_info", typ: clientmodel.MetricType_GAUGE, expected: true},
+		{name: "kube_pod_container_resource_requests", typ: clientmodel.MetricType_GAUGE, expected: true},
+		{name: "apiserver_latency_seconds", typ: clientmodel.MetricType_HISTOGRAM, expected: true},
+		{name: "apiserver_latency_seconds", typ: clientmodel.MetricType_GAUGE, expected: false},
+		{name: "up", typ: clientmodel.MetricType_GAUGE, expected: false},
+	} {
+		family := &clientmodel.MetricFamily{Name: proto.String(tc.name), Type: tc.typ.Enum()}
+		if got := e.Selects(family); got != tc.expected {
+			t.Errorf("expected %s of type %s to be selected: %t, got %t", tc.name, tc.typ, tc.expected, got)
+		}
+	}
+
+	// A selector without a metric name selects every family.
+	rules, err = ParseRules([]string{`{"name":"cluster:count","query":"count({namespace=\"a\"})"}`})
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	family := &clientmodel.MetricFamily{Name: proto.String("up"), Type: clientmodel.MetricType_GAUGE.Enum()}
+	if !NewEvaluator(rules, time.Minute, log.NewNopLogger()).Selects(family) {
+		t.Errorf("expected every family to be selected")
+	}
+}
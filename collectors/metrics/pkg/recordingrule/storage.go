@@ -0,0 +1,153 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package recordingrule
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// seriesStorage is a read-only storage.Queryable of the federated series. It holds the
+// samples of a single federation, so range selectors return at most one sample per series.
+type seriesStorage struct {
+	// series are sorted by labels, as Select returns them.
+	series []*series
+}
+
+type series struct {
+	lset  labels.Labels
+	chunk chunkenc.Chunk
+	// maxt is the timestamp of the last sample appended to chunk.
+	maxt int64
+}
+
+// newSeriesStorage returns a storage of the float samples of timeseries. The samples of
+// a series are kept in timestamp order, the samples that are out of order are dropped.
+func newSeriesStorage(timeseries []prompb.TimeSeries) (*seriesStorage, error) {
+	byHash := map[uint64]*series{}
+	s := &seriesStorage{}
+	for _, ts := range timeseries {
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		lset := make(labels.Labels, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+		}
+		sort.Sort(lset)
+
+		h := lset.Hash()
+		sr, ok := byHash[h]
+		if !ok {
+			sr = &series{lset: lset, chunk: chunkenc.NewXORChunk(), maxt: math.MinInt64}
+			byHash[h] = sr
+			s.series = append(s.series, sr)
+		}
+		app, err := sr.chunk.Appender()
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range ts.Samples {
+			if sample.Timestamp <= sr.maxt {
+				continue
+			}
+			app.Append(sample.Timestamp, sample.Value)
+			sr.maxt = sample.Timestamp
+		}
+	}
+	sort.Slice(s.series, func(i, j int) bool {
+		return labels.Compare(s.series[i].lset, s.series[j].lset) < 0
+	})
+	return s, nil
+}
+
+// Querier implements storage.Queryable. The samples are returned regardless of mint and maxt,
+// the engine ignores the ones out of the range it selects.
+func (s *seriesStorage) Querier(_ context.Context, _, _ int64) (storage.Querier, error) {
+	return s, nil
+}
+
+// Select implements storage.Querier.
+func (s *seriesStorage) Select(_ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	return &seriesSet{series: s.matching(matchers), cur: -1}
+}
+
+// LabelValues implements storage.Querier.
+func (s *seriesStorage) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	values := map[string]struct{}{}
+	for _, sr := range s.matching(matchers) {
+		if v := sr.lset.Get(name); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	return sortedKeys(values), nil, nil
+}
+
+// LabelNames implements storage.Querier.
+func (s *seriesStorage) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	names := map[string]struct{}{}
+	for _, sr := range s.matching(matchers) {
+		for _, l := range sr.lset {
+			names[l.Name] = struct{}{}
+		}
+	}
+	return sortedKeys(names), nil, nil
+}
+
+// Close implements storage.Querier.
+func (s *seriesStorage) Close() error {
+	return nil
+}
+
+// matching returns the series matching all the matchers, in label order.
+func (s *seriesStorage) matching(matchers []*labels.Matcher) []*series {
+	var matched []*series
+next:
+	for _, sr := range s.series {
+		for _, m := range matchers {
+			if !m.Matches(sr.lset.Get(m.Name)) {
+				continue next
+			}
+		}
+		matched = append(matched, sr)
+	}
+	return matched
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// seriesSet implements storage.SeriesSet over a slice of series.
+type seriesSet struct {
+	series []*series
+	cur    int
+}
+
+func (s *seriesSet) Next() bool {
+	s.cur++
+	return s.cur < len(s.series)
+}
+
+func (s *seriesSet) At() storage.Series         { return s.series[s.cur] }
+func (s *seriesSet) Err() error                 { return nil }
+func (s *seriesSet) Warnings() storage.Warnings { return nil }
+
+// Labels implements storage.Series.
+func (s *series) Labels() labels.Labels { return s.lset }
+
+// Iterator implements storage.Series.
+func (s *series) Iterator() chunkenc.Iterator { return s.chunk.Iterator(nil) }
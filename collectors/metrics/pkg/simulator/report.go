@@ -0,0 +1,103 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package simulator
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLatencies is the number of most recent write latencies the quantiles are computed from.
+const maxLatencies = 1000
+
+// Report measures the throughput and latency of the hub receive path while a scenario runs.
+// Report is thread safe.
+type Report struct {
+	lock     sync.Mutex
+	cycles   int
+	failures int
+	metrics  int64
+	// busy is the total time spent writing, the throughput is the metrics written per second of it.
+	busy time.Duration
+	// latencies is a ring of the most recent write latencies, next is the index of the oldest.
+	latencies []time.Duration
+	next      int
+}
+
+// ReportSummary is the state of a Report.
+type ReportSummary struct {
+	Cycles   int   `json:"cycles"`
+	Failures int   `json:"failures"`
+	Metrics  int64 `json:"metrics"`
+	// MetricsPerSecond is the number of metrics written per second spent writing.
+	MetricsPerSecond float64 `json:"metricsPerSecond"`
+	// The latency quantiles of the most recent writes, in seconds.
+	LatencyP50 float64 `json:"latencyP50Seconds"`
+	LatencyP90 float64 `json:"latencyP90Seconds"`
+	LatencyP99 float64 `json:"latencyP99Seconds"`
+}
+
+// NewReport returns an empty Report.
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Record records a cycle in which metrics were written in d. A failed write counts as a
+// failure, its metrics are not counted as written.
+func (r *Report) Record(metrics int, d time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cycles++
+	if err != nil {
+		r.failures++
+	} else {
+		r.metrics += int64(metrics)
+	}
+	r.busy += d
+	if len(r.latencies) < maxLatencies {
+		r.latencies = append(r.latencies, d)
+		return
+	}
+	r.latencies[r.next] = d
+	r.next = (r.next + 1) % maxLatencies
+}
+
+// Summary returns the throughput and latencies recorded so far.
+func (r *Report) Summary() ReportSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s := ReportSummary{
+		Cycles:   r.cycles,
+		Failures: r.failures,
+		Metrics:  r.metrics,
+	}
+	if r.busy > 0 {
+		s.MetricsPerSecond = float64(r.metrics) / r.busy.Seconds()
+	}
+	if len(r.latencies) > 0 {
+		sorted := append([]time.Duration(nil), r.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.LatencyP50 = quantile(sorted, 0.5)
+		s.LatencyP90 = quantile(sorted, 0.9)
+		s.LatencyP99 = quantile(sorted, 0.99)
+	}
+	return s
+}
+
+// quantile returns the q quantile of sorted in seconds, with the nearest rank method.
+func quantile(sorted []time.Duration, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Seconds()
+}
@@ -0,0 +1,289 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	clientmodel "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	gaugeType     = "gauge"
+	counterType   = "counter"
+	histogramType = "histogram"
+
+	// seriesLabel tells the series of a simulated metric apart.
+	seriesLabel = "series"
+)
+
+// Scenario describes how the metrics of a simulated cluster evolve over time, for capacity
+// planning of the hub receive path. It is read from --simulated-scenario-file, in YAML or JSON.
+type Scenario struct {
+	// Seed makes the generated values reproducible, a random seed is used if unset.
+	Seed    int64            `json:"seed,omitempty"`
+	Metrics []MetricScenario `json:"metrics"`
+}
+
+// MetricScenario describes the series of a simulated metric.
+type MetricScenario struct {
+	Name string `json:"name"`
+	// Type is gauge, counter or histogram, gauge by default.
+	Type string `json:"type,omitempty"`
+	// Series is the number of series in the first cycle, SeriesGrowth are added every cycle.
+	Series       int `json:"series"`
+	SeriesGrowth int `json:"seriesGrowth,omitempty"`
+	// Churn is the fraction of the series replaced by new series every cycle, between 0 and 1.
+	Churn float64 `json:"churn,omitempty"`
+	// Rate is the average increase per second of a counter, or the number of observations per
+	// second of a histogram. It defaults to 1.
+	Rate float64 `json:"rate,omitempty"`
+	// Buckets are the upper bounds of the buckets of a histogram.
+	Buckets []float64       `json:"buckets,omitempty"`
+	Labels  []LabelScenario `json:"labels,omitempty"`
+}
+
+// LabelScenario describes a label of a simulated metric. Its values are spread over the
+// series, so that Values distinct values are used in the first cycle and Growth more
+// every cycle, as long as there are enough series.
+type LabelScenario struct {
+	Name   string `json:"name"`
+	Values int    `json:"values"`
+	Growth int    `json:"growth,omitempty"`
+}
+
+// LoadScenario reads and validates a scenario file.
+func LoadScenario(scenarioFile string) (*Scenario, error) {
+	data, err := os.ReadFile(filepath.Clean(scenarioFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read simulated-scenario-file: %w", err)
+	}
+	s := &Scenario{}
+	if err := yaml.UnmarshalStrict(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse simulated-scenario-file %s: %w", scenarioFile, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid simulated-scenario-file %s: %w", scenarioFile, err)
+	}
+	return s, nil
+}
+
+func (s *Scenario) validate() error {
+	if len(s.Metrics) == 0 {
+		return errors.New("at least one metric is required")
+	}
+	names := map[string]bool{}
+	for _, m := range s.Metrics {
+		if len(m.Name) == 0 {
+			return errors.New("a metric name is required")
+		}
+		if names[m.Name] {
+			return fmt.Errorf("duplicate metric %s", m.Name)
+		}
+		names[m.Name] = true
+		switch m.Type {
+		case "", gaugeType, counterType:
+		case histogramType:
+			if len(m.Buckets) == 0 || !sort.Float64sAreSorted(m.Buckets) {
+				return fmt.Errorf("sorted buckets are required for histogram %s", m.Name)
+			}
+		default:
+			return fmt.Errorf("unknown type %s of metric %s", m.Type, m.Name)
+		}
+		if m.Series <= 0 || m.SeriesGrowth < 0 {
+			return fmt.Errorf("metric %s must have a positive number of series", m.Name)
+		}
+		if m.Churn < 0 || m.Churn > 1 {
+			return fmt.Errorf("churn of metric %s must be between 0 and 1", m.Name)
+		}
+		for _, l := range m.Labels {
+			if len(l.Name) == 0 || l.Name == seriesLabel {
+				return fmt.Errorf("invalid label name %q of metric %s", l.Name, m.Name)
+			}
+			if l.Values <= 0 || l.Growth < 0 {
+				return fmt.Errorf("label %s of metric %s must have a positive number of values", l.Name, m.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Generator generates the families of a scenario, one cycle at a time. The counters and
+// histograms of a series keep increasing from one cycle to the next, until the series is
+// replaced because of churn.
+type Generator struct {
+	scenario Scenario
+	rand     *rand.Rand
+	cycle    int
+	last     time.Time
+	// first is the id of the oldest series of each metric, churn moves it forward.
+	first []int
+	// series hold the state of the series of each metric, by series id.
+	series []map[int]*seriesState
+}
+
+// seriesState is a simulated series. Its labels are chosen when it appears, so that
+// the growth of the label values does not change the existing series.
+type seriesState struct {
+	labels []*clientmodel.LabelPair
+	// counts are the value of a counter, or the cumulative bucket counts of a histogram
+	// followed by its sum and count.
+	counts []float64
+}
+
+// NewGenerator returns a Generator of the scenario.
+func NewGenerator(s *Scenario) *Generator {
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	g := &Generator{
+		scenario: *s,
+		// #nosec G404 -- The simulated values do not need a secure random generator.
+		rand:   rand.New(rand.NewSource(seed)),
+		first:  make([]int, len(s.Metrics)),
+		series: make([]map[int]*seriesState, len(s.Metrics)),
+	}
+	for i := range g.series {
+		g.series[i] = map[int]*seriesState{}
+	}
+	return g
+}
+
+// Next returns the families of the next cycle, with samples at now.
+func (g *Generator) Next(now time.Time) []*clientmodel.MetricFamily {
+	elapsed := now.Sub(g.last).Seconds()
+	if g.last.IsZero() || elapsed < 0 {
+		elapsed = 0
+	}
+	timestamp := now.UnixMilli()
+
+	families := make([]*clientmodel.MetricFamily, 0, len(g.scenario.Metrics))
+	for i, m := range g.scenario.Metrics {
+		count := m.Series + m.SeriesGrowth*g.cycle
+		if g.cycle > 0 {
+			g.first[i] += int(math.Round(float64(count) * m.Churn))
+		}
+		// Forget the series replaced by churn.
+		for id := range g.series[i] {
+			if id < g.first[i] {
+				delete(g.series[i], id)
+			}
+		}
+
+		family := &clientmodel.MetricFamily{Name: proto.String(m.Name), Type: metricType(m.Type)}
+		for id := g.first[i]; id < g.first[i]+count; id++ {
+			s, ok := g.series[i][id]
+			if !ok {
+				s = g.newSeries(m, id)
+				g.series[i][id] = s
+			}
+			// The transformers modify the labels of the families, they are not shared.
+			labels := make([]*clientmodel.LabelPair, 0, len(s.labels))
+			for _, l := range s.labels {
+				labels = append(labels, &clientmodel.LabelPair{Name: proto.String(l.GetName()), Value: proto.String(l.GetValue())})
+			}
+			metric := &clientmodel.Metric{
+				Label:       labels,
+				TimestampMs: proto.Int64(timestamp),
+			}
+			switch m.Type {
+			case counterType:
+				s.counts[0] += 2 * g.rand.Float64() * rate(m) * elapsed
+				metric.Counter = &clientmodel.Counter{Value: proto.Float64(s.counts[0])}
+			case histogramType:
+				metric.Histogram = g.observe(s, m, elapsed)
+			default:
+				metric.Gauge = &clientmodel.Gauge{Value: proto.Float64(g.rand.Float64())}
+			}
+			family.Metric = append(family.Metric, metric)
+		}
+		families = append(families, family)
+	}
+
+	g.cycle++
+	g.last = now
+	return families
+}
+
+// newSeries returns the series id of m. Its label values are picked among the values
+// of the current cycle.
+func (g *Generator) newSeries(m MetricScenario, id int) *seriesState {
+	labels := []*clientmodel.LabelPair{{
+		Name:  proto.String(seriesLabel),
+		Value: proto.String(fmt.Sprint(id)),
+	}}
+	for _, l := range m.Labels {
+		values := l.Values + l.Growth*g.cycle
+		labels = append(labels, &clientmodel.LabelPair{
+			Name:  proto.String(l.Name),
+			Value: proto.String(fmt.Sprintf("%s-%d", l.Name, id%values)),
+		})
+	}
+
+	counts := 1
+	if m.Type == histogramType {
+		counts = len(m.Buckets) + 2
+	}
+	return &seriesState{labels: labels, counts: make([]float64, counts)}
+}
+
+// observe adds about m.Rate observations per second to the histogram of s.
+func (g *Generator) observe(s *seriesState, m MetricScenario, elapsed float64) *clientmodel.Histogram {
+	c := s.counts
+	sum, count := len(m.Buckets), len(m.Buckets)+1
+	highest := m.Buckets[len(m.Buckets)-1]
+	observations := int(math.Round(2 * g.rand.Float64() * rate(m) * elapsed))
+	for n := 0; n < observations; n++ {
+		// Some observations are above the last bucket.
+		v := g.rand.Float64() * highest * 1.1
+		for b, upper := range m.Buckets {
+			if v <= upper {
+				c[b]++
+			}
+		}
+		c[sum] += v
+		c[count]++
+	}
+
+	h := &clientmodel.Histogram{
+		SampleCount: proto.Uint64(uint64(c[count])),
+		SampleSum:   proto.Float64(c[sum]),
+	}
+	for b, upper := range m.Buckets {
+		h.Bucket = append(h.Bucket, &clientmodel.Bucket{
+			UpperBound:      proto.Float64(upper),
+			CumulativeCount: proto.Uint64(uint64(c[b])),
+		})
+	}
+	return h
+}
+
+func rate(m MetricScenario) float64 {
+	if m.Rate > 0 {
+		return m.Rate
+	}
+	return 1
+}
+
+func metricType(t string) *clientmodel.MetricType {
+	switch t {
+	case counterType:
+		return clientmodel.MetricType_COUNTER.Enum()
+	case histogramType:
+		return clientmodel.MetricType_HISTOGRAM.Enum()
+	default:
+		return clientmodel.MetricType_GAUGE.Enum()
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package simulator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_model/go"
+)
+
+func TestLoadScenario(t *testing.T) {
+	s, err := LoadScenario("../../testdata/scenario.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Metrics) != 3 {
+		t.Errorf("expected 3 metrics, got %d", len(s.Metrics))
+	}
+
+	invalid := filepath.Join(t.TempDir(), "scenario.yaml")
+	for _, data := range []string{
+		"metrics: []",
+		"metrics: [{name: foo}]",
+		"metrics: [{name: foo, series: 1, type: summary}]",
+		"metrics: [{name: foo, series: 1, type: histogram}]",
+		"metrics: [{name: foo, series: 1, churn: 2}]",
+		"metrics: [{name: foo, series: 1, labels: [{name: series, values: 1}]}]",
+		"metrics: [{name: foo, series: 1, unknown: 1}]",
+	} {
+		if err := os.WriteFile(invalid, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadScenario(invalid); err == nil {
+			t.Errorf("expected scenario %q to be rejected", data)
+		}
+	}
+}
+
+func seriesIDs(f *clientmodel.MetricFamily) map[string]*clientmodel.Metric {
+	ids := map[string]*clientmodel.Metric{}
+	for _, m := range f.Metric {
+		for _, l := range m.Label {
+			if l.GetName() == seriesLabel {
+				ids[l.GetValue()] = m
+			}
+		}
+	}
+	return ids
+}
+
+func TestGenerator(t *testing.T) {
+	g := NewGenerator(&Scenario{
+		Seed: 1,
+		Metrics: []MetricScenario{
+			{Name: "requests_total", Type: counterType, Series: 10, SeriesGrowth: 2, Churn: 0.2, Rate: 10,
+				Labels: []LabelScenario{{Name: "namespace", Values: 2, Growth: 1}}},
+			{Name: "duration_seconds", Type: histogramType, Series: 1, Rate: 10, Buckets: []float64{0.1, 1}},
+		},
+	})
+
+	now := time.Now()
+	first := g.Next(now)
+	second := g.Next(now.Add(time.Minute))
+
+	// The series grow from 10 to 12, 20% of them are replaced.
+	before, after := seriesIDs(first[0]), seriesIDs(second[0])
+	if len(before) != 10 || len(after) != 12 {
+		t.Fatalf("expected 10 then 12 series, got %d and %d", len(before), len(after))
+	}
+	kept := 0
+	for id, m := range after {
+		prev, ok := before[id]
+		if !ok {
+			continue
+		}
+		kept++
+		if m.GetCounter().GetValue() < prev.GetCounter().GetValue() {
+			t.Errorf("counter of series %s decreased", id)
+		}
+		if len(m.Label) != len(prev.Label) || m.Label[1].GetValue() != prev.Label[1].GetValue() {
+			t.Errorf("labels of series %s changed", id)
+		}
+	}
+	if kept != 8 {
+		t.Errorf("expected 8 series to be kept, got %d", kept)
+	}
+
+	// The new series use the values added to the growing label.
+	namespaces := map[string]bool{}
+	for _, m := range second[0].Metric {
+		namespaces[m.Label[1].GetValue()] = true
+	}
+	if len(namespaces) != 3 {
+		t.Errorf("expected 3 namespaces, got %v", namespaces)
+	}
+
+	h := second[1].Metric[0].GetHistogram()
+	if h.GetSampleCount() == 0 || h.Bucket[0].GetCumulativeCount() > h.Bucket[1].GetCumulativeCount() ||
+		h.Bucket[1].GetCumulativeCount() > h.GetSampleCount() {
+		t.Errorf("unexpected histogram %v", h)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := NewReport()
+	for i := 1; i <= 10; i++ {
+		r.Record(100, time.Duration(i)*100*time.Millisecond, nil)
+	}
+	r.Record(100, time.Second, os.ErrDeadlineExceeded)
+
+	s := r.Summary()
+	if s.Cycles != 11 || s.Failures != 1 || s.Metrics != 1000 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	// 1000 metrics written in 6.5s.
+	if s.MetricsPerSecond < 153 || s.MetricsPerSecond > 154 {
+		t.Errorf("expected about 153.8 metrics per second, got %v", s.MetricsPerSecond)
+	}
+	if s.LatencyP50 != 0.6 || s.LatencyP99 != 1 {
+		t.Errorf("unexpected latencies %+v", s)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,11 +26,27 @@ import (
 )
 
 const (
+	// CollectRulesConditionType is the condition listing the collect rules that are firing.
+	// It is kept apart from the Available and Degraded conditions of the collector.
+	CollectRulesConditionType = "CollectRulesFiring"
+
 	name       = "observability-addon"
 	namespace  = "open-cluster-management-addon-observability"
 	uwlPromURL = "https://prometheus-user-workload.openshift-user-workload-monitoring.svc:9092"
+
+	// PlatformSource and UserWorkloadSource name the platform and user workload Prometheus.
+	PlatformSource     = "platform"
+	UserWorkloadSource = "user-workload"
 )
 
+// Source is the state of a Prometheus instance the collector federates from.
+type Source struct {
+	Name string
+	// Type is Available or Degraded.
+	Type    string
+	Message string
+}
+
 type StatusReport struct {
 	statusClient client.Client
 	logger       log.Logger
@@ -64,14 +81,26 @@ func New(logger log.Logger) (*StatusReport, error) {
 	}, nil
 }
 
+// SourceName returns the name of the source federated from the URL from.
+func SourceName(from string) string {
+	if strings.Contains(from, uwlPromURL) {
+		return UserWorkloadSource
+	}
+	return PlatformSource
+}
+
+// UpdateStatus reports the state of the collector as the state of its only source, named
+// after the Prometheus it federates from.
 func (s *StatusReport) UpdateStatus(t string, m string) error {
+	return s.UpdateSources([]Source{{Name: SourceName(os.Getenv("FROM")), Type: t, Message: m}})
+}
+
+// UpdateSources reports the state of the given sources. The sources reported by other
+// collectors are kept, and the Available or Degraded condition summarizes all of them.
+func (s *StatusReport) UpdateSources(sources []Source) error {
 	if s.statusClient == nil {
 		return nil
 	}
-	isUwl := false
-	if strings.Contains(os.Getenv("FROM"), uwlPromURL) {
-		isUwl = true
-	}
 	addon := &oav1beta1.ObservabilityAddon{}
 	err := s.statusClient.Get(context.TODO(), types.NamespacedName{
 		Name:      name,
@@ -81,12 +110,92 @@ func (s *StatusReport) UpdateStatus(t string, m string) error {
 		logger.Log(s.logger, logger.Error, "err", err)
 		return err
 	}
+
+	update := false
+	for _, src := range sources {
+		if setSource(addon, src) {
+			update = true
+		}
+	}
+	message, conditionType, reason := summarize(addon.Status.MetricsSources)
+	if setCondition(addon, conditionType, reason, message) {
+		update = true
+	}
+	if !update {
+		return nil
+	}
+	err = s.statusClient.Status().Update(context.TODO(), addon)
+	if err != nil {
+		logger.Log(s.logger, logger.Error, "err", err)
+	}
+	return err
+}
+
+// setSource sets the state of a source in the status of the addon. It returns true
+// when the state changed.
+func setSource(addon *oav1beta1.ObservabilityAddon, src Source) bool {
+	t := "Available"
+	if src.Type == "Degraded" {
+		t = "Degraded"
+	}
+	status := oav1beta1.MetricsSourceStatus{
+		Name:               src.Name,
+		Type:               t,
+		Reason:             t,
+		Message:            src.Message,
+		LastTransitionTime: metav1.NewTime(time.Now()),
+	}
+	for i, c := range addon.Status.MetricsSources {
+		if c.Name != src.Name {
+			continue
+		}
+		if c.Type == status.Type && c.Message == status.Message {
+			return false
+		}
+		addon.Status.MetricsSources[i] = status
+		return true
+	}
+	addon.Status.MetricsSources = append(addon.Status.MetricsSources, status)
+	sort.Slice(addon.Status.MetricsSources, func(i, j int) bool {
+		return addon.Status.MetricsSources[i].Name < addon.Status.MetricsSources[j].Name
+	})
+	return true
+}
+
+// summarize returns the message, type and reason of the condition that summarizes the
+// state of the sources: Degraded when any source is degraded, Available otherwise.
+func summarize(sources []oav1beta1.MetricsSourceStatus) (string, string, string) {
+	conditionType := "Available"
+	for _, src := range sources {
+		if src.Type == "Degraded" {
+			conditionType = "Degraded"
+		}
+	}
+	if len(sources) == 1 {
+		return sources[0].Message, conditionType, conditionType
+	}
+	var messages []string
+	for _, src := range sources {
+		if conditionType == "Degraded" && src.Type != "Degraded" {
+			continue
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", src.Name, src.Message))
+	}
+	return strings.Join(messages, "; "), conditionType, conditionType
+}
+
+// setCondition makes conditionType the only true condition of the collector, besides the
+// collect rules condition. It returns true when the conditions changed.
+func setCondition(addon *oav1beta1.ObservabilityAddon, conditionType, reason, message string) bool {
 	update := false
 	found := false
 	conditions := []oav1beta1.StatusCondition{}
 	latestC := oav1beta1.StatusCondition{}
-	message, conditionType, reason := mergeCondtion(isUwl, m, addon.Status.Conditions[len(addon.Status.Conditions)-1])
 	for _, c := range addon.Status.Conditions {
+		if c.Type == CollectRulesConditionType {
+			conditions = append(conditions, c)
+			continue
+		}
 		if c.Status == metav1.ConditionTrue {
 			if c.Type != conditionType {
 				c.Status = metav1.ConditionFalse
@@ -128,36 +237,62 @@ func (s *StatusReport) UpdateStatus(t string, m string) error {
 		})
 		update = true
 	}
-	if update {
-		addon.Status.Conditions = conditions
-		err = s.statusClient.Status().Update(context.TODO(), addon)
-		if err != nil {
-			logger.Log(s.logger, logger.Error, "err", err)
-		}
+	addon.Status.Conditions = conditions
+	return update
+}
+
+// UpdateCollectRules reports the names of the collect rules that are firing in the
+// CollectRulesConditionType condition. The condition is only added once a rule fires.
+func (s *StatusReport) UpdateCollectRules(names []string) error {
+	if s.statusClient == nil {
+		return nil
+	}
+	addon := &oav1beta1.ObservabilityAddon{}
+	err := s.statusClient.Get(context.TODO(), types.NamespacedName{
+		Name:      name,
+		Namespace: namespace,
+	}, addon)
+	if err != nil {
+		logger.Log(s.logger, logger.Error, "err", err)
 		return err
 	}
-	return nil
-}
 
-func mergeCondtion(isUwl bool, m string, condition oav1beta1.StatusCondition) (string, string, string) {
-	messages := strings.Split(condition.Message, " ; ")
-	if len(messages) == 1 {
-		messages = append(messages, "")
+	condition := oav1beta1.StatusCondition{
+		Type:    CollectRulesConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  "NoCollectRuleFiring",
+		Message: "No collect rule is firing",
 	}
-	if isUwl {
-		messages[1] = fmt.Sprintf("User Workload: %s", m)
-	} else {
-		messages[0] = m
+	if len(names) > 0 {
+		names = append([]string(nil), names...)
+		sort.Strings(names)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "CollectRulesFiring"
+		condition.Message = fmt.Sprintf("Collect rules firing: %s", strings.Join(names, ", "))
+	}
+	condition.LastTransitionTime = metav1.NewTime(time.Now())
+
+	found := false
+	for i, c := range addon.Status.Conditions {
+		if c.Type != CollectRulesConditionType {
+			continue
+		}
+		found = true
+		if c.Status == condition.Status && c.Message == condition.Message {
+			return nil
+		}
+		addon.Status.Conditions[i] = condition
 	}
-	message := messages[0]
-	if messages[1] != "" {
-		message = strings.Join(messages, " ; ")
+	if !found {
+		if len(names) == 0 {
+			return nil
+		}
+		addon.Status.Conditions = append(addon.Status.Conditions, condition)
 	}
-	conditionType := "Available"
-	reason := "Available"
-	if strings.Contains(message, "Failed") {
-		conditionType = "Degraded"
-		reason = "Degraded"
+
+	err = s.statusClient.Status().Update(context.TODO(), addon)
+	if err != nil {
+		logger.Log(s.logger, logger.Error, "err", err)
 	}
-	return message, conditionType, reason
+	return err
 }
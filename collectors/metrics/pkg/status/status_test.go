@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-kit/log"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 
 	oav1beta1 "github.com/stolostron/multicluster-observability-operator/operators/multiclusterobservability/api/v1beta1"
@@ -92,3 +93,131 @@ func TestUpdateStatus(t *testing.T) {
 		t.Fatalf("Failed to update status: (%v)", err)
 	}
 }
+
+func TestUpdateCollectRules(t *testing.T) {
+	s, err := New(log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Failed to create new Status struct: (%v)", err)
+	}
+	addon := &oav1beta1.ObservabilityAddon{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Status: oav1beta1.ObservabilityAddonStatus{
+			Conditions: []oav1beta1.StatusCondition{
+				{
+					Type:               "Available",
+					Status:             metav1.ConditionTrue,
+					Reason:             "Available",
+					Message:            "Cluster metrics sent successfully",
+					LastTransitionTime: metav1.NewTime(time.Now()),
+				},
+			},
+		},
+	}
+	if err := s.statusClient.Create(context.TODO(), addon); err != nil {
+		t.Fatalf("Failed to create observabilityAddon: (%v)", err)
+	}
+
+	getCondition := func(conditionType string) *oav1beta1.StatusCondition {
+		found := &oav1beta1.ObservabilityAddon{}
+		if err := s.statusClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, found); err != nil {
+			t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+		}
+		for _, c := range found.Status.Conditions {
+			if c.Type == conditionType {
+				return &c
+			}
+		}
+		return nil
+	}
+
+	if err := s.UpdateCollectRules([]string{"SNOOverCPU", "HighMemory"}); err != nil {
+		t.Fatalf("Failed to update collect rules: (%v)", err)
+	}
+	c := getCondition(CollectRulesConditionType)
+	if c == nil || c.Status != metav1.ConditionTrue || c.Message != "Collect rules firing: HighMemory, SNOOverCPU" {
+		t.Errorf("unexpected collect rules condition %v", c)
+	}
+
+	// The collector conditions leave the collect rules condition untouched.
+	if err := s.UpdateStatus("Degraded", "Failed to send metrics"); err != nil {
+		t.Fatalf("Failed to update status: (%v)", err)
+	}
+	if c := getCondition(CollectRulesConditionType); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("expected the collect rules condition to be kept, got %v", c)
+	}
+	if c := getCondition("Degraded"); c == nil || c.Message != "Failed to send metrics" {
+		t.Errorf("unexpected degraded condition %v", c)
+	}
+
+	if err := s.UpdateCollectRules(nil); err != nil {
+		t.Fatalf("Failed to update collect rules: (%v)", err)
+	}
+	if c := getCondition(CollectRulesConditionType); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("expected the collect rules condition to be false, got %v", c)
+	}
+}
+
+func TestUpdateSources(t *testing.T) {
+	s, err := New(log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Failed to create new Status struct: (%v)", err)
+	}
+	addon := &oav1beta1.ObservabilityAddon{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	if err := s.statusClient.Create(context.TODO(), addon); err != nil {
+		t.Fatalf("Failed to create observabilityAddon: (%v)", err)
+	}
+
+	getStatus := func() oav1beta1.ObservabilityAddonStatus {
+		found := &oav1beta1.ObservabilityAddon{}
+		if err := s.statusClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, found); err != nil {
+			t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+		}
+		return found.Status
+	}
+	trueCondition := func(st oav1beta1.ObservabilityAddonStatus) *oav1beta1.StatusCondition {
+		for _, c := range st.Conditions {
+			if c.Status == metav1.ConditionTrue {
+				return &c
+			}
+		}
+		return nil
+	}
+
+	err = s.UpdateSources([]Source{
+		{Name: UserWorkloadSource, Type: "Degraded", Message: "Failed to retrieve metrics"},
+		{Name: PlatformSource, Type: "Available", Message: "Cluster metrics sent successfully"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update sources: (%v)", err)
+	}
+	st := getStatus()
+	if len(st.MetricsSources) != 2 || st.MetricsSources[0].Name != PlatformSource || st.MetricsSources[1].Type != "Degraded" {
+		t.Errorf("unexpected sources %v", st.MetricsSources)
+	}
+	if c := trueCondition(st); c == nil || c.Type != "Degraded" || c.Message != "user-workload: Failed to retrieve metrics" {
+		t.Errorf("unexpected condition %v", c)
+	}
+
+	// A source reported alone leaves the other sources untouched.
+	os.Setenv("FROM", uwlPromURL)
+	defer os.Setenv("FROM", "")
+	if err := s.UpdateStatus("Available", "Cluster metrics sent successfully"); err != nil {
+		t.Fatalf("Failed to update status: (%v)", err)
+	}
+	st = getStatus()
+	if len(st.MetricsSources) != 2 {
+		t.Errorf("expected 2 sources, got %v", st.MetricsSources)
+	}
+	want := "platform: Cluster metrics sent successfully; user-workload: Cluster metrics sent successfully"
+	if c := trueCondition(st); c == nil || c.Type != "Available" || c.Message != want {
+		t.Errorf("unexpected condition %v", c)
+	}
+}
@@ -6,6 +6,7 @@ package observabilityendpoint
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -179,6 +180,14 @@ func getCommands(params CollectorParams) []string {
 			fmt.Sprintf("--recordingrule={\"name\":\"%s\",\"query\":\"%s\"}", rule.Record, rule.Expr),
 		)
 	}
+	for _, relabelConfig := range params.allowlist.RelabelConfigList {
+		data, err := json.Marshal(relabelConfig)
+		if err != nil {
+			log.Error(err, "Failed to marshal relabel config", "config", relabelConfig)
+			continue
+		}
+		commands = append(commands, "--relabel-config="+string(data))
+	}
 	return commands
 }
 
@@ -28,6 +28,22 @@ type ObservabilityAddonStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Conditions []StatusCondition `json:"conditions"`
+	// MetricsSources is the state of every Prometheus instance the metrics collector
+	// federates from. The Available and Degraded conditions summarize it.
+	// +optional
+	MetricsSources []MetricsSourceStatus `json:"metricsSources,omitempty"`
+}
+
+// MetricsSourceStatus contains the state of a Prometheus instance the metrics collector
+// federates from
+type MetricsSourceStatus struct {
+	// Name of the source, such as platform or user-workload.
+	Name string `json:"name"`
+	// Type is Available when the metrics of the source are sent, Degraded otherwise.
+	Type               string      `json:"type"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	Reason             string      `json:"reason"`
+	Message            string      `json:"message"`
 }
 
 // +kubebuilder:object:root=true
@@ -27,6 +27,22 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSourceStatus) DeepCopyInto(out *MetricsSourceStatus) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSourceStatus.
+func (in *MetricsSourceStatus) DeepCopy() *MetricsSourceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSourceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MultiClusterObservability) DeepCopyInto(out *MultiClusterObservability) {
 	*out = *in
@@ -216,6 +232,13 @@ func (in *ObservabilityAddonStatus) DeepCopyInto(out *ObservabilityAddonStatus)
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MetricsSources != nil {
+		in, out := &in.MetricsSources, &out.MetricsSources
+		*out = make([]MetricsSourceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObservabilityAddonStatus.
@@ -26,6 +26,9 @@ var (
 		"Disabled":     "Degraded",
 		"Degraded":     "Degraded",
 		"NotSupported": "Degraded",
+		// The collect rules of the metrics collector are reported under their own condition,
+		// they do not change the availability of the addon.
+		"CollectRulesFiring": "CollectRulesFiring",
 	}
 )
 
@@ -36,8 +39,15 @@ func updateAddonStatus(c client.Client, addonList mcov1beta1.ObservabilityAddonL
 		}
 		conditions := []metav1.Condition{}
 		for _, c := range addon.Status.Conditions {
+			conditionType, ok := statusMap[c.Type]
+			if !ok {
+				// A condition without a type on the managedclusteraddon would be rejected.
+				log.Info("Skipping unknown observabilityaddon condition", "type", c.Type,
+					"namespace", addon.ObjectMeta.Namespace)
+				continue
+			}
 			condition := metav1.Condition{
-				Type:               statusMap[c.Type],
+				Type:               conditionType,
 				Status:             c.Status,
 				LastTransitionTime: c.LastTransitionTime,
 				Reason:             c.Reason,
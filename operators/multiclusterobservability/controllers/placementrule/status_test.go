@@ -66,4 +66,42 @@ func TestUpdateAddonStatus(t *testing.T) {
 	if maddon.Status.Conditions == nil || len(maddon.Status.Conditions) != 1 {
 		t.Fatalf("Status not updated correctly in managedclusteraddon: (%v)", maddon)
 	}
+
+	// The collect rules condition is reported alongside the availability, unknown conditions are skipped.
+	addonList.Items[0].Status.Conditions = append(addonList.Items[0].Status.Conditions,
+		mcov1beta1.StatusCondition{
+			Type:               "CollectRulesFiring",
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Reason:             "CollectRulesFiring",
+			Message:            "Collect rules firing: SNOHighCPUUsage",
+		},
+		mcov1beta1.StatusCondition{
+			Type:               "Unknown",
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Reason:             "Unknown",
+			Message:            "Unknown condition",
+		},
+	)
+	err = updateAddonStatus(c, *addonList)
+	if err != nil {
+		t.Fatalf("Failed to update status for managedclusteraddon: (%v)", err)
+	}
+	err = c.Get(context.TODO(), types.NamespacedName{
+		Name:      util.ManagedClusterAddonName,
+		Namespace: namespace,
+	}, maddon)
+	if err != nil {
+		t.Fatalf("Failed to get managedclusteraddon: (%v)", err)
+	}
+	if len(maddon.Status.Conditions) != 2 {
+		t.Fatalf("Expected the available and collect rules conditions in managedclusteraddon: (%v)",
+			maddon.Status.Conditions)
+	}
+	for i, conditionType := range []string{"Available", "CollectRulesFiring"} {
+		if maddon.Status.Conditions[i].Type != conditionType {
+			t.Errorf("Expected condition %d to be %s, got %s", i, conditionType, maddon.Status.Conditions[i].Type)
+		}
+	}
 }
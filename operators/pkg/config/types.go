@@ -48,6 +48,19 @@ type CollectRuleGroup struct {
 	Selector        CollectRuleSelector `yaml:"selector"`
 	CollectRuleList []CollectRule       `yaml:"rules"`
 }
+
+// RelabelConfig is a Prometheus relabel_config applied by the metrics collector
+// to every metric before it is sent.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty" json:"source_labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty" json:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty" json:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty" json:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty" json:"action,omitempty"`
+}
+
 type MetricsAllowlist struct {
 	NameList             []string           `yaml:"names"`
 	MatchList            []string           `yaml:"matches"`
@@ -55,4 +68,5 @@ type MetricsAllowlist struct {
 	RuleList             []RecordingRule    `yaml:"rules"` //deprecated
 	RecordingRuleList    []RecordingRule    `yaml:"recording_rules"`
 	CollectRuleGroupList []CollectRuleGroup `yaml:"collect_rules"`
+	RelabelConfigList    []RelabelConfig    `yaml:"relabel_configs"`
 }
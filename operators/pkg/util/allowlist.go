@@ -73,6 +73,7 @@ func MergeAllowlist(allowlist, customAllowlist, ocp3Allowlist, uwlAllowlist,
 	for k, v := range customAllowlist.RenameMap {
 		allowlist.RenameMap[k] = v
 	}
+	allowlist.RelabelConfigList = append(allowlist.RelabelConfigList, customAllowlist.RelabelConfigList...)
 	if ocp3Allowlist != nil {
 		ocp3Allowlist.NameList = mergeMetrics(ocp3Allowlist.NameList, customAllowlist.NameList)
 		ocp3Allowlist.MatchList = mergeMetrics(ocp3Allowlist.MatchList, customAllowlist.MatchList)
@@ -80,6 +81,7 @@ func MergeAllowlist(allowlist, customAllowlist, ocp3Allowlist, uwlAllowlist,
 		for k, v := range customAllowlist.RenameMap {
 			ocp3Allowlist.RenameMap[k] = v
 		}
+		ocp3Allowlist.RelabelConfigList = append(ocp3Allowlist.RelabelConfigList, customAllowlist.RelabelConfigList...)
 	}
 	uwlAllowlist.NameList = mergeMetrics(uwlAllowlist.NameList, customUwlAllowlist.NameList)
 	uwlAllowlist.MatchList = mergeMetrics(uwlAllowlist.MatchList, customUwlAllowlist.MatchList)
@@ -87,6 +89,7 @@ func MergeAllowlist(allowlist, customAllowlist, ocp3Allowlist, uwlAllowlist,
 	for k, v := range customUwlAllowlist.RenameMap {
 		uwlAllowlist.RenameMap[k] = v
 	}
+	uwlAllowlist.RelabelConfigList = append(uwlAllowlist.RelabelConfigList, customUwlAllowlist.RelabelConfigList...)
 
 	return allowlist, ocp3Allowlist, uwlAllowlist
 }
@@ -93,6 +93,10 @@ collect_rules:
           - c
         matches:
           - __name__="a"
+relabel_configs:
+  - source_labels: [namespace]
+    regex: kube-.*
+    action: drop
 `,
 			operatorconfig.UwlMetricsConfigMapKey: `
 names:
@@ -125,6 +129,9 @@ func TestMergeAllowList(t *testing.T) {
 	if !slices.Contains(uwlList.NameList, "custom_uwl_a") {
 		t.Error("metrics custom_uwl_a not merged into uwl allowlist")
 	}
+	if len(list.RelabelConfigList) != 1 || list.RelabelConfigList[0].Action != "drop" {
+		t.Errorf("relabel configs not merged into allowlist: %v", list.RelabelConfigList)
+	}
 }
 
 func TestMergeMetrics(t *testing.T) {
@@ -10,6 +10,9 @@ import (
 	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
@@ -23,13 +26,17 @@ import (
 )
 
 const (
-	defaultListenAddress = "0.0.0.0:3002"
+	defaultListenAddress        = "0.0.0.0:3002"
+	defaultMetricsListenAddress = "0.0.0.0:9002"
 )
 
 type proxyConf struct {
-	listenAddress      string
-	metricServer       string
-	kubeconfigLocation string
+	listenAddress        string
+	metricsListenAddress string
+	metricServer         string
+	kubeconfigLocation   string
+	tenancyMode          string
+	authorizer           string
 }
 
 func main() {
@@ -43,13 +50,20 @@ func main() {
 
 	flagset.StringVar(&cfg.listenAddress, "listen-address",
 		defaultListenAddress, "The address HTTP server should listen on.")
+	flagset.StringVar(&cfg.metricsListenAddress, "metrics-listen-address", defaultMetricsListenAddress,
+		"The address the metrics of the proxy are served on.")
 	flagset.StringVar(&cfg.metricServer, "metrics-server", "",
 		"The address the metrics server should run on.")
+	flagset.StringVar(&cfg.tenancyMode, "tenancy-mode", proxyconfig.ClusterTenancyMode,
+		"How the queries of the users are scoped: \"cluster\" grants the managed clusters whose namespace "+
+			"the user can access on the hub, \"namespace\" also grants the namespaces of the "+
+			proxyconfig.NamespaceTenancyConfigMapName+" configmap.")
+	flagset.StringVar(&cfg.authorizer, "authorizer", util.OpenShiftAuthorizer,
+		"How the users are authenticated and authorized: \"openshift\" reads the users and their projects "+
+			"from the OpenShift APIs, \"kubernetes\" uses token reviews and subject access reviews of the "+
+			"managed cluster namespaces.")
 
 	_ = flagset.Parse(os.Args[1:])
-	if err := os.Setenv("METRICS_SERVER", cfg.metricServer); err != nil {
-		klog.Fatalf("failed to Setenv: %v", err)
-	}
 
 	//Kubeconfig flag
 	flagset.StringVar(&cfg.kubeconfigLocation, "kubeconfig", "",
@@ -57,18 +71,38 @@ func main() {
 
 	klog.Infof("proxy server will running on: %s", cfg.listenAddress)
 	klog.Infof("metrics server is: %s", cfg.metricServer)
+	klog.Infof("proxy metrics will be served on: %s", cfg.metricsListenAddress)
 	klog.Infof("kubeconfig is: %s", cfg.kubeconfigLocation)
+	klog.Infof("tenancy mode is: %s", cfg.tenancyMode)
+	klog.Infof("authorizer is: %s", cfg.authorizer)
+
+	if err := util.SetTenancyMode(cfg.tenancyMode); err != nil {
+		klog.Fatalf("invalid tenancy mode: %v", err)
+	}
 
-	clusterClient, err := clusterclientset.NewForConfig(config.GetConfigOrDie())
+	// The kube config is loaded once, and shared by the clients.
+	restConfig := config.GetConfigOrDie()
+	clusterClient, err := clusterclientset.NewForConfig(restConfig)
 	if err != nil {
 		klog.Fatalf("failed to initialize new cluster clientset: %v", err)
 	}
 
-	kubeClient, err := kubernetes.NewForConfig(config.GetConfigOrDie())
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		klog.Fatalf("failed to initialize new kubernetes client: %v", err)
 	}
 
+	authorizer, err := util.NewAuthorizer(cfg.authorizer, restConfig.Host, kubeClient)
+	if err != nil {
+		klog.Fatalf("failed to initialize authorizer: %v", err)
+	}
+	util.SetAuthorizer(authorizer)
+
+	stop := make(chan struct{})
+	if err := proxy.InitReverseProxy(cfg.metricServer, stop); err != nil {
+		klog.Fatalf("failed to initialize reverse proxy: %v", err)
+	}
+
 	_, err = proxyconfig.GetManagedClusterLabelAllowListConfigmap(kubeClient,
 		proxyconfig.ManagedClusterLabelAllowListNamespace)
 
@@ -85,6 +119,18 @@ func main() {
 	go util.WatchManagedClusterLabelAllowList(kubeClient)
 	go util.ScheduleManagedClusterLabelAllowlistResync(kubeClient)
 	go util.CleanExpiredProjectInfoJob(24 * 60 * 60)
+	if util.IsNamespaceTenancy() {
+		go util.WatchNamespaceTenancy(kubeClient)
+	}
+	go util.WatchQueryLimits(kubeClient)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	proxy.RegisterMetrics(reg)
+	go serveMetrics(cfg.metricsListenAddress, reg)
 
 	handlers := http.NewServeMux()
 	handlers.HandleFunc("/", proxy.HandleRequestAndRedirect)
@@ -100,3 +146,18 @@ func main() {
 		klog.Fatalf("failed to ListenAndServe: %v", err)
 	}
 }
+
+// serveMetrics serves the metrics of reg, apart from the proxied API.
+func serveMetrics(listenAddress string, reg *prometheus.Registry) {
+	handlers := http.NewServeMux()
+	handlers.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	s := http.Server{
+		Addr:              listenAddress,
+		Handler:           handlers,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	if err := s.ListenAndServe(); err != nil {
+		klog.Fatalf("failed to serve metrics: %v", err)
+	}
+}
@@ -20,6 +20,21 @@ const (
 	ManagedClusterLabelAllowListNamespace     = "open-cluster-management-observability"
 
 	RBACProxyLabelMetricName = "acm_label_names"
+
+	NamespaceTenancyConfigMapName = "observability-namespace-tenancy"
+	NamespaceTenancyConfigMapKey  = "tenancy.yaml"
+
+	// ClusterTenancyMode scopes the queries of a user to the managed clusters whose namespace
+	// the user can access on the hub. NamespaceTenancyMode also grants access to the namespaces
+	// of the namespace tenancy configmap, on the clusters it lists.
+	ClusterTenancyMode   = "cluster"
+	NamespaceTenancyMode = "namespace"
+
+	// AllClusters is the name of a tenant cluster that stands for every managed cluster.
+	AllClusters = "*"
+
+	QueryLimitsConfigMapName = "observability-query-limits"
+	QueryLimitsConfigMapKey  = "limits.yaml"
 )
 
 var (
@@ -4,6 +4,8 @@
 
 package config
 
+import "github.com/prometheus/common/model"
+
 // ManagedClusterLabelList is the struct that contains the
 // list of labels that are assigned to the managed clusters.
 type ManagedClusterLabelList struct {
@@ -11,3 +13,54 @@ type ManagedClusterLabelList struct {
 	LabelList      []string `yaml:"labels"`
 	RegexLabelList []string `yaml:"-"`
 }
+
+// NamespaceTenancy is the content of the namespace tenancy configmap. It maps the users
+// and groups to the namespaces they can query on every managed cluster.
+type NamespaceTenancy struct {
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// Tenant grants its users and the members of its groups access to the metrics of
+// the namespaces of its clusters.
+type Tenant struct {
+	Name     string          `yaml:"name"`
+	Users    []string        `yaml:"users,omitempty"`
+	Groups   []string        `yaml:"groups,omitempty"`
+	Clusters []TenantCluster `yaml:"clusters"`
+}
+
+// TenantCluster lists the namespaces of a managed cluster, or of every managed cluster
+// when the name is "*".
+type TenantCluster struct {
+	Name       string   `yaml:"name"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// QueryLimits is the content of the query limits configmap. The limits of the first override
+// that lists a user, or one of its groups, replace the default limits of the user.
+type QueryLimits struct {
+	Default   Limits           `yaml:"default"`
+	Overrides []LimitsOverride `yaml:"overrides,omitempty"`
+}
+
+// LimitsOverride sets the limits of its users and of the members of its groups.
+type LimitsOverride struct {
+	Users  []string `yaml:"users,omitempty"`
+	Groups []string `yaml:"groups,omitempty"`
+	Limits Limits   `yaml:"limits"`
+}
+
+// Limits bounds the queries of a user, a zero limit is unlimited.
+type Limits struct {
+	// RequestsPerSecond is the rate of the requests, with bursts of Burst requests.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond,omitempty"`
+	Burst             int     `yaml:"burst,omitempty"`
+	// MaxConcurrentQueries is the number of requests proxied at once.
+	MaxConcurrentQueries int `yaml:"maxConcurrentQueries,omitempty"`
+	// MaxQueryRangeSpan is the span between the start and the end of a range query, and
+	// MaxSpanStepRatio the number of its steps.
+	MaxQueryRangeSpan model.Duration `yaml:"maxQueryRangeSpan,omitempty"`
+	MaxSpanStepRatio  int            `yaml:"maxSpanStepRatio,omitempty"`
+	// MaxResponseBytes is the size of the responses of the metrics server.
+	MaxResponseBytes int64 `yaml:"maxResponseBytes,omitempty"`
+}
@@ -0,0 +1,327 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+	"k8s.io/klog"
+
+	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
+	"github.com/stolostron/multicluster-observability-operator/proxy/pkg/util"
+)
+
+// The reasons of the rejections of the requests, in the throttled requests metric.
+const (
+	rateLimitReason    = "rate_limit"
+	concurrencyReason  = "concurrency"
+	rangeSpanReason    = "query_range_span"
+	rangeStepsReason   = "query_range_steps"
+	responseSizeReason = "response_size"
+)
+
+// limiterIdleTimeout is how long the limiter of a user without requests is kept. A user
+// returning after it gets a full rate limiter again.
+const limiterIdleTimeout = 15 * time.Minute
+
+// The error types of the Prometheus HTTP API.
+const (
+	errorTypeBadData     = "bad_data"
+	errorTypeExecution   = "execution"
+	errorTypeUnavailable = "unavailable"
+)
+
+var (
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rbac_query_proxy_throttled_requests_total",
+		Help: "Total number of requests rejected by the query limits of their user, by reason.",
+	}, []string{"reason"})
+	inflightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rbac_query_proxy_inflight_requests",
+		Help: "Number of requests being proxied to the metrics server.",
+	})
+
+	userLimiters     = map[string]*userLimiter{}
+	userLimitersLock sync.Mutex
+	// lastLimitersSweep is when the idle limiters were last removed.
+	lastLimitersSweep time.Time
+)
+
+// limitsContextKey is the context key of the limits of the user of a request.
+type limitsContextKey struct{}
+
+// userLimiter tracks the requests of a user under its limits.
+type userLimiter struct {
+	limits   proxyconfig.Limits
+	requests *rate.Limiter
+	inflight int
+	lastUsed time.Time
+}
+
+// apiError is the body of the errors of the Prometheus HTTP API.
+type apiError struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+// RegisterMetrics registers the metrics of the proxy.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(throttledRequests, inflightRequests)
+}
+
+// acquire admits a request of a user under its limits. It returns the function that releases
+// the request, or the reason why the request is rejected.
+func acquire(userName string, limits proxyconfig.Limits) (func(), string) {
+	userLimitersLock.Lock()
+	defer userLimitersLock.Unlock()
+
+	now := time.Now()
+	sweepIdleLimiters(now)
+	l, ok := userLimiters[userName]
+	if !ok {
+		l = &userLimiter{}
+		userLimiters[userName] = l
+	}
+	l.lastUsed = now
+	if !ok || l.limits != limits {
+		// The limits of the user changed, the requests in flight are still counted.
+		l.limits = limits
+		l.requests = nil
+		if limits.RequestsPerSecond > 0 {
+			burst := limits.Burst
+			if burst == 0 {
+				burst = int(math.Max(1, math.Ceil(limits.RequestsPerSecond)))
+			}
+			l.requests = rate.NewLimiter(rate.Limit(limits.RequestsPerSecond), burst)
+		}
+	}
+
+	if l.requests != nil && !l.requests.Allow() {
+		return nil, rateLimitReason
+	}
+	if limits.MaxConcurrentQueries > 0 && l.inflight >= limits.MaxConcurrentQueries {
+		return nil, concurrencyReason
+	}
+	l.inflight++
+	return func() {
+		userLimitersLock.Lock()
+		defer userLimitersLock.Unlock()
+		l.inflight--
+		l.lastUsed = time.Now()
+	}, ""
+}
+
+// sweepIdleLimiters removes the limiters of the users without requests for limiterIdleTimeout,
+// at most once per limiterIdleTimeout. userLimitersLock must be held.
+func sweepIdleLimiters(now time.Time) {
+	if now.Sub(lastLimitersSweep) < limiterIdleTimeout {
+		return
+	}
+	lastLimitersSweep = now
+	for userName, l := range userLimiters {
+		if l.inflight == 0 && now.Sub(l.lastUsed) >= limiterIdleTimeout {
+			delete(userLimiters, userName)
+		}
+	}
+}
+
+// checkLimits admits req under the limits of its user. It returns req carrying the limits, and
+// the function that releases the request. Both are nil when the request is rejected, the error
+// is then written to res.
+func checkLimits(res http.ResponseWriter, req *http.Request) (*http.Request, func()) {
+	// The limits apply to the user of the token, as the X-Forwarded-User header can be set by
+	// the client.
+	user, err := util.GetAuthenticatedUser(req.Header.Get("X-Forwarded-Access-Token"))
+	if err != nil || user.Name == "" {
+		klog.Errorf("failed to authenticate the user of the request: %v", err)
+		writeAPIError(res, http.StatusServiceUnavailable, errorTypeUnavailable,
+			errors.New("failed to authenticate the user of the request"))
+		return nil, nil
+	}
+	limits := util.GetUserLimits(user.Name, user.Groups)
+
+	if strings.HasSuffix(req.URL.Path, "/api/v1/query_range") {
+		if reason, err := checkQueryRange(req, limits); err != nil {
+			klog.Infof("rejected query of user <%s>: %v", user.Name, err)
+			throttledRequests.WithLabelValues(reason).Inc()
+			writeAPIError(res, http.StatusBadRequest, errorTypeBadData, err)
+			return nil, nil
+		}
+	}
+
+	release, reason := acquire(user.Name, limits)
+	if release == nil {
+		klog.Infof("throttled request of user <%s>: %s", user.Name, reason)
+		throttledRequests.WithLabelValues(reason).Inc()
+		res.Header().Set("Retry-After", "1")
+		writeAPIError(res, http.StatusTooManyRequests, errorTypeUnavailable,
+			fmt.Errorf("too many requests of user %s, the %s limit is exceeded", user.Name, reason))
+		return nil, nil
+	}
+	return req.WithContext(context.WithValue(req.Context(), limitsContextKey{}, limits)), release
+}
+
+// checkQueryRange checks the span and the steps of the range query of req. The parameters that
+// cannot be parsed are not checked, the metrics server rejects them.
+func checkQueryRange(req *http.Request, limits proxyconfig.Limits) (string, error) {
+	if limits.MaxQueryRangeSpan == 0 && limits.MaxSpanStepRatio == 0 {
+		return "", nil
+	}
+	values, err := getQueryValues(req)
+	if err != nil {
+		return "", nil
+	}
+	start, err := parseTime(values.Get("start"))
+	if err != nil {
+		return "", nil
+	}
+	end, err := parseTime(values.Get("end"))
+	if err != nil {
+		return "", nil
+	}
+	span := end.Sub(start)
+	if limits.MaxQueryRangeSpan > 0 && span > time.Duration(limits.MaxQueryRangeSpan) {
+		return rangeSpanReason, fmt.Errorf("the query range span %s exceeds the limit of %s",
+			model.Duration(span), limits.MaxQueryRangeSpan)
+	}
+
+	step, err := parseDuration(values.Get("step"))
+	if err != nil || step <= 0 {
+		return "", nil
+	}
+	if steps := int64(span / step); limits.MaxSpanStepRatio > 0 && steps > int64(limits.MaxSpanStepRatio) {
+		return rangeStepsReason, fmt.Errorf("the query range has %d steps, it exceeds the limit of %d steps, "+
+			"increase the step", steps, limits.MaxSpanStepRatio)
+	}
+	return "", nil
+}
+
+// getQueryValues returns the parameters of the URL and of the form body of req, the body is
+// left to be read again.
+func getQueryValues(req *http.Request) (url.Values, error) {
+	values := req.URL.Query()
+	if req.Method != http.MethodPost || req.Body == nil {
+		return values, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	bodyValues, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range bodyValues {
+		values[k] = append(values[k], v...)
+	}
+	return values, nil
+}
+
+// parseTime parses a time of the Prometheus HTTP API, a unix timestamp or a RFC3339 time.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, ns := math.Modf(t)
+		return time.Unix(int64(sec), int64(math.Round(ns*float64(time.Second)))), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// parseDuration parses a duration of the Prometheus HTTP API, in seconds or a duration string.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	d, err := model.ParseDuration(s)
+	return time.Duration(d), err
+}
+
+// limitResponseSize replaces the response of the metrics server with an error when it is larger
+// than the limit of the user of the request. The size of a compressed response is its decoded
+// size, the response is still sent compressed.
+func limitResponseSize(res *http.Response) error {
+	limits, ok := res.Request.Context().Value(limitsContextKey{}).(proxyconfig.Limits)
+	if !ok || limits.MaxResponseBytes == 0 {
+		return nil
+	}
+
+	// The compressed responses are smaller than decoded, they are rejected when they are already
+	// larger than the limit compressed.
+	var body []byte
+	if res.ContentLength <= limits.MaxResponseBytes {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(res.Body, limits.MaxResponseBytes+1))
+		_ = res.Body.Close()
+		if err != nil {
+			return err
+		}
+		size := int64(len(body))
+		if size <= limits.MaxResponseBytes && res.Header.Get("Content-Encoding") == "gzip" {
+			size, err = decodedSize(body, limits.MaxResponseBytes+1)
+			if err != nil {
+				return err
+			}
+		}
+		if size <= limits.MaxResponseBytes {
+			res.Body = io.NopCloser(bytes.NewReader(body))
+			return nil
+		}
+	} else {
+		_ = res.Body.Close()
+	}
+
+	klog.Infof("rejected response of user <%s>: larger than %d bytes",
+		res.Request.Header.Get("X-Forwarded-User"), limits.MaxResponseBytes)
+	throttledRequests.WithLabelValues(responseSizeReason).Inc()
+	body, _ = json.Marshal(apiError{
+		Status:    "error",
+		ErrorType: errorTypeExecution,
+		Error:     fmt.Sprintf("the response exceeds the limit of %d bytes, narrow down the query", limits.MaxResponseBytes),
+	})
+	res.StatusCode = http.StatusUnprocessableEntity
+	res.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	res.Header.Del("Content-Encoding")
+	res.Header.Set("Content-Type", "application/json")
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.ContentLength = int64(len(body))
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// decodedSize returns the decoded size of the gzip body, up to limit bytes.
+func decodedSize(body []byte, limit int64) (int64, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	return io.Copy(io.Discard, io.LimitReader(gz, limit))
+}
+
+func writeAPIError(res http.ResponseWriter, code int, errorType string, err error) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(code)
+	if err := json.NewEncoder(res).Encode(apiError{Status: "error", ErrorType: errorType, Error: err.Error()}); err != nil {
+		klog.Errorf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
+	"github.com/stolostron/multicluster-observability-operator/proxy/pkg/util"
+)
+
+func TestAcquire(t *testing.T) {
+	limits := proxyconfig.Limits{RequestsPerSecond: 1, Burst: 3, MaxConcurrentQueries: 2}
+	first, reason := acquire("test-acquire", limits)
+	if first == nil {
+		t.Fatalf("expected the first request to be admitted, got %s", reason)
+	}
+	if release, _ := acquire("test-acquire", limits); release == nil {
+		t.Fatal("expected the second request to be admitted")
+	}
+	if _, reason := acquire("test-acquire", limits); reason != concurrencyReason {
+		t.Errorf("expected the third concurrent request to be throttled, got %q", reason)
+	}
+	first()
+	if _, reason := acquire("test-acquire", limits); reason != rateLimitReason {
+		t.Errorf("expected the fourth request to exceed the burst, got %q", reason)
+	}
+
+	// Other users have their own limiters.
+	if release, reason := acquire("test-acquire-other", limits); release == nil {
+		t.Errorf("expected the request of another user to be admitted, got %s", reason)
+	}
+}
+
+func TestSweepIdleLimiters(t *testing.T) {
+	userLimitersLock.Lock()
+	defer userLimitersLock.Unlock()
+
+	now := time.Now()
+	userLimiters["test-idle"] = &userLimiter{lastUsed: now.Add(-2 * limiterIdleTimeout)}
+	userLimiters["test-inflight"] = &userLimiter{lastUsed: now.Add(-2 * limiterIdleTimeout), inflight: 1}
+	userLimiters["test-active"] = &userLimiter{lastUsed: now}
+	lastLimitersSweep = time.Time{}
+	sweepIdleLimiters(now)
+	for userName, kept := range map[string]bool{"test-idle": false, "test-inflight": true, "test-active": true} {
+		if _, ok := userLimiters[userName]; ok != kept {
+			t.Errorf("expected the limiter of %s to be kept: %v", userName, kept)
+		}
+	}
+}
+
+func TestCheckQueryRange(t *testing.T) {
+	limits := proxyconfig.Limits{MaxQueryRangeSpan: model.Duration(24 * time.Hour), MaxSpanStepRatio: 1000}
+	testCaseList := []struct {
+		name   string
+		query  string
+		reason string
+	}{
+		{"within limits", "start=0&end=3600&step=60", ""},
+		{"span too large", "start=2023-01-01T00:00:00Z&end=2023-01-03T00:00:00Z&step=1h", rangeSpanReason},
+		{"too many steps", "start=0&end=3600&step=1", rangeStepsReason},
+		{"invalid parameters", "start=now&end=3600&step=1", ""},
+	}
+	for _, c := range testCaseList {
+		req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1/api/v1/query_range", strings.NewReader(c.query))
+		reason, err := checkQueryRange(req, limits)
+		if reason != c.reason || (err != nil) != (c.reason != "") {
+			t.Errorf("case (%v) output: (%v, %v) is not the expected: (%v)", c.name, reason, err, c.reason)
+		}
+		if body, _ := io.ReadAll(req.Body); string(body) != c.query {
+			t.Errorf("case (%v) expected the body to be kept, got %s", c.name, body)
+		}
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	util.InitUserProjectInfo()
+	up := util.NewUserProject("test-check-limits", "test-check-limits-token", []string{})
+	up.User = &util.UserInfo{Name: "test-check-limits"}
+	util.UpdateUserProject(up)
+	util.SetQueryLimits(proxyconfig.QueryLimits{Overrides: []proxyconfig.LimitsOverride{
+		{Users: []string{"spoofed"}, Limits: proxyconfig.Limits{MaxConcurrentQueries: 1}},
+		{Users: []string{"test-check-limits"}, Limits: proxyconfig.Limits{MaxConcurrentQueries: 2}},
+	}})
+	defer util.SetQueryLimits(proxyconfig.QueryLimits{})
+
+	// The limits of the authenticated user apply, not the ones of the forwarded user.
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/api/v1/query", nil)
+	req.Header.Set("X-Forwarded-Access-Token", "test-check-limits-token")
+	req.Header.Set("X-Forwarded-User", "spoofed")
+	limitedReq, release := checkLimits(httptest.NewRecorder(), req)
+	if release == nil {
+		t.Fatal("expected the request to be admitted")
+	}
+	defer release()
+	if limits, _ := limitedReq.Context().Value(limitsContextKey{}).(proxyconfig.Limits); limits.MaxConcurrentQueries != 2 {
+		t.Errorf("expected the request to carry the limits of its user, got %+v", limits)
+	}
+
+	req.Header.Set("X-Forwarded-Access-Token", "unknown-token")
+	util.SetAuthorizer(util.NewOpenShiftAuthorizer("http://127.0.0.1:0"))
+	defer util.SetAuthorizer(nil)
+	rec := httptest.NewRecorder()
+	if _, release := checkLimits(rec, req); release != nil || rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the request of an unknown user to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestLimitResponseSize(t *testing.T) {
+	ctx := context.WithValue(context.Background(), limitsContextKey{}, proxyconfig.Limits{MaxResponseBytes: 10})
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/api/v1/query", nil).WithContext(ctx)
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: -1,
+		Body: io.NopCloser(strings.NewReader("small")), Request: req}
+	if err := limitResponseSize(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body, _ := io.ReadAll(res.Body); res.StatusCode != http.StatusOK || string(body) != "small" {
+		t.Errorf("expected the response to be kept, got %d %s", res.StatusCode, body)
+	}
+
+	res = &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}}, ContentLength: -1,
+		Body: io.NopCloser(strings.NewReader("a response larger than the limit")), Request: req}
+	if err := limitResponseSize(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	apiErr := apiError{}
+	if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if res.StatusCode != http.StatusUnprocessableEntity || res.Header.Get("Content-Encoding") != "" ||
+		apiErr.Status != "error" || apiErr.ErrorType != errorTypeExecution {
+		t.Errorf("expected a Prometheus API error, got %d %+v", res.StatusCode, apiErr)
+	}
+}
+
+func gzipResponse(req *http.Request, body string) *http.Response {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte(body))
+	_ = gz.Close()
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}},
+		ContentLength: int64(buf.Len()), Body: io.NopCloser(&buf), Request: req}
+}
+
+func TestLimitResponseSizeDecoded(t *testing.T) {
+	ctx := context.WithValue(context.Background(), limitsContextKey{}, proxyconfig.Limits{MaxResponseBytes: 100})
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/api/v1/query", nil).WithContext(ctx)
+
+	// The compressed response is sent unchanged.
+	res := gzipResponse(req, "small")
+	if err := limitResponseSize(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatalf("expected the response to be kept compressed: %v", err)
+	}
+	if body, _ := io.ReadAll(gz); res.StatusCode != http.StatusOK || string(body) != "small" {
+		t.Errorf("expected the response to be kept, got %d %s", res.StatusCode, body)
+	}
+
+	// A response smaller than the limit compressed is rejected when it is larger decoded.
+	res = gzipResponse(req, strings.Repeat("a", 1000))
+	if res.ContentLength > 100 {
+		t.Fatalf("expected the compressed response to be smaller than the limit, got %d bytes", res.ContentLength)
+	}
+	if err := limitResponseSize(res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.StatusCode != http.StatusUnprocessableEntity || res.Header.Get("Content-Encoding") != "" {
+		t.Errorf("expected the response to be rejected, got %d", res.StatusCode)
+	}
+}
@@ -12,28 +12,60 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
 	"path"
 	"strings"
 
 	"k8s.io/klog"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
 	"github.com/stolostron/multicluster-observability-operator/proxy/pkg/util"
 )
 
 const (
-	basePath        = "/api/metrics/v1/default"
-	projectsAPIPath = "/apis/project.openshift.io/v1/projects"
-	userAPIPath     = "/apis/user.openshift.io/v1/users/~"
+	basePath = "/api/metrics/v1/default"
 )
 
 var (
 	serverScheme = ""
 	serverHost   = ""
+
+	// reverseProxy sends the requests to the metrics server, it is created by InitReverseProxy.
+	reverseProxy *httputil.ReverseProxy
 )
 
+// InitReverseProxy creates the reverse proxy to the metrics server. Its transport is shared by
+// all the requests and keeps the connections alive, the certificates are reloaded when they
+// change until stop is closed.
+func InitReverseProxy(metricsServer string, stop <-chan struct{}) error {
+	serverURL, err := url.Parse(metricsServer)
+	if err != nil {
+		return err
+	}
+	serverHost = serverURL.Host
+	serverScheme = serverURL.Scheme
+
+	transport, err := newReloadingTransport(caPath, certPath)
+	if err != nil {
+		return err
+	}
+	if err := transport.watch(stop); err != nil {
+		return err
+	}
+
+	filterResponse := util.FilterMetricsResponse(transport)
+	reverseProxy = &httputil.ReverseProxy{
+		Director:  proxyRequest,
+		Transport: transport,
+		ModifyResponse: func(res *http.Response) error {
+			if err := limitResponseSize(res); err != nil {
+				return err
+			}
+			return filterResponse(res)
+		},
+	}
+	return nil
+}
+
 func shouldModifyAPISeriesResponse(res http.ResponseWriter, req *http.Request) bool {
 	if strings.HasSuffix(req.URL.Path, "/api/v1/series") {
 		body, err := io.ReadAll(req.Body)
@@ -83,29 +115,27 @@ func HandleRequestAndRedirect(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	serverURL, err := url.Parse(os.Getenv("METRICS_SERVER"))
-	if err != nil {
-		klog.Errorf("failed to parse url: %v", err)
-	}
-	serverHost = serverURL.Host
-	serverScheme = serverURL.Scheme
-
-	tlsTransport, err := getTLSTransport()
-	if err != nil {
-		klog.Fatalf("failed to create tls transport: %v", err)
-	}
-
-	// create the reverse proxy
-	proxy := httputil.ReverseProxy{
-		Director:  proxyRequest,
-		Transport: tlsTransport,
+	req, release := checkLimits(res, req)
+	if release == nil {
+		return
 	}
+	defer release()
+	inflightRequests.Inc()
+	defer inflightRequests.Dec()
 
 	req.Header.Set("X-Forwarded-Host", req.Header.Get("Host"))
-	req.Host = serverURL.Host
+	req.Host = serverHost
 	req.URL.Path = path.Join(basePath, req.URL.Path)
-	util.ModifyMetricsQueryParams(req, config.GetConfigOrDie().Host+projectsAPIPath)
-	proxy.ServeHTTP(res, req)
+	if err := util.ModifyMetricsQueryParams(req); err != nil {
+		klog.Infof("rejected query of user <%s>: %v", req.Header.Get("X-Forwarded-User"), err)
+		code := http.StatusBadRequest
+		if errors.Is(err, util.ErrNothingGranted) {
+			code = http.StatusForbidden
+		}
+		writeAPIError(res, code, errorTypeBadData, err)
+		return
+	}
+	reverseProxy.ServeHTTP(res, req)
 }
 
 func preCheckRequest(req *http.Request) error {
@@ -119,24 +149,38 @@ func preCheckRequest(req *http.Request) error {
 		}
 	}
 
-	userName := req.Header.Get("X-Forwarded-User")
-	if userName == "" {
-		userName = util.GetUserName(token, config.GetConfigOrDie().Host+userAPIPath)
-		if userName == "" {
+	// The user is authenticated once per token. The X-Forwarded-User header can be set by the
+	// client, it is replaced with the authenticated user.
+	projectList, ok := util.GetUserProjectList(token)
+	user, cached := util.GetUserInfo(token)
+	if !ok || !cached {
+		var err error
+		user, err = util.GetAuthorizer().User(token)
+		if err != nil || user.Name == "" {
 			return errors.New("failed to found user name")
-		} else {
-			req.Header.Set("X-Forwarded-User", userName)
 		}
+		// The projects of the user are reused for its other tokens.
+		projectList, ok = util.GetUserProjectListOfUser(user)
+		if !ok {
+			projectList, err = util.GetAuthorizer().Projects(token, user)
+			if err != nil {
+				klog.Errorf("failed to get the projects of user %s: %v", user.Name, err)
+				return errors.New("failed to get the projects of the user")
+			}
+		}
+		up := util.NewUserProject(user.Name, token, projectList)
+		up.User = &user
+		util.UpdateUserProject(up)
 	}
+	req.Header.Set("X-Forwarded-User", user.Name)
 
-	projectList, ok := util.GetUserProjectList(token)
-	if !ok {
-		projectList = util.FetchUserProjectList(token, config.GetConfigOrDie().Host+projectsAPIPath)
-		up := util.NewUserProject(userName, token, projectList)
-		util.UpdateUserProject(up)
+	hasAccess := len(projectList) > 0
+	if util.IsNamespaceTenancy() {
+		// The namespace tenancy may grant namespaces to the user or its groups.
+		hasAccess = hasAccess || util.HasNamespaceAccess(user.Name, user.Groups)
 	}
 
-	if len(projectList) == 0 || len(util.GetAllManagedClusterNames()) == 0 {
+	if !hasAccess || len(util.GetAllManagedClusterNames()) == 0 {
 		return errors.New("no project or cluster found")
 	}
 
@@ -176,6 +220,10 @@ func gzipWrite(w io.Writer, data []byte) error {
 func proxyRequest(r *http.Request) {
 	r.URL.Scheme = serverScheme
 	r.URL.Host = serverHost
+	if util.ShouldFilterResponse(r.URL.Path) {
+		// Let the transport decompress the response, it is filtered before it is sent back.
+		r.Header.Del("Accept-Encoding")
+	}
 	if r.Method == http.MethodGet {
 		if strings.HasSuffix(r.URL.Path, "/api/v1/query") ||
 			strings.HasSuffix(r.URL.Path, "/api/v1/query_range") ||
@@ -7,6 +7,7 @@ package proxy
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -83,6 +84,17 @@ func (r *FakeResponse) WriteHeader(status int) {
 	r.status = status
 }
 
+// failingProjectsAuthorizer authenticates every token, and fails to list the projects.
+type failingProjectsAuthorizer struct{}
+
+func (failingProjectsAuthorizer) User(token string) (util.UserInfo, error) {
+	return util.UserInfo{Name: token}, nil
+}
+
+func (failingProjectsAuthorizer) Projects(string, util.UserInfo) ([]string, error) {
+	return nil, errors.New("the server is currently unable to handle the request")
+}
+
 func TestPreCheckRequest(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://127.0.0.1:3002/metrics/query?query=foo", nil)
 	resp := http.Response{
@@ -91,9 +103,10 @@ func TestPreCheckRequest(t *testing.T) {
 		Request: req,
 	}
 	resp.Request.Header.Set("X-Forwarded-Access-Token", "test")
-	resp.Request.Header.Set("X-Forwarded-User", "test")
+	resp.Request.Header.Set("X-Forwarded-User", "spoofed")
 	util.InitUserProjectInfo()
 	up := util.NewUserProject("test", "test", []string{"p"})
+	up.User = &util.UserInfo{Name: "test"}
 	util.UpdateUserProject(up)
 	util.InitAllManagedClusterNames()
 	clusters := util.GetAllManagedClusterNames()
@@ -102,6 +115,9 @@ func TestPreCheckRequest(t *testing.T) {
 	if err != nil {
 		t.Errorf("failed to test preCheckRequest: %v", err)
 	}
+	if user := req.Header.Get("X-Forwarded-User"); user != "test" {
+		t.Errorf("expected the forwarded user to be the authenticated user, got %s", user)
+	}
 
 	resp.Request.Header.Del("X-Forwarded-Access-Token")
 	resp.Request.Header.Add("Authorization", "test")
@@ -110,12 +126,25 @@ func TestPreCheckRequest(t *testing.T) {
 		t.Errorf("failed to test preCheckRequest with bear token: %v", err)
 	}
 
-	resp.Request.Header.Del("X-Forwarded-User")
+	// The user of an unknown token cannot be authenticated.
+	util.SetAuthorizer(util.NewOpenShiftAuthorizer("http://127.0.0.1:0"))
+	defer util.SetAuthorizer(nil)
+	resp.Request.Header.Set("X-Forwarded-Access-Token", "unknown")
 	err = preCheckRequest(req)
-	if !strings.Contains(err.Error(), "failed to found user name") {
+	if err == nil || !strings.Contains(err.Error(), "failed to found user name") {
 		t.Errorf("failed to test preCheckRequest: %v", err)
 	}
 
+	// The projects are not cached when they cannot be fetched.
+	util.SetAuthorizer(failingProjectsAuthorizer{})
+	resp.Request.Header.Set("X-Forwarded-Access-Token", "failing")
+	if err := preCheckRequest(req); err == nil {
+		t.Error("expected the request to fail when the projects cannot be fetched")
+	}
+	if _, ok := util.GetUserProjectList("failing"); ok {
+		t.Error("expected the projects not to be cached when they cannot be fetched")
+	}
+
 	resp.Request.Header.Del("X-Forwarded-Access-Token")
 	resp.Request.Header.Del("Authorization")
 	err = preCheckRequest(req)
@@ -12,22 +12,111 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"k8s.io/klog"
 )
 
 const (
 	caPath   = "/var/rbac_proxy/ca"
 	certPath = "/var/rbac_proxy/certs"
+
+	// reloadDelay groups the file events of a certificate update, as the secret volumes
+	// are updated with several file operations.
+	reloadDelay = time.Second
 )
 
-func getTLSTransport() (*http.Transport, error) {
+// reloadingTransport is a long-lived transport to the metrics server. It keeps the connections
+// alive, and is rebuilt with the new certificates when their files change.
+type reloadingTransport struct {
+	caFile  string
+	crtFile string
+	keyFile string
+
+	lock      sync.RWMutex
+	transport *http.Transport
+}
+
+// newReloadingTransport returns a transport with the certificates of the caDir and certDir.
+func newReloadingTransport(caDir, certDir string) (*reloadingTransport, error) {
+	t := &reloadingTransport{
+		caFile:  path.Join(caDir, "ca.crt"),
+		crtFile: path.Join(certDir, "tls.crt"),
+		keyFile: path.Join(certDir, "tls.key"),
+	}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.RLock()
+	transport := t.transport
+	t.lock.RUnlock()
+	return transport.RoundTrip(req)
+}
+
+// reload rebuilds the transport with the certificates of the files. The connections of the
+// previous transport are closed once they are idle.
+func (t *reloadingTransport) reload() error {
+	transport, err := getTLSTransport(t.caFile, t.crtFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	previous := t.transport
+	t.transport = transport
+	t.lock.Unlock()
+	if previous != nil {
+		previous.CloseIdleConnections()
+	}
+	return nil
+}
+
+// watch reloads the transport when the files of the certificates change, until stop is closed.
+func (t *reloadingTransport) watch(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// The directories are watched, as the files of the secret volumes are replaced through symlinks.
+	for _, dir := range []string{filepath.Dir(t.caFile), filepath.Dir(t.crtFile)} {
+		if err := watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
 
-	caCertFile := path.Join(caPath, "ca.crt")
-	tlsKeyFile := path.Join(certPath, "tls.key")
-	tlsCrtFile := path.Join(certPath, "tls.crt")
+	go func() {
+		defer watcher.Close()
+		reloadTimer := time.NewTimer(reloadDelay)
+		reloadTimer.Stop()
+		for {
+			select {
+			case <-stop:
+				reloadTimer.Stop()
+				return
+			case event := <-watcher.Events:
+				klog.V(1).Infof("certificate file event: %v", event)
+				reloadTimer.Reset(reloadDelay)
+			case err := <-watcher.Errors:
+				klog.Errorf("failed to watch certificate files: %v", err)
+			case <-reloadTimer.C:
+				if err := t.reload(); err != nil {
+					klog.Errorf("failed to reload certificates, keeping the previous ones: %v", err)
+					continue
+				}
+				klog.Info("reloaded certificates")
+			}
+		}
+	}()
+	return nil
+}
 
+func getTLSTransport(caCertFile, tlsCrtFile, tlsKeyFile string) (*http.Transport, error) {
 	// Load Server CA cert
 	caCert, err := os.ReadFile(filepath.Clean(caCertFile))
 	if err != nil {
@@ -50,13 +139,16 @@ func getTLSTransport() (*http.Transport, error) {
 		MinVersion:   tls.VersionTLS12,
 	}
 	return &http.Transport{
-		Dial: (&net.Dialer{
+		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 300 * time.Second,
-		}).Dial,
+		}).DialContext,
 		TLSHandshakeTimeout:   30 * time.Second,
 		ResponseHeaderTimeout: 300 * time.Second,
-		DisableKeepAlives:     true,
-		TLSClientConfig:       tlsConfig,
+		// A dashboard sends its panel queries at once, keep enough connections to reuse them.
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     tlsConfig,
 	}, nil
 }
@@ -0,0 +1,88 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificates(t *testing.T, caDir, certDir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "rbac-query-proxy"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	for file, data := range map[string][]byte{
+		filepath.Join(caDir, "ca.crt"):    crt,
+		filepath.Join(certDir, "tls.crt"): crt,
+		filepath.Join(certDir, "tls.key"): pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	} {
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+}
+
+func TestReloadingTransport(t *testing.T) {
+	caDir, certDir := t.TempDir(), t.TempDir()
+	if _, err := newReloadingTransport(caDir, certDir); err == nil {
+		t.Error("expected the transport to require certificates")
+	}
+
+	writeCertificates(t, caDir, certDir)
+	transport, err := newReloadingTransport(caDir, certDir)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	initial := transport.transport
+	if initial.DisableKeepAlives || initial.MaxIdleConnsPerHost == 0 {
+		t.Errorf("expected the transport to keep the connections alive")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := transport.watch(stop); err != nil {
+		t.Fatalf("failed to watch certificates: %v", err)
+	}
+	writeCertificates(t, caDir, certDir)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		transport.lock.RLock()
+		reloaded := transport.transport != initial
+		transport.lock.RUnlock()
+		if reloaded {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("expected the transport to be rebuilt with the new certificates")
+}
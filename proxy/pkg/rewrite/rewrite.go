@@ -31,14 +31,23 @@ func InjectLabels(query string, label string, values []string) (string, error) {
 	}
 
 	matchType := labels.MatchRegexp
+	var value string
 	if len(values) == 1 {
 		matchType = labels.MatchEqual
+		value = values[0]
+	} else {
+		// The values are matched literally, the cluster names have dots.
+		quoted := make([]string, 0, len(values))
+		for _, v := range values {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+		value = strings.Join(quoted, "|")
 	}
 	err = injectproxy.NewEnforcer(false, []*labels.Matcher{
 		{
 			Name:  label,
 			Type:  matchType,
-			Value: strings.Join(values[:], "|"),
+			Value: value,
 		},
 	}...).EnforceNode(expr)
 	if err != nil {
@@ -70,6 +70,13 @@ func TestInjectLabels(t *testing.T) {
 			values:   []string{"A", "B"},
 			expected: `test_metrics{cluster="A",akey="value",cluster=~"A|B"}`,
 		},
+		{
+			name:     "Values with regexp characters",
+			query:    "test_metrics",
+			label:    "cluster",
+			values:   []string{"c1.prod", "c2"},
+			expected: `test_metrics{cluster=~"c1\\.prod|c2"}`,
+		},
 	}
 
 	for _, c := range caseList {
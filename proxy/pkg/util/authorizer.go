@@ -0,0 +1,228 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const (
+	OpenShiftAuthorizer  = "openshift"
+	KubernetesAuthorizer = "kubernetes"
+
+	projectsAPIPath = "/apis/project.openshift.io/v1/projects"
+	userAPIPath     = "/apis/user.openshift.io/v1/users/~"
+
+	// maxConcurrentReviews bounds the subject access reviews sent at once for a user.
+	maxConcurrentReviews = 10
+)
+
+var authorizer Authorizer
+
+// UserInfo is a user authenticated by an Authorizer.
+type UserInfo struct {
+	Name   string
+	Groups []string
+	// UID and Extra are set by the authorizers that review the access of the user.
+	UID   string
+	Extra map[string][]string
+}
+
+// Authorizer authenticates the users of the proxy, and lists the namespaces they can access
+// on the hub. A user can query the metrics of the managed clusters whose namespace it lists.
+type Authorizer interface {
+	// User returns the user of token.
+	User(token string) (UserInfo, error)
+	// Projects returns the namespaces user, the user of token, can access.
+	Projects(token string, user UserInfo) ([]string, error)
+}
+
+// SetAuthorizer sets the Authorizer of the proxy.
+func SetAuthorizer(a Authorizer) {
+	authorizer = a
+}
+
+// GetAuthorizer returns the Authorizer of the proxy, the OpenShift Authorizer of the
+// current cluster if none was set.
+func GetAuthorizer() Authorizer {
+	if authorizer == nil {
+		authorizer = NewOpenShiftAuthorizer(config.GetConfigOrDie().Host)
+	}
+	return authorizer
+}
+
+// GetAuthenticatedUser returns the user of token, which is cached along with its projects.
+func GetAuthenticatedUser(token string) (UserInfo, error) {
+	if user, ok := GetUserInfo(token); ok {
+		return user, nil
+	}
+	user, err := GetAuthorizer().User(token)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	UpdateUserInfo(token, user)
+	return user, nil
+}
+
+// NewAuthorizer returns the Authorizer named name, for the API server of host.
+func NewAuthorizer(name string, host string, kubeClient kubernetes.Interface) (Authorizer, error) {
+	switch name {
+	case OpenShiftAuthorizer:
+		return NewOpenShiftAuthorizer(host), nil
+	case KubernetesAuthorizer:
+		return NewKubernetesAuthorizer(kubeClient), nil
+	default:
+		return nil, fmt.Errorf("unknown authorizer %s, must be %s or %s", name, OpenShiftAuthorizer, KubernetesAuthorizer)
+	}
+}
+
+type openShiftAuthorizer struct {
+	host string
+}
+
+// NewOpenShiftAuthorizer returns an Authorizer that reads the users and the projects they
+// can access from the OpenShift APIs of host.
+func NewOpenShiftAuthorizer(host string) Authorizer {
+	return openShiftAuthorizer{host: strings.TrimRight(host, "/")}
+}
+
+func (a openShiftAuthorizer) User(token string) (UserInfo, error) {
+	user, err := fetchUser(token, a.host+userAPIPath)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	if user.Name == "" {
+		return UserInfo{}, errors.New("failed to found user name")
+	}
+	return UserInfo{Name: user.Name, Groups: user.Groups}, nil
+}
+
+func (a openShiftAuthorizer) Projects(token string, _ UserInfo) ([]string, error) {
+	return FetchUserProjectList(token, a.host+projectsAPIPath), nil
+}
+
+type kubernetesAuthorizer struct {
+	kubeClient kubernetes.Interface
+}
+
+// NewKubernetesAuthorizer returns an Authorizer that authenticates the users with token
+// reviews, and lists the namespaces of the managed clusters they can get with subject
+// access reviews. It only relies on the Kubernetes APIs.
+func NewKubernetesAuthorizer(kubeClient kubernetes.Interface) Authorizer {
+	return kubernetesAuthorizer{kubeClient: kubeClient}
+}
+
+func (a kubernetesAuthorizer) User(token string) (UserInfo, error) {
+	review, err := a.kubeClient.AuthenticationV1().TokenReviews().Create(context.TODO(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: strings.TrimPrefix(token, "Bearer ")},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		klog.Errorf("failed to review token: %v", err)
+		return UserInfo{}, err
+	}
+	if !review.Status.Authenticated {
+		return UserInfo{}, fmt.Errorf("token is not authenticated: %s", review.Status.Error)
+	}
+	user := UserInfo{Name: review.Status.User.Username, Groups: review.Status.User.Groups, UID: review.Status.User.UID}
+	for k, v := range review.Status.User.Extra {
+		if user.Extra == nil {
+			user.Extra = map[string][]string{}
+		}
+		user.Extra[k] = v
+	}
+	return user, nil
+}
+
+// canGetNamespace returns whether user can get the namespace ns, or every namespace when ns
+// is empty.
+func (a kubernetesAuthorizer) canGetNamespace(user UserInfo, ns string) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := a.kubeClient.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Name,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			// The user can get the namespace of the managed cluster, as it could see the project on OpenShift.
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: ns,
+				Verb:      "get",
+				Resource:  "namespaces",
+				Name:      ns,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// Projects returns the namespaces of the managed clusters user can get. A single review is
+// sent for the users who can get every namespace, one per managed cluster otherwise. It fails
+// when any review fails, as the namespaces are cached for the token.
+func (a kubernetesAuthorizer) Projects(token string, user UserInfo) ([]string, error) {
+	namespaces := []string{}
+	for name := range GetAllManagedClusterNames() {
+		namespaces = append(namespaces, name)
+	}
+	sort.Strings(namespaces)
+
+	all, err := a.canGetNamespace(user, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to review access of user %s to all namespaces: %v", user.Name, err)
+	}
+	if all {
+		return namespaces, nil
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	projects := []string{}
+	errs := []error{}
+	reviews := make(chan struct{}, maxConcurrentReviews)
+	for _, ns := range namespaces {
+		wg.Add(1)
+		reviews <- struct{}{}
+		go func(ns string) {
+			defer func() {
+				<-reviews
+				wg.Done()
+			}()
+			allowed, err := a.canGetNamespace(user, ns)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to review access of user %s to namespace %s: %v", user.Name, ns, err))
+				return
+			}
+			if allowed {
+				projects = append(projects, ns)
+			}
+		}(ns)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	sort.Strings(projects)
+	return projects, nil
+}
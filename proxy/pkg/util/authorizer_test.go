@@ -0,0 +1,88 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesAuthorizer(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "alice-token" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: "alice", Groups: []string{"devs"}}
+		}
+		return true, review, nil
+	})
+	var reviews int32
+	kubeClient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&reviews, 1)
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		if review.Spec.User == "bob" && attributes.Namespace == "c1" {
+			return true, nil, errors.New("the server is currently unable to handle the request")
+		}
+		review.Status.Allowed = attributes.Verb == "get" && attributes.Resource == "namespaces" &&
+			attributes.Name == attributes.Namespace && ((review.Spec.User == "alice" && attributes.Namespace != "" &&
+			attributes.Namespace != "c2") || review.Spec.User == "admin")
+		return true, review, nil
+	})
+
+	allManagedClusterNames = map[string]string{"c0": "c0", "c1": "c1", "c2": "c2"}
+	defer InitAllManagedClusterNames()
+	a := NewKubernetesAuthorizer(kubeClient)
+
+	user, err := a.User("Bearer alice-token")
+	if err != nil {
+		t.Fatalf("failed to authenticate alice: %v", err)
+	}
+	if !reflect.DeepEqual(user, UserInfo{Name: "alice", Groups: []string{"devs"}}) {
+		t.Errorf("unexpected user %+v", user)
+	}
+	projects, err := a.Projects("alice-token", user)
+	if err != nil {
+		t.Fatalf("failed to get the projects of alice: %v", err)
+	}
+	if !reflect.DeepEqual(projects, []string{"c0", "c1"}) {
+		t.Errorf("expected alice to access the namespaces of c0 and c1, got %v", projects)
+	}
+
+	if _, err := a.User("unknown-token"); err == nil {
+		t.Error("expected an unknown token not to be authenticated")
+	}
+
+	// The users who can get every namespace are reviewed once.
+	atomic.StoreInt32(&reviews, 0)
+	projects, err = a.Projects("admin-token", UserInfo{Name: "admin"})
+	if err != nil || !reflect.DeepEqual(projects, []string{"c0", "c1", "c2"}) || atomic.LoadInt32(&reviews) != 1 {
+		t.Errorf("expected admin to access all the namespaces with a single review, got %v, %v after %d reviews",
+			projects, err, atomic.LoadInt32(&reviews))
+	}
+
+	// The projects are not returned partially when a review fails.
+	if projects, err := a.Projects("bob-token", UserInfo{Name: "bob"}); err == nil || projects != nil {
+		t.Errorf("expected the failed review to fail, got %v, %v", projects, err)
+	}
+}
+
+func TestNewAuthorizer(t *testing.T) {
+	if _, err := NewAuthorizer("ldap", "https://127.0.0.1:6443", fake.NewSimpleClientset()); err == nil {
+		t.Error("expected an unknown authorizer to be rejected")
+	}
+	if _, err := NewAuthorizer(KubernetesAuthorizer, "https://127.0.0.1:6443", fake.NewSimpleClientset()); err != nil {
+		t.Errorf("failed to create the kubernetes authorizer: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"k8s.io/klog"
+)
+
+const (
+	labelsPath          = "/api/v1/labels"
+	metricNameValuePath = "/api/v1/label/__name__/values"
+	metadataPath        = "/api/v1/metadata"
+	rulesPath           = "/api/v1/rules"
+	alertsPath          = "/api/v1/alerts"
+	exemplarsPath       = "/api/v1/query_exemplars"
+
+	// allSeriesSelector selects every series, it is scoped to the user like any series selector.
+	allSeriesSelector = `{__name__=~".+"}`
+)
+
+var labelValuesPathRegexp = regexp.MustCompile(`/api/v1/label/[^/]+/values$`)
+
+// addSeriesMatchers adds a series selector to the requests of the labels and label values
+// endpoints that have none, so that they only return the labels of the series the user can query.
+func addSeriesMatchers(path string, queryValues url.Values) url.Values {
+	if !strings.HasSuffix(path, labelsPath) && !labelValuesPathRegexp.MatchString(path) {
+		return queryValues
+	}
+	if len(queryValues["match[]"]) == 0 {
+		queryValues.Set("match[]", allSeriesSelector)
+	}
+	return queryValues
+}
+
+// ShouldFilterResponse returns whether the response of the endpoint of path must be filtered,
+// as the endpoint does not accept a series selector to scope it to the user.
+func ShouldFilterResponse(path string) bool {
+	for _, p := range []string{metadataPath, rulesPath, alertsPath, exemplarsPath} {
+		if strings.HasSuffix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterMetricsResponse returns a function that removes from the responses of the metadata,
+// rules, alerts and exemplars endpoints what the user of the request cannot query. The
+// metadata are filtered with the metric names the user can query, which are requested
+// with transport.
+func FilterMetricsResponse(transport http.RoundTripper) func(*http.Response) error {
+	return func(res *http.Response) error {
+		path := res.Request.URL.Path
+		if res.StatusCode != http.StatusOK || !ShouldFilterResponse(path) {
+			return nil
+		}
+		clusterList, all := getRequestClusterList(res.Request)
+		if all {
+			return nil
+		}
+		scopes := getRequestScopes(res.Request, clusterList)
+
+		body, err := readResponseBody(res)
+		if err != nil {
+			return err
+		}
+		response := map[string]json.RawMessage{}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return fmt.Errorf("failed to decode response json body: %v", err)
+		}
+		if _, ok := response["data"]; !ok {
+			return writeResponseBody(res, body)
+		}
+		var data interface{}
+		decoder := json.NewDecoder(bytes.NewReader(response["data"]))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
+			return fmt.Errorf("failed to decode response data: %v", err)
+		}
+
+		switch {
+		case strings.HasSuffix(path, metadataPath):
+			names, err := fetchMetricNames(res.Request, transport, scopes)
+			if err != nil {
+				return err
+			}
+			data = filterMetadata(data, names)
+		case strings.HasSuffix(path, rulesPath):
+			data = filterRules(data, scopes)
+		case strings.HasSuffix(path, alertsPath):
+			data = filterAlerts(data, scopes)
+		case strings.HasSuffix(path, exemplarsPath):
+			data = filterExemplars(data, scopes)
+		}
+
+		response["data"], err = json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		body, err = json.Marshal(response)
+		if err != nil {
+			return err
+		}
+		return writeResponseBody(res, body)
+	}
+}
+
+func readResponseBody(res *http.Response) ([]byte, error) {
+	defer res.Body.Close()
+	reader := io.Reader(res.Body)
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip response body: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return io.ReadAll(reader)
+}
+
+func writeResponseBody(res *http.Response, body []byte) error {
+	res.Header.Del("Content-Encoding")
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.ContentLength = int64(len(body))
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// fetchMetricNames returns the names of the metrics the scopes grant, with the label values
+// endpoint of the server req is sent to.
+func fetchMetricNames(req *http.Request, transport http.RoundTripper, scopes []labelScope) (map[string]bool, error) {
+	if len(scopes) == 0 {
+		return map[string]bool{}, nil
+	}
+	namesURL := *req.URL
+	namesURL.Path = strings.TrimSuffix(namesURL.Path, metadataPath) + metricNameValuePath
+	matches, err := rewriteScopedQuery(url.Values{"match[]": {allSeriesSelector}}, scopes, "match[]")
+	if err != nil {
+		return nil, err
+	}
+	namesURL.RawQuery = matches.Encode()
+
+	namesReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, namesURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	namesReq.Header = req.Header.Clone()
+	namesReq.Header.Del("Accept-Encoding")
+	namesReq.Header.Del("Content-Type")
+
+	res, err := transport.RoundTrip(namesReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request metric names: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to request metric names: %s", res.Status)
+	}
+
+	values := struct {
+		Data []string `json:"data"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("failed to decode metric names: %v", err)
+	}
+	names := map[string]bool{}
+	for _, name := range values.Data {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// filterMetadata keeps the metadata of the metrics of names.
+func filterMetadata(data interface{}, names map[string]bool) interface{} {
+	metadata, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	for name := range metadata {
+		if !names[name] {
+			delete(metadata, name)
+		}
+	}
+	return metadata
+}
+
+// filterRules keeps the rules whose labels the scopes grant, and their alerts the scopes grant.
+// A rule without cluster or namespace label applies to every cluster, it is kept.
+func filterRules(data interface{}, scopes []labelScope) interface{} {
+	rulesData, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	groups, _ := rulesData["groups"].([]interface{})
+	filteredGroups := []interface{}{}
+	for _, g := range groups {
+		group, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rules, _ := group["rules"].([]interface{})
+		filteredRules := []interface{}{}
+		for _, r := range rules {
+			rule, ok := r.(map[string]interface{})
+			if !ok || !scopesAllow(scopes, toLabels(rule["labels"]), true) {
+				continue
+			}
+			if alerts, ok := rule["alerts"].([]interface{}); ok {
+				rule["alerts"] = filterLabeled(alerts, "labels", scopes)
+			}
+			filteredRules = append(filteredRules, rule)
+		}
+		if len(rules) > 0 && len(filteredRules) == 0 {
+			continue
+		}
+		group["rules"] = filteredRules
+		filteredGroups = append(filteredGroups, group)
+	}
+	rulesData["groups"] = filteredGroups
+	return rulesData
+}
+
+// filterAlerts keeps the alerts the scopes grant.
+func filterAlerts(data interface{}, scopes []labelScope) interface{} {
+	alertsData, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	alerts, _ := alertsData["alerts"].([]interface{})
+	alertsData["alerts"] = filterLabeled(alerts, "labels", scopes)
+	return alertsData
+}
+
+// filterExemplars keeps the exemplars of the series the scopes grant.
+func filterExemplars(data interface{}, scopes []labelScope) interface{} {
+	series, ok := data.([]interface{})
+	if !ok {
+		return data
+	}
+	return filterLabeled(series, "seriesLabels", scopes)
+}
+
+// filterLabeled keeps the objects whose labels under key the scopes grant.
+func filterLabeled(objects []interface{}, key string, scopes []labelScope) []interface{} {
+	filtered := []interface{}{}
+	for _, o := range objects {
+		object, ok := o.(map[string]interface{})
+		if ok && scopesAllow(scopes, toLabels(object[key]), false) {
+			filtered = append(filtered, object)
+		}
+	}
+	return filtered
+}
+
+func toLabels(v interface{}) map[string]string {
+	lbls := map[string]string{}
+	m, _ := v.(map[string]interface{})
+	for name, value := range m {
+		if s, ok := value.(string); ok {
+			lbls[name] = s
+		}
+	}
+	return lbls
+}
+
+// scopesAllow returns whether any of the scopes grants lbls. With partial, the cluster and
+// namespace labels missing from lbls are not checked.
+func scopesAllow(scopes []labelScope, lbls map[string]string, partial bool) bool {
+	for _, s := range scopes {
+		if allowsValue(s.clusters, lbls, "cluster", partial) && allowsValue(s.namespaces, lbls, "namespace", partial) {
+			return true
+		}
+	}
+	klog.V(1).Infof("filtered out labels %v", lbls)
+	return false
+}
+
+func allowsValue(values []string, lbls map[string]string, name string, partial bool) bool {
+	if values == nil {
+		return true
+	}
+	value, ok := lbls[name]
+	if !ok {
+		return partial
+	}
+	return slices.Contains(values, value)
+}
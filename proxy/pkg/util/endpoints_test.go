@@ -0,0 +1,114 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func setUpPartialAccess() {
+	InitUserProjectInfo()
+	UpdateUserProject(NewUserProject("alice", "alice-token", []string{"c1"}))
+	allManagedClusterNames = map[string]string{"c1": "c1", "c2": "c2"}
+}
+
+func TestAddSeriesMatchers(t *testing.T) {
+	setUpPartialAccess()
+	defer InitAllManagedClusterNames()
+
+	for _, path := range []string{"/api/v1/labels", "/api/v1/label/namespace/values"} {
+		req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/api/metrics/v1/default"+path, nil)
+		req.Header.Set("X-Forwarded-Access-Token", "alice-token")
+		ModifyMetricsQueryParams(req)
+		match := req.URL.Query().Get("match[]")
+		if !strings.Contains(match, `__name__=~".+"`) || !strings.Contains(match, `cluster="c1"`) {
+			t.Errorf("expected %s to select the series of cluster c1, got %q", path, match)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/api/v1/label/namespace/values?match[]=up", nil)
+	req.Header.Set("X-Forwarded-Access-Token", "alice-token")
+	ModifyMetricsQueryParams(req)
+	if match := req.URL.Query()["match[]"]; len(match) != 1 || !strings.HasPrefix(match[0], "up{") {
+		t.Errorf("expected the series selector of the request to be scoped, got %v", match)
+	}
+}
+
+func filterResponse(t *testing.T, transport http.RoundTripper, url string, body string) map[string]interface{} {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-Forwarded-Access-Token", "alice-token")
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+	if err := FilterMetricsResponse(transport)(res); err != nil {
+		t.Fatalf("failed to filter response of %s: %v", url, err)
+	}
+	filtered := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&filtered); err != nil {
+		t.Fatalf("failed to decode filtered response of %s: %v", url, err)
+	}
+	return filtered
+}
+
+func TestFilterMetricsResponse(t *testing.T) {
+	setUpPartialAccess()
+	defer InitAllManagedClusterNames()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/label/__name__/values" || !strings.Contains(r.URL.Query().Get("match[]"), `cluster="c1"`) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":["up"]}`)
+	}))
+	defer server.Close()
+
+	filtered := filterResponse(t, http.DefaultTransport, server.URL+"/api/v1/metadata",
+		`{"status":"success","data":{"up":[{"type":"gauge"}],"secret_total":[{"type":"counter"}]}}`)
+	if data := filtered["data"].(map[string]interface{}); len(data) != 1 || data["up"] == nil {
+		t.Errorf("expected the metadata of up only, got %v", data)
+	}
+
+	filtered = filterResponse(t, http.DefaultTransport, server.URL+"/api/v1/rules", `{"status":"success","data":{"groups":[
+		{"name":"generic","rules":[{"type":"alerting","name":"Down","labels":{"severity":"critical"},"alerts":[
+			{"labels":{"alertname":"Down","cluster":"c1"}},
+			{"labels":{"alertname":"Down","cluster":"c2"}}]}]},
+		{"name":"c2","rules":[{"type":"recording","name":"c2:up","labels":{"cluster":"c2"}}]}]}}`)
+	groups := filtered["data"].(map[string]interface{})["groups"].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("expected the rules of cluster c2 to be removed, got %v", groups)
+	}
+	rule := groups[0].(map[string]interface{})["rules"].([]interface{})[0].(map[string]interface{})
+	expectedAlerts := []interface{}{map[string]interface{}{"labels": map[string]interface{}{"alertname": "Down", "cluster": "c1"}}}
+	if !reflect.DeepEqual(rule["alerts"], expectedAlerts) {
+		t.Errorf("expected the alerts of cluster c1 only, got %v", rule["alerts"])
+	}
+
+	filtered = filterResponse(t, http.DefaultTransport, server.URL+"/api/v1/query_exemplars", `{"status":"success","data":[
+		{"seriesLabels":{"__name__":"latency","cluster":"c1"},"exemplars":[{"value":"1","timestamp":1}]},
+		{"seriesLabels":{"__name__":"latency","cluster":"c2"},"exemplars":[{"value":"2","timestamp":1}]},
+		{"seriesLabels":{"__name__":"latency"},"exemplars":[{"value":"3","timestamp":1}]}]}`)
+	if data := filtered["data"].([]interface{}); len(data) != 1 {
+		t.Errorf("expected the exemplars of cluster c1 only, got %v", data)
+	}
+
+	// The users who can access all the clusters get the response unchanged.
+	UpdateUserProject(NewUserProject("alice", "alice-token", []string{"c1", "c2"}))
+	filtered = filterResponse(t, nil, server.URL+"/api/v1/metadata",
+		`{"status":"success","data":{"up":[{"type":"gauge"}],"secret_total":[{"type":"counter"}]}}`)
+	if data := filtered["data"].(map[string]interface{}); len(data) != 2 {
+		t.Errorf("expected all the metadata, got %v", data)
+	}
+}
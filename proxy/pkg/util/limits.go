@@ -0,0 +1,122 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
+)
+
+var (
+	queryLimits     proxyconfig.QueryLimits
+	queryLimitsLock sync.RWMutex
+)
+
+// SetQueryLimits replaces the query limits of the users.
+func SetQueryLimits(limits proxyconfig.QueryLimits) {
+	queryLimitsLock.Lock()
+	defer queryLimitsLock.Unlock()
+	queryLimits = limits
+}
+
+// unmarshalQueryLimits unmarshals and validates the query limits configmap data.
+func unmarshalQueryLimits(data map[string]string) (proxyconfig.QueryLimits, error) {
+	limits := proxyconfig.QueryLimits{}
+	if err := yaml.UnmarshalStrict([]byte(data[proxyconfig.QueryLimitsConfigMapKey]), &limits); err != nil {
+		return limits, err
+	}
+	if err := validateLimits(limits.Default); err != nil {
+		return limits, fmt.Errorf("invalid default limits: %v", err)
+	}
+	for i, o := range limits.Overrides {
+		if len(o.Users) == 0 && len(o.Groups) == 0 {
+			return limits, fmt.Errorf("users or groups are required for override %d", i)
+		}
+		if err := validateLimits(o.Limits); err != nil {
+			return limits, fmt.Errorf("invalid limits of override %d: %v", i, err)
+		}
+	}
+	return limits, nil
+}
+
+func validateLimits(limits proxyconfig.Limits) error {
+	if limits.RequestsPerSecond < 0 || limits.Burst < 0 || limits.MaxConcurrentQueries < 0 ||
+		limits.MaxQueryRangeSpan < 0 || limits.MaxSpanStepRatio < 0 || limits.MaxResponseBytes < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// GetQueryLimitsEventHandler return event handler functions for query limits configmap watch events.
+// An invalid configmap is logged and ignored, the users keep their limits.
+func GetQueryLimitsEventHandler() cache.ResourceEventHandlerFuncs {
+	update := func(obj interface{}) {
+		cm := obj.(*v1.ConfigMap)
+		limits, err := unmarshalQueryLimits(cm.Data)
+		if err != nil {
+			klog.Errorf("failed to unmarshal configmap <%s>: %v", cm.Name, err)
+			return
+		}
+		klog.Infof("updated query limits: %d overrides", len(limits.Overrides))
+		SetQueryLimits(limits)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+
+		DeleteFunc: func(obj interface{}) {
+			klog.Warningf("deleted configmap: %s", proxyconfig.QueryLimitsConfigMapName)
+			SetQueryLimits(proxyconfig.QueryLimits{})
+		},
+
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			update(newObj)
+		},
+	}
+}
+
+// WatchQueryLimits will watch and save the query limits configmap when create/update/delete.
+func WatchQueryLimits(kubeClient kubernetes.Interface) {
+	watchlist := cache.NewListWatchFromClient(kubeClient.CoreV1().RESTClient(), "configmaps",
+		proxyconfig.ManagedClusterLabelAllowListNamespace,
+		fields.OneTermEqualSelector("metadata.name", proxyconfig.QueryLimitsConfigMapName))
+
+	_, controller := cache.NewInformer(watchlist, &v1.ConfigMap{}, time.Second*0,
+		GetQueryLimitsEventHandler())
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	for {
+		time.Sleep(time.Second * 30)
+		queryLimitsLock.RLock()
+		klog.V(1).Infof("found %v query limits overrides", len(queryLimits.Overrides))
+		queryLimitsLock.RUnlock()
+	}
+}
+
+// GetUserLimits returns the limits of a user: the limits of the first override that lists the
+// user or one of its groups, the default limits otherwise.
+func GetUserLimits(userName string, groups []string) proxyconfig.Limits {
+	queryLimitsLock.RLock()
+	defer queryLimitsLock.RUnlock()
+
+	for _, o := range queryLimits.Overrides {
+		if slices.Contains(o.Users, userName) || containsAny(o.Groups, groups) {
+			return o.Limits
+		}
+	}
+	return queryLimits.Default
+}
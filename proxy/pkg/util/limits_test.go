@@ -0,0 +1,66 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
+)
+
+const limitsYAML = `default:
+  requestsPerSecond: 5
+  maxConcurrentQueries: 4
+  maxQueryRangeSpan: 7d
+overrides:
+- users:
+  - alice
+  limits:
+    requestsPerSecond: 20
+    maxResponseBytes: 1048576
+- groups:
+  - sre
+  limits: {}
+`
+
+func TestUnmarshalQueryLimits(t *testing.T) {
+	limits, err := unmarshalQueryLimits(map[string]string{proxyconfig.QueryLimitsConfigMapKey: limitsYAML})
+	if err != nil {
+		t.Fatalf("failed to unmarshal query limits: %v", err)
+	}
+	if limits.Default.MaxQueryRangeSpan != model.Duration(7*24*time.Hour) || len(limits.Overrides) != 2 {
+		t.Errorf("unexpected query limits: %+v", limits)
+	}
+
+	for _, data := range []string{
+		"default:\n  burst: -1",
+		"overrides:\n- limits:\n    burst: 1",
+		"overrides:\n- users: [alice]\n  limits:\n    maxConcurrentQueries: -1",
+		"default:\n  unknown: 1",
+	} {
+		if _, err := unmarshalQueryLimits(map[string]string{proxyconfig.QueryLimitsConfigMapKey: data}); err == nil {
+			t.Errorf("expected query limits %q to be rejected", data)
+		}
+	}
+}
+
+func TestGetUserLimits(t *testing.T) {
+	limits, _ := unmarshalQueryLimits(map[string]string{proxyconfig.QueryLimitsConfigMapKey: limitsYAML})
+	SetQueryLimits(limits)
+	defer SetQueryLimits(proxyconfig.QueryLimits{})
+
+	if l := GetUserLimits("alice", []string{"sre"}); l.RequestsPerSecond != 20 || l.MaxConcurrentQueries != 0 {
+		t.Errorf("expected the limits of alice to replace the default limits, got %+v", l)
+	}
+	if l := GetUserLimits("bob", []string{"sre"}); l != (proxyconfig.Limits{}) {
+		t.Errorf("expected the members of sre to be unlimited, got %+v", l)
+	}
+	if l := GetUserLimits("carol", nil); l != limits.Default {
+		t.Errorf("expected the default limits, got %+v", l)
+	}
+}
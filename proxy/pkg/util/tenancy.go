@@ -0,0 +1,370 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
+	"github.com/stolostron/multicluster-observability-operator/proxy/pkg/rewrite"
+)
+
+// ErrNothingGranted is returned when a query is scoped for a user granted no cluster and no
+// namespace.
+var ErrNothingGranted = errors.New("no cluster or namespace is granted to the user")
+
+var (
+	tenancyMode = proxyconfig.ClusterTenancyMode
+
+	namespaceTenancy     proxyconfig.NamespaceTenancy
+	namespaceTenancyLock sync.RWMutex
+)
+
+// labelScope is a set of clusters and namespaces a user can query together. Nil clusters
+// stand for every cluster, nil namespaces for every namespace.
+type labelScope struct {
+	clusters   []string
+	namespaces []string
+}
+
+// SetTenancyMode sets how the queries of the users are scoped, by cluster or by namespace.
+func SetTenancyMode(mode string) error {
+	if mode != proxyconfig.ClusterTenancyMode && mode != proxyconfig.NamespaceTenancyMode {
+		return fmt.Errorf("unknown tenancy mode %s, must be %s or %s", mode,
+			proxyconfig.ClusterTenancyMode, proxyconfig.NamespaceTenancyMode)
+	}
+	tenancyMode = mode
+	return nil
+}
+
+// IsNamespaceTenancy returns whether the users can be granted access to namespaces of the
+// managed clusters.
+func IsNamespaceTenancy() bool {
+	return tenancyMode == proxyconfig.NamespaceTenancyMode
+}
+
+// SetNamespaceTenancy replaces the tenants of the namespace tenancy.
+func SetNamespaceTenancy(tenancy proxyconfig.NamespaceTenancy) {
+	namespaceTenancyLock.Lock()
+	defer namespaceTenancyLock.Unlock()
+	namespaceTenancy = tenancy
+}
+
+// unmarshalNamespaceTenancy unmarshals and validates the namespace tenancy configmap data.
+func unmarshalNamespaceTenancy(data map[string]string) (proxyconfig.NamespaceTenancy, error) {
+	tenancy := proxyconfig.NamespaceTenancy{}
+	if err := yaml.UnmarshalStrict([]byte(data[proxyconfig.NamespaceTenancyConfigMapKey]), &tenancy); err != nil {
+		return tenancy, err
+	}
+	for _, t := range tenancy.Tenants {
+		if len(t.Name) == 0 {
+			return tenancy, errors.New("a tenant name is required")
+		}
+		if len(t.Users) == 0 && len(t.Groups) == 0 {
+			return tenancy, fmt.Errorf("users or groups are required for tenant %s", t.Name)
+		}
+		for _, c := range t.Clusters {
+			if len(c.Name) == 0 || len(c.Namespaces) == 0 {
+				return tenancy, fmt.Errorf("clusters of tenant %s must have a name and namespaces", t.Name)
+			}
+		}
+	}
+	return tenancy, nil
+}
+
+// GetNamespaceTenancyEventHandler return event handler functions for namespace tenancy configmap watch events.
+// An invalid configmap is logged and ignored, the users keep the namespaces they were granted.
+func GetNamespaceTenancyEventHandler() cache.ResourceEventHandlerFuncs {
+	update := func(obj interface{}) {
+		cm := obj.(*v1.ConfigMap)
+		tenancy, err := unmarshalNamespaceTenancy(cm.Data)
+		if err != nil {
+			klog.Errorf("failed to unmarshal configmap <%s>: %v", cm.Name, err)
+			return
+		}
+		klog.Infof("updated namespace tenancy: %d tenants", len(tenancy.Tenants))
+		SetNamespaceTenancy(tenancy)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+
+		DeleteFunc: func(obj interface{}) {
+			klog.Warningf("deleted configmap: %s", proxyconfig.NamespaceTenancyConfigMapName)
+			SetNamespaceTenancy(proxyconfig.NamespaceTenancy{})
+		},
+
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			update(newObj)
+		},
+	}
+}
+
+// WatchNamespaceTenancy will watch and save the namespace tenancy configmap when create/update/delete.
+func WatchNamespaceTenancy(kubeClient kubernetes.Interface) {
+	watchlist := cache.NewListWatchFromClient(kubeClient.CoreV1().RESTClient(), "configmaps",
+		proxyconfig.ManagedClusterLabelAllowListNamespace,
+		fields.OneTermEqualSelector("metadata.name", proxyconfig.NamespaceTenancyConfigMapName))
+
+	_, controller := cache.NewInformer(watchlist, &v1.ConfigMap{}, time.Second*0,
+		GetNamespaceTenancyEventHandler())
+
+	stop := make(chan struct{})
+	go controller.Run(stop)
+	for {
+		time.Sleep(time.Second * 30)
+		namespaceTenancyLock.RLock()
+		klog.V(1).Infof("found %v tenants", len(namespaceTenancy.Tenants))
+		namespaceTenancyLock.RUnlock()
+	}
+}
+
+// getUserNamespaces returns the namespaces the tenants of a user grant, by cluster name.
+func getUserNamespaces(userName string, groups []string) map[string][]string {
+	namespaceTenancyLock.RLock()
+	defer namespaceTenancyLock.RUnlock()
+
+	namespaces := map[string][]string{}
+	for _, t := range namespaceTenancy.Tenants {
+		if !slices.Contains(t.Users, userName) && !containsAny(t.Groups, groups) {
+			continue
+		}
+		for _, c := range t.Clusters {
+			for _, ns := range c.Namespaces {
+				if !slices.Contains(namespaces[c.Name], ns) {
+					namespaces[c.Name] = append(namespaces[c.Name], ns)
+				}
+			}
+		}
+	}
+	for _, ns := range namespaces {
+		sort.Strings(ns)
+	}
+	return namespaces
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, c := range candidates {
+		if slices.Contains(values, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasNamespaceAccess returns whether the namespace tenancy grants a user access to any namespace.
+func HasNamespaceAccess(userName string, groups []string) bool {
+	return len(getUserNamespaces(userName, groups)) > 0
+}
+
+// getUserScopes returns the scopes a user can query: the clusters of clusterList with all
+// their namespaces, and the namespaces granted on the other clusters. The clusters granted
+// the same namespaces share a scope.
+func getUserScopes(clusterList []string, namespaces map[string][]string) []labelScope {
+	scopes := []labelScope{}
+	if len(clusterList) > 0 {
+		scopes = append(scopes, labelScope{clusters: clusterList})
+	}
+
+	byNamespaces := map[string]*labelScope{}
+	keys := []string{}
+	for cluster, ns := range namespaces {
+		if slices.Contains(clusterList, cluster) {
+			continue
+		}
+		key := strings.Join(ns, "|")
+		if cluster == proxyconfig.AllClusters {
+			// The namespaces granted on every cluster have a scope of their own.
+			key = proxyconfig.AllClusters + key
+		}
+		scope, ok := byNamespaces[key]
+		if !ok {
+			scope = &labelScope{namespaces: ns}
+			byNamespaces[key] = scope
+			keys = append(keys, key)
+		}
+		if cluster != proxyconfig.AllClusters {
+			scope.clusters = append(scope.clusters, cluster)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sort.Strings(byNamespaces[key].clusters)
+		scopes = append(scopes, *byNamespaces[key])
+	}
+	return scopes
+}
+
+// rewriteScopedQuery injects the cluster and namespace matchers of the scopes into the values
+// of key. The scopes are merged into a single set of matchers when they grant the same
+// namespaces, or the same clusters. Otherwise a query becomes the union of the query of every
+// scope, and must keep the cluster and namespace labels for the union to be its result. A
+// series selector is repeated for every scope.
+func rewriteScopedQuery(queryValues url.Values, scopes []labelScope, key string) (url.Values, error) {
+	originalQueries := queryValues[key]
+	if len(originalQueries) == 0 {
+		return queryValues, nil
+	}
+	if len(scopes) == 0 {
+		// An empty cluster matcher would still match the series without cluster label.
+		return queryValues, ErrNothingGranted
+	}
+	if scope, ok := mergeScopes(scopes); ok {
+		scopes = []labelScope{scope}
+	}
+
+	modifiedQueries := []string{}
+	for _, originalQuery := range originalQueries {
+		if key == "query" && len(scopes) > 1 {
+			if err := checkScopesUnion(originalQuery); err != nil {
+				return queryValues, err
+			}
+		}
+		queries := []string{}
+		for _, scope := range scopes {
+			query, err := injectScope(originalQuery, scope)
+			if err != nil {
+				return queryValues, err
+			}
+			queries = append(queries, query)
+		}
+		if key != "query" {
+			modifiedQueries = append(modifiedQueries, queries...)
+			continue
+		}
+		if len(queries) == 1 {
+			modifiedQueries = append(modifiedQueries, queries[0])
+			continue
+		}
+		modifiedQueries = append(modifiedQueries, "("+strings.Join(queries, ") or (")+")")
+	}
+
+	queryValues.Del(key)
+	for _, query := range modifiedQueries {
+		queryValues.Add(key, query)
+	}
+	return queryValues, nil
+}
+
+// mergeScopes returns the scope that selects the same series as scopes, when they all have
+// the same namespaces or the same clusters.
+func mergeScopes(scopes []labelScope) (labelScope, bool) {
+	sameClusters, sameNamespaces := true, true
+	for _, scope := range scopes[1:] {
+		sameClusters = sameClusters && sameValues(scope.clusters, scopes[0].clusters)
+		sameNamespaces = sameNamespaces && sameValues(scope.namespaces, scopes[0].namespaces)
+	}
+	merged := scopes[0]
+	switch {
+	case sameNamespaces:
+		for _, scope := range scopes[1:] {
+			merged.clusters = unionValues(merged.clusters, scope.clusters)
+		}
+	case sameClusters:
+		for _, scope := range scopes[1:] {
+			merged.namespaces = unionValues(merged.namespaces, scope.namespaces)
+		}
+	default:
+		return labelScope{}, false
+	}
+	return merged, true
+}
+
+// sameValues returns whether a and b hold the same values, nil standing for every value.
+func sameValues(a, b []string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	sort.Strings(a)
+	sort.Strings(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+// unionValues returns the sorted values of a and b, nil standing for every value.
+func unionValues(a, b []string) []string {
+	if a == nil || b == nil {
+		return nil
+	}
+	union := append(slices.Clone(a), b...)
+	sort.Strings(union)
+	return slices.Compact(union)
+}
+
+// checkScopesUnion returns an error when the union of the results of query for several scopes
+// is not the result of query: when query does not return series, or when its aggregations,
+// vector matchings or absent functions drop the cluster or namespace labels, as each scope
+// then computes a partial result and the union keeps one of them.
+func checkScopesUnion(query string) error {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		// The metrics server rejects the invalid queries.
+		return nil
+	}
+	const msg = "the clusters granted different namespaces are queried separately"
+	if expr.Type() != parser.ValueTypeVector {
+		return fmt.Errorf("%s, the query must return an instant vector", msg)
+	}
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.AggregateExpr:
+			if !keepsScopeLabels(n.Grouping, n.Without) {
+				err = fmt.Errorf("%s, the %s aggregation must keep the cluster and namespace labels", msg, n.Op)
+			}
+		case *parser.BinaryExpr:
+			m := n.VectorMatching
+			if m != nil && m.Card == parser.CardOneToOne && n.LHS.Type() == parser.ValueTypeVector &&
+				n.RHS.Type() == parser.ValueTypeVector && !keepsScopeLabels(m.MatchingLabels, !m.On) {
+				err = fmt.Errorf("%s, the %s operation must keep the cluster and namespace labels", msg, n.Op)
+			}
+		case *parser.Call:
+			if n.Func.Name == "absent" || n.Func.Name == "absent_over_time" {
+				err = fmt.Errorf("%s, %s is not supported", msg, n.Func.Name)
+			}
+		}
+		return err
+	})
+	return err
+}
+
+// keepsScopeLabels returns whether the grouping of an aggregation or vector matching keeps
+// the cluster and namespace labels: when both are listed, or neither when without is set.
+func keepsScopeLabels(grouping []string, without bool) bool {
+	if without {
+		return !slices.Contains(grouping, "cluster") && !slices.Contains(grouping, "namespace")
+	}
+	return slices.Contains(grouping, "cluster") && slices.Contains(grouping, "namespace")
+}
+
+func injectScope(query string, scope labelScope) (string, error) {
+	var err error
+	if scope.clusters != nil {
+		query, err = rewrite.InjectLabels(query, "cluster", scope.clusters)
+		if err != nil {
+			return "", err
+		}
+	}
+	if scope.namespaces != nil {
+		query, err = rewrite.InjectLabels(query, "namespace", scope.namespaces)
+		if err != nil {
+			return "", err
+		}
+	}
+	return query, nil
+}
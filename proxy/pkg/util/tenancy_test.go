@@ -0,0 +1,233 @@
+// Copyright (c) Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project
+// Licensed under the Apache License 2.0
+
+package util
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	proxyconfig "github.com/stolostron/multicluster-observability-operator/proxy/pkg/config"
+)
+
+const tenancyYAML = `tenants:
+- name: payments
+  users:
+  - alice
+  groups:
+  - payments-devs
+  clusters:
+  - name: c1
+    namespaces:
+    - payments
+    - payments-staging
+  - name: c2
+    namespaces:
+    - payments
+    - payments-staging
+- name: shared
+  groups:
+  - everyone
+  clusters:
+  - name: "*"
+    namespaces:
+    - shared
+`
+
+func TestUnmarshalNamespaceTenancy(t *testing.T) {
+	tenancy, err := unmarshalNamespaceTenancy(map[string]string{proxyconfig.NamespaceTenancyConfigMapKey: tenancyYAML})
+	if err != nil {
+		t.Fatalf("failed to unmarshal namespace tenancy: %v", err)
+	}
+	if len(tenancy.Tenants) != 2 || len(tenancy.Tenants[0].Clusters) != 2 {
+		t.Errorf("unexpected namespace tenancy: %+v", tenancy)
+	}
+
+	for _, data := range []string{
+		"tenants:\n- users: [alice]\n  clusters: [{name: c1, namespaces: [a]}]",
+		"tenants:\n- name: t\n  clusters: [{name: c1, namespaces: [a]}]",
+		"tenants:\n- name: t\n  users: [alice]\n  clusters: [{name: c1}]",
+		"tenants:\n- name: t\n  unknown: true",
+	} {
+		if _, err := unmarshalNamespaceTenancy(map[string]string{proxyconfig.NamespaceTenancyConfigMapKey: data}); err == nil {
+			t.Errorf("expected namespace tenancy %q to be rejected", data)
+		}
+	}
+}
+
+func TestGetUserScopes(t *testing.T) {
+	tenancy, _ := unmarshalNamespaceTenancy(map[string]string{proxyconfig.NamespaceTenancyConfigMapKey: tenancyYAML})
+	SetNamespaceTenancy(tenancy)
+	defer SetNamespaceTenancy(proxyconfig.NamespaceTenancy{})
+
+	if HasNamespaceAccess("bob", []string{"system:authenticated"}) {
+		t.Error("expected bob not to be granted any namespace")
+	}
+
+	// Alice can see c2 on the hub, so the namespaces granted on c2 do not restrict her.
+	scopes := getUserScopes([]string{"c2"}, getUserNamespaces("alice", []string{"everyone"}))
+	expected := []labelScope{
+		{clusters: []string{"c2"}},
+		{namespaces: []string{"shared"}},
+		{clusters: []string{"c1"}, namespaces: []string{"payments", "payments-staging"}},
+	}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Errorf("expected scopes %+v, got %+v", expected, scopes)
+	}
+
+	// The members of the group are granted the same namespaces on both clusters.
+	scopes = getUserScopes(nil, getUserNamespaces("carol", []string{"payments-devs"}))
+	expected = []labelScope{{clusters: []string{"c1", "c2"}, namespaces: []string{"payments", "payments-staging"}}}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Errorf("expected scopes %+v, got %+v", expected, scopes)
+	}
+}
+
+// evaluateAt returns the value of every series of the instant query at 0, by labels.
+func evaluateAt(t *testing.T, test *promql.Test, query string) map[string]float64 {
+	q, err := test.QueryEngine().NewInstantQuery(test.Queryable(), nil, query, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed to create query %s: %v", query, err)
+	}
+	defer q.Close()
+	vector, err := q.Exec(test.Context()).Vector()
+	if err != nil {
+		t.Fatalf("failed to evaluate query %s: %v", query, err)
+	}
+	values := map[string]float64{}
+	for _, s := range vector {
+		values[s.Metric.String()] = s.V
+	}
+	return values
+}
+
+func TestRewriteScopedQuery(t *testing.T) {
+	test, err := promql.NewTest(t, `
+load 1m
+	foo{cluster="c1", namespace="payments", pod="a"} 1
+	foo{cluster="c1", namespace="payments", pod="b"} 2
+	foo{cluster="c1", namespace="other", pod="a"} 10
+	foo{cluster="c2", namespace="payments", pod="a"} 100
+	foo{cluster="c2", namespace="shared", pod="a"} 1000
+	foo{cluster="c3", namespace="shared", pod="a"} 10000
+`)
+	if err != nil {
+		t.Fatalf("failed to create test: %v", err)
+	}
+	defer test.Close()
+	if err := test.Run(); err != nil {
+		t.Fatalf("failed to load series: %v", err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		scopes   []labelScope
+		query    string
+		expected map[string]float64
+	}{
+		{
+			name: "namespaces granted on several clusters are merged",
+			scopes: []labelScope{
+				{clusters: []string{"c1"}, namespaces: []string{"payments"}},
+				{clusters: []string{"c2"}, namespaces: []string{"payments"}},
+			},
+			query:    "sum(foo)",
+			expected: map[string]float64{"{}": 103},
+		},
+		{
+			name: "clusters granted several namespaces are merged",
+			scopes: []labelScope{
+				{clusters: []string{"c2"}, namespaces: []string{"payments"}},
+				{clusters: []string{"c2"}, namespaces: []string{"shared"}},
+			},
+			query:    "sum(foo)",
+			expected: map[string]float64{"{}": 1100},
+		},
+		{
+			name:     "the namespaces granted on every cluster are merged with a cluster",
+			scopes:   []labelScope{{clusters: []string{"c1"}, namespaces: []string{"shared"}}, {namespaces: []string{"shared"}}},
+			query:    "sum(foo)",
+			expected: map[string]float64{"{}": 11000},
+		},
+		{
+			name:   "aggregations keeping the scope labels are computed for every scope",
+			scopes: []labelScope{{clusters: []string{"c2"}}, {clusters: []string{"c1"}, namespaces: []string{"payments"}}},
+			query:  "sum by (cluster, namespace) (foo)",
+			expected: map[string]float64{
+				`{cluster="c1", namespace="payments"}`: 3,
+				`{cluster="c2", namespace="payments"}`: 100,
+				`{cluster="c2", namespace="shared"}`:   1000,
+			},
+		},
+		{
+			name:   "series are selected in every scope",
+			scopes: []labelScope{{clusters: []string{"c2"}}, {clusters: []string{"c1"}, namespaces: []string{"payments"}}},
+			query:  "sum without (pod) (foo) > 1",
+			expected: map[string]float64{
+				`{cluster="c1", namespace="payments"}`: 3,
+				`{cluster="c2", namespace="payments"}`: 100,
+				`{cluster="c2", namespace="shared"}`:   1000,
+			},
+		},
+	} {
+		values, err := rewriteScopedQuery(url.Values{"query": {c.query}}, c.scopes, "query")
+		if err != nil {
+			t.Errorf("%s: failed to rewrite query: %v", c.name, err)
+			continue
+		}
+		if got := evaluateAt(t, test, values.Get("query")); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %v, got %v for query %s", c.name, c.expected, got, values.Get("query"))
+		}
+	}
+
+	// The queries whose union across the scopes is not their result are rejected.
+	scopes := []labelScope{{clusters: []string{"c2"}}, {clusters: []string{"c1"}, namespaces: []string{"payments"}}}
+	for _, query := range []string{
+		"sum(foo)",
+		"sum by (namespace) (foo)",
+		"count without (cluster) (foo)",
+		"foo / ignoring (namespace) foo",
+		"absent(foo)",
+		"foo[5m]",
+		"scalar(foo)",
+	} {
+		if _, err := rewriteScopedQuery(url.Values{"query": {query}}, scopes, "query"); err == nil {
+			t.Errorf("expected query %s to be rejected", query)
+		}
+	}
+
+	// The queries of a user granted nothing are rejected, an empty cluster matcher would
+	// still select the series without cluster label.
+	for _, key := range []string{"query", "match[]"} {
+		if _, err := rewriteScopedQuery(url.Values{key: {"foo"}}, nil, key); !errors.Is(err, ErrNothingGranted) {
+			t.Errorf("expected the %s of a user granted nothing to be rejected, got %v", key, err)
+		}
+	}
+
+	values, err := rewriteScopedQuery(url.Values{"match[]": {"foo", "bar"}}, scopes, "match[]")
+	if err != nil {
+		t.Fatalf("failed to rewrite series selectors: %v", err)
+	}
+	if matches := values["match[]"]; len(matches) != 4 {
+		t.Errorf("expected every series selector to be repeated for both scopes, got %v", matches)
+	}
+}
+
+func TestMergeScopes(t *testing.T) {
+	if _, ok := mergeScopes([]labelScope{
+		{clusters: []string{"c2"}},
+		{clusters: []string{"c1"}, namespaces: []string{"payments"}},
+	}); ok {
+		t.Error("expected the scopes granting different namespaces on different clusters not to be merged")
+	}
+	scope, ok := mergeScopes([]labelScope{{clusters: []string{"c2"}}, {clusters: []string{"c1", "c2"}}})
+	if expected := (labelScope{clusters: []string{"c1", "c2"}}); !ok || !reflect.DeepEqual(scope, expected) {
+		t.Errorf("expected scope %+v, got %+v", expected, scope)
+	}
+}
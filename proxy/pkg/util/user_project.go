@@ -5,6 +5,7 @@
 package util
 
 import (
+	"reflect"
 	"sync"
 	"time"
 
@@ -23,6 +24,8 @@ type UserProject struct {
 	Timestamp   int64
 	Token       string
 	ProjectList []string
+	// User is the user authenticated by the Authorizer, nil until it is fetched.
+	User *UserInfo
 }
 
 func InitUserProjectInfo() {
@@ -61,6 +64,39 @@ func GetUserProjectList(token string) ([]string, bool) {
 	return []string{}, false
 }
 
+// GetUserProjectListOfUser returns the cached project list of another token of user.
+func GetUserProjectListOfUser(user UserInfo) ([]string, bool) {
+	userProjectInfo.Lock()
+	defer userProjectInfo.Unlock()
+	for _, up := range userProjectInfo.ProjectInfo {
+		if up.User != nil && reflect.DeepEqual(*up.User, user) {
+			return up.ProjectList, true
+		}
+	}
+	return []string{}, false
+}
+
+// UpdateUserInfo caches the authenticated user of token along with its projects.
+func UpdateUserInfo(token string, user UserInfo) {
+	userProjectInfo.Lock()
+	if up, ok := userProjectInfo.ProjectInfo[token]; ok {
+		up.User = &user
+		userProjectInfo.ProjectInfo[token] = up
+	}
+	userProjectInfo.Unlock()
+}
+
+// GetUserInfo returns the cached authenticated user of token.
+func GetUserInfo(token string) (UserInfo, bool) {
+	userProjectInfo.Lock()
+	up, ok := userProjectInfo.ProjectInfo[token]
+	userProjectInfo.Unlock()
+	if ok && up.User != nil {
+		return *up.User, true
+	}
+	return UserInfo{}, false
+}
+
 func CleanExpiredProjectInfoJob(expiredTimeSeconds int64) {
 	InitUserProjectInfo()
 	ticker := time.NewTicker(time.Duration(time.Second * time.Duration(expiredTimeSeconds)))
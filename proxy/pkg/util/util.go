@@ -19,6 +19,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-co-op/gocron"
@@ -50,6 +51,9 @@ var (
 
 	managedLabelList = proxyconfig.GetManagedClusterLabelList()
 	syncLabelList    = proxyconfig.GetSyncLabelList()
+
+	apiServerTransport     *http.Transport
+	apiServerTransportLock sync.Mutex
 )
 
 // resources for the gocron scheduler.
@@ -170,37 +174,36 @@ func updateAllManagedClusterLabelNames(managedLabelList *proxyconfig.ManagedClus
 	}
 }
 
-// ModifyMetricsQueryParams will modify request url params for query metrics.
-func ModifyMetricsQueryParams(req *http.Request, reqUrl string) {
+// ModifyMetricsQueryParams will modify request url params for query metrics. It returns an
+// error when a query cannot be scoped to the clusters and namespaces of the user.
+func ModifyMetricsQueryParams(req *http.Request) error {
 	userName := req.Header.Get("X-Forwarded-User")
 	klog.V(1).Infof("user is %v", userName)
 	klog.V(1).Infof("URL is: %s", req.URL)
 	klog.V(1).Infof("URL path is: %v", req.URL.Path)
 	klog.V(1).Infof("URL RawQuery is: %v", req.URL.RawQuery)
-	token := req.Header.Get("X-Forwarded-Access-Token")
-	if token == "" {
-		klog.Errorf("failed to get token from http header")
-	}
-
-	projectList, ok := GetUserProjectList(token)
-	klog.V(1).Infof("projectList from local mem cache = %v, ok = %v", projectList, ok)
-	if !ok {
-		projectList = FetchUserProjectList(token, reqUrl)
-		up := NewUserProject(userName, token, projectList)
-		UpdateUserProject(up)
-		klog.V(1).Infof("projectList from api server = %v", projectList)
-	}
 
-	klog.V(1).Infof("cluster list: %v", allManagedClusterNames)
-	klog.V(1).Infof("user <%s> project list: %v", userName, projectList)
-	if canAccessAllClusters(projectList) {
+	clusterList, all := getRequestClusterList(req)
+	if all {
 		klog.Infof("user <%v> have access to all clusters", userName)
-		return
+		return nil
 	}
-
-	clusterList := getUserClusterList(projectList)
 	klog.Infof("user <%v> have access to these clusters: %v", userName, clusterList)
 
+	rewriteQueries := func(queryValues url.Values) (url.Values, error) {
+		if IsNamespaceTenancy() {
+			scopes := getRequestScopes(req, clusterList)
+			klog.Infof("user <%v> have access to these clusters and namespaces: %+v", userName, scopes)
+			queryValues, err := rewriteScopedQuery(queryValues, scopes, "query")
+			if err != nil {
+				return nil, err
+			}
+			return rewriteScopedQuery(queryValues, scopes, "match[]")
+		}
+		queryValues = rewriteQuery(queryValues, clusterList, "query")
+		return rewriteQuery(queryValues, clusterList, "match[]"), nil
+	}
+
 	var rawQuery string
 	if req.Method == "POST" {
 		body, _ := io.ReadAll(req.Body)
@@ -208,24 +211,29 @@ func ModifyMetricsQueryParams(req *http.Request, reqUrl string) {
 		queryValues, err := url.ParseQuery(string(body))
 		if err != nil {
 			klog.Errorf("Failed to parse request body: %v", err)
-			return
+			return nil
 		}
+		queryValues = addSeriesMatchers(req.URL.Path, queryValues)
 		if len(queryValues) == 0 {
-			return
+			return nil
+		}
+		queryValues, err = rewriteQueries(queryValues)
+		if err != nil {
+			return err
 		}
-		queryValues = rewriteQuery(queryValues, clusterList, "query")
-		queryValues = rewriteQuery(queryValues, clusterList, "match[]")
 		rawQuery = queryValues.Encode()
 		req.Body = io.NopCloser(strings.NewReader(rawQuery))
 		req.Header.Set("Content-Length", fmt.Sprint(len([]rune(rawQuery))))
 		req.ContentLength = int64(len([]rune(rawQuery)))
 	} else {
-		queryValues := req.URL.Query()
+		queryValues := addSeriesMatchers(req.URL.Path, req.URL.Query())
 		if len(queryValues) == 0 {
-			return
+			return nil
+		}
+		queryValues, err := rewriteQueries(queryValues)
+		if err != nil {
+			return err
 		}
-		queryValues = rewriteQuery(queryValues, clusterList, "query")
-		queryValues = rewriteQuery(queryValues, clusterList, "match[]")
 		req.URL.RawQuery = queryValues.Encode()
 		rawQuery = req.URL.RawQuery
 	}
@@ -234,6 +242,7 @@ func ModifyMetricsQueryParams(req *http.Request, reqUrl string) {
 	klog.V(1).Infof("URL is: %s", req.URL)
 	klog.V(1).Infof("URL path is: %v", req.URL.Path)
 	klog.V(1).Infof("URL RawQuery is: %v", rawQuery)
+	return nil
 }
 
 // GetManagedClusterEventHandler return event handler functions for managed cluster watch events.
@@ -384,15 +393,31 @@ func sendHTTPRequest(url string, verb string, token string) (*http.Response, err
 	}
 
 	if len(token) == 0 {
-		transport := &http.Transport{}
-		defaultClient := &http.Client{Transport: transport}
-		return defaultClient.Do(req)
+		return http.DefaultClient.Do(req)
 	}
 
 	if !strings.HasPrefix(token, "Bearer ") {
 		token = "Bearer " + token
 	}
 	req.Header.Set("Authorization", token)
+	tr, err := getAPIServerTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Transport: tr}
+	return client.Do(req)
+}
+
+// getAPIServerTransport returns the transport to the API server. It is created once, so that
+// the connections of the user and project lookups are kept alive.
+func getAPIServerTransport() (*http.Transport, error) {
+	apiServerTransportLock.Lock()
+	defer apiServerTransportLock.Unlock()
+	if apiServerTransport != nil {
+		return apiServerTransport, nil
+	}
+
 	caCert, err := os.ReadFile(filepath.Clean(caPath))
 	if err != nil {
 		klog.Error("failed to load root ca cert file")
@@ -402,17 +427,16 @@ func sendHTTPRequest(url string, verb string, token string) (*http.Response, err
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM(caCert)
 
-	tr := &http.Transport{
+	apiServerTransport = &http.Transport{
 		TLSClientConfig: &tls.Config{
 			RootCAs:    caCertPool,
 			MinVersion: tls.VersionTLS12,
 		},
-		MaxIdleConns:    100,
-		IdleConnTimeout: 60 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     60 * time.Second,
 	}
-
-	client := http.Client{Transport: tr}
-	return client.Do(req)
+	return apiServerTransport, nil
 }
 
 func FetchUserProjectList(token string, url string) []string {
@@ -451,14 +475,23 @@ func FetchUserProjectList(token string, url string) []string {
 }
 
 func GetUserName(token string, url string) string {
+	user, err := fetchUser(token, url)
+	if err != nil {
+		return ""
+	}
+	return user.Name
+}
+
+// fetchUser returns the OpenShift user of token.
+func fetchUser(token string, url string) (userv1.User, error) {
+	user := userv1.User{}
 	resp, err := sendHTTPRequest(url, "GET", token)
 	if err != nil {
 		klog.Errorf("failed to send http request: %v", err)
 		writeError(fmt.Sprintf("failed to send http request: %v", err))
-		return ""
+		return user, err
 	}
 
-	user := userv1.User{}
 	defer func() {
 		err := resp.Body.Close()
 		if err != nil {
@@ -469,10 +502,56 @@ func GetUserName(token string, url string) string {
 	err = json.NewDecoder(resp.Body).Decode(&user)
 	if err != nil {
 		klog.Errorf("failed to decode response json body: %v", err)
-		return ""
+		return user, err
 	}
 
-	return user.Name
+	return user, nil
+}
+
+// getRequestClusterList returns the clusters the user of req can query, and whether it can
+// query all the clusters.
+func getRequestClusterList(req *http.Request) ([]string, bool) {
+	userName := req.Header.Get("X-Forwarded-User")
+	token := req.Header.Get("X-Forwarded-Access-Token")
+	if token == "" {
+		klog.Errorf("failed to get token from http header")
+	}
+
+	projectList, ok := GetUserProjectList(token)
+	klog.V(1).Infof("projectList from local mem cache = %v, ok = %v", projectList, ok)
+	if !ok {
+		user, err := GetAuthorizer().User(token)
+		if err == nil {
+			projectList, err = GetAuthorizer().Projects(token, user)
+		}
+		if err != nil {
+			// The projects are not cached, the user gets no cluster until they can be fetched.
+			klog.Errorf("failed to get the projects of user <%s>: %v", userName, err)
+			projectList = []string{}
+		} else {
+			up := NewUserProject(user.Name, token, projectList)
+			up.User = &user
+			UpdateUserProject(up)
+		}
+		klog.V(1).Infof("projectList from api server = %v", projectList)
+	}
+
+	klog.V(1).Infof("cluster list: %v", allManagedClusterNames)
+	klog.V(1).Infof("user <%s> project list: %v", userName, projectList)
+	if canAccessAllClusters(projectList) {
+		return nil, true
+	}
+	return getUserClusterList(projectList), false
+}
+
+// getRequestScopes returns the scopes the user of req can query, given the clusters it can query.
+func getRequestScopes(req *http.Request, clusterList []string) []labelScope {
+	if !IsNamespaceTenancy() {
+		return []labelScope{{clusters: clusterList}}
+	}
+	// The namespaces are granted to the authenticated user, not to the forwarded one.
+	user, _ := GetUserInfo(req.Header.Get("X-Forwarded-Access-Token"))
+	return getUserScopes(clusterList, getUserNamespaces(user.Name, user.Groups))
 }
 
 // canAccessAllClusters check user have permission to access all clusters.
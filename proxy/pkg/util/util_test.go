@@ -142,10 +142,11 @@ func TestGetAllManagedClusterNames(t *testing.T) {
 	time.Sleep(time.Second)
 
 	InitAllManagedClusterNames()
+	SetAuthorizer(NewOpenShiftAuthorizer("http://127.0.0.1:3002"))
 	for _, c := range testCaseList {
 		allManagedClusterNames = c.clusters
 		req := newHTTPRequest()
-		ModifyMetricsQueryParams(req, "http://127.0.0.1:3002/")
+		ModifyMetricsQueryParams(req)
 		if req.URL.RawQuery != c.expected {
 			t.Errorf("case (%v) output: (%v) is not the expected: (%v)", c.name, req.URL.RawQuery, c.expected)
 		}